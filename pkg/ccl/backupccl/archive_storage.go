@@ -0,0 +1,87 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/errors"
+)
+
+// archiveStorage is the read-only base of the ExternalStorages which serve the
+// files of an archive, such as tarGzStorage and zipStorage, which embed it and
+// implement ReadFile. The files are listed and sized from an index of the
+// archive built when the storage is made, and can't be written or deleted.
+type archiveStorage struct {
+	// ExternalStorage is the store holding the archive.
+	cloud.ExternalStorage
+	archive string
+	// sizes maps the cleaned name of each file in the archive to its size.
+	sizes map[string]int64
+}
+
+// errNotInArchive returns the error reported for a file which isn't in the
+// archive.
+func (s *archiveStorage) errNotInArchive(basename string) error {
+	return errors.Wrapf(cloudimpl.ErrFileDoesNotExist,
+		"archive %s does not contain %s", s.archive, basename)
+}
+
+// Conf is part of the cloud.ExternalStorage interface. It returns an empty
+// configuration, from which no storage can be made, so that nothing mistakes
+// the store holding the archive for the files in it, e.g. to read them on
+// other nodes.
+func (s *archiveStorage) Conf() roachpb.ExternalStorage {
+	return roachpb.ExternalStorage{}
+}
+
+// WriteFile is part of the cloud.ExternalStorage interface.
+func (s *archiveStorage) WriteFile(_ context.Context, basename string, _ io.ReadSeeker) error {
+	return errors.Newf("cannot write %s: archive %s is read-only", basename, s.archive)
+}
+
+// ListFiles is part of the cloud.ExternalStorage interface.
+func (s *archiveStorage) ListFiles(_ context.Context, patternSuffix string) ([]string, error) {
+	if patternSuffix == "" {
+		return nil, errors.Newf("archive %s can only be listed with a pattern", s.archive)
+	}
+	pattern := path.Clean(patternSuffix)
+	var res []string
+	for name := range s.sizes {
+		matches, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Delete is part of the cloud.ExternalStorage interface.
+func (s *archiveStorage) Delete(_ context.Context, basename string) error {
+	return errors.Newf("cannot delete %s: archive %s is read-only", basename, s.archive)
+}
+
+// Size is part of the cloud.ExternalStorage interface.
+func (s *archiveStorage) Size(_ context.Context, basename string) (int64, error) {
+	size, ok := s.sizes[path.Clean(basename)]
+	if !ok {
+		return 0, s.errNotInArchive(basename)
+	}
+	return size, nil
+}
@@ -14,10 +14,13 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
@@ -64,7 +67,8 @@ func fetchPreviousBackups(
 // In addition, in this case that this backup is an incremental backup (either
 // explicitly, or due to the auto-append feature), it will resolve the
 // encryption options based on the base backup, as well as find all previous
-// backup manifests in the backup chain.
+// backup manifests in the backup chain, and whether the backup it is appended
+// to has an index of its layers which needs to be maintained once it's done.
 //
 // TODO(pbardea): Cleanup list for after stability
 //  - We shouldn't need to pass `to` and (`defaultURI`, `urisByLocalityKV`). We
@@ -86,6 +90,7 @@ func resolveDest(
 	string, /* chosenSuffix */
 	map[string]string, /* urisByLocalityKV */
 	[]string, /* prevBackupURIs */
+	bool, /* layersIndexed */
 	error,
 ) {
 	// chosenSuffix is the automatically chosen suffix within the collection path
@@ -93,18 +98,19 @@ func resolveDest(
 	var collectionURI string
 	var prevBackupURIs []string
 	var chosenSuffix string
+	var layersIndexed bool
 	var err error
 
 	if nested {
 		collectionURI, chosenSuffix, err = resolveBackupCollection(ctx, user, defaultURI,
 			appendToLatest, makeCloudStorage, endTime, subdir)
 		if err != nil {
-			return "", "", "", nil, nil, err
+			return "", "", "", nil, nil, false, err
 		}
 
 		defaultURI, urisByLocalityKV, err = getURIsByLocalityKV(to, chosenSuffix)
 		if err != nil {
-			return "", "", "", nil, nil, err
+			return "", "", "", nil, nil, false, err
 		}
 	}
 
@@ -113,27 +119,27 @@ func resolveDest(
 	} else {
 		defaultStore, err := makeCloudStorage(ctx, defaultURI, user)
 		if err != nil {
-			return "", "", "", nil, nil, err
+			return "", "", "", nil, nil, false, err
 		}
 		defer defaultStore.Close()
 		exists, err := containsManifest(ctx, defaultStore)
 		if err != nil {
-			return "", "", "", nil, nil, err
+			return "", "", "", nil, nil, false, err
 		}
 		if exists {
 			// The backup in the auto-append directory is the full backup.
 			prevBackupURIs = append(prevBackupURIs, defaultURI)
-			priors, err := findPriorBackupLocations(ctx, defaultStore)
+			var priors []string
+			priors, layersIndexed, err = findPriorBackupLocationsAndIndex(ctx, defaultStore)
 			for _, prior := range priors {
 				priorURI, err := url.Parse(defaultURI)
 				if err != nil {
-					return "", "", "", nil, nil, errors.Wrapf(err, "parsing default backup location %s", defaultURI)
+					return "", "", "", nil, nil, false, errors.Wrapf(err, "parsing default backup location %s", defaultURI)
 				}
-				priorURI.Path = path.Join(priorURI.Path, prior)
-				prevBackupURIs = append(prevBackupURIs, priorURI.String())
+				prevBackupURIs = append(prevBackupURIs, incrementalLayerURIs([]*url.URL{priorURI}, prior)...)
 			}
 			if err != nil {
-				return "", "", "", nil, nil, errors.Wrap(err, "finding previous backups")
+				return "", "", "", nil, nil, false, errors.Wrap(err, "finding previous backups")
 			}
 
 			// Pick a piece-specific suffix and update the destination path(s).
@@ -141,12 +147,128 @@ func resolveDest(
 			partName = path.Join(chosenSuffix, partName)
 			defaultURI, urisByLocalityKV, err = getURIsByLocalityKV(to, partName)
 			if err != nil {
-				return "", "", "", nil, nil, errors.Wrap(err, "adjusting backup destination to append new layer to existing backup")
+				return "", "", "", nil, nil, false, errors.Wrap(err, "adjusting backup destination to append new layer to existing backup")
 			}
 		}
 	}
 
-	return collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, nil
+	return collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, layersIndexed, nil
+}
+
+// ValidateAppendTarget checks that a backup starting at newStartTime can be
+// appended as an incremental layer to the backup in store, before anything is
+// written for it: the latest layer of the backup, or the full backup itself if
+// it has none, must end at newStartTime, and must have been taken by the same
+// cluster as the full backup. Otherwise the chain would only be found to be
+// unrestorable when restoring it.
+func ValidateAppendTarget(
+	ctx context.Context,
+	store cloud.ExternalStorage,
+	newStartTime hlc.Timestamp,
+	encryption *jobspb.BackupEncryptionOptions,
+) error {
+	base, err := readBackupManifestFromStore(ctx, store, encryption)
+	if err != nil {
+		return errors.Wrap(err, "reading the backup to append to")
+	}
+	latest, latestName := base, "full backup"
+	layers, err := findPriorBackupLocations(ctx, store)
+	if err != nil {
+		return err
+	}
+	if len(layers) > 0 {
+		latestName = layers[len(layers)-1]
+		latest, err = readBackupManifest(ctx, store, path.Join(latestName, backupManifestName), encryption)
+		if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+			// Layers written by 20.1 nodes and earlier use the old manifest name.
+			latest, err = readBackupManifest(ctx, store, path.Join(latestName, backupOldManifestName), encryption)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading the latest layer %s of the backup to append to", latestName)
+		}
+	}
+	if !latest.ClusterID.Equal(base.ClusterID) {
+		return errors.Newf("latest layer %s of the backup to append to belongs to cluster %s, "+
+			"but its full backup belongs to cluster %s", latestName, latest.ClusterID, base.ClusterID)
+	}
+	if !latest.EndTime.Equal(newStartTime) {
+		return errors.Newf("cannot append a backup starting at %s to a backup whose latest "+
+			"layer %s ends at %s", newStartTime, latestName, latest.EndTime)
+	}
+	return nil
+}
+
+// validateAppendChain checks that the chain of backups prevBackups, whose URIs
+// are prevURIs, can have a new layer appended to it: that each of its layers
+// starts where the previous one ends. It's the check ValidateAppendTarget makes,
+// for a chain which was already loaded, in which the new layer starts where the
+// latest one ends.
+func validateAppendChain(prevBackups []BackupManifest, prevURIs []string) error {
+	for i := 1; i < len(prevBackups); i++ {
+		if !prevBackups[i].StartTime.Equal(prevBackups[i-1].EndTime) {
+			return errors.Newf("cannot append to the backup: its layer %s starts at %s, but the "+
+				"layer before it ends at %s", RedactURIForErrorMessage(prevURIs[i]),
+				prevBackups[i].StartTime, prevBackups[i-1].EndTime)
+		}
+	}
+	return nil
+}
+
+// appendedBackupLayer returns the URI of the full backup into which the
+// backup at backupURI was automatically appended as an incremental layer, and
+// the name of the layer's manifest relative to it, if backupURI is such a
+// layer, i.e. if it is in a subdirectory named after the time of the backup.
+func appendedBackupLayer(backupURI string) (string, string, bool, error) {
+	u, err := url.Parse(backupURI)
+	if err != nil {
+		return "", "", false, err
+	}
+	layerPath := path.Clean(u.Path)
+	dayDir, timeDir := path.Split(layerPath)
+	dayDir = path.Base(dayDir)
+	if _, err := time.Parse(dateBasedIncFolderName, "/"+dayDir+"/"+timeDir); err != nil {
+		return "", "", false, nil
+	}
+	u.Path = path.Dir(path.Dir(layerPath))
+	return u.String(), path.Join(dayDir, timeDir, backupManifestName), true, nil
+}
+
+// maintainBackupLayersIndex updates the index of the layers of the full backup
+// into which the backup at backupURI was appended, if it was, adding the new
+// layer to it if backupLayersIndexEnabled is set and the cluster version is
+// BackupLayersIndex, and removing it otherwise, since it no longer lists every
+// layer. indexed is whether the full backup had an index when the backup was
+// planned; if it didn't and no index is to be added to, the full backup isn't
+// looked at at all.
+func maintainBackupLayersIndex(
+	ctx context.Context,
+	user security.SQLUsername,
+	st *cluster.Settings,
+	makeCloudStorage cloud.ExternalStorageFromURIFactory,
+	backupURI string,
+	indexed bool,
+) error {
+	enabled := backupLayersIndexEnabled.Get(&st.SV) &&
+		st.Version.IsActive(ctx, clusterversion.BackupLayersIndex)
+	if !enabled && !indexed {
+		return nil
+	}
+	baseURI, layer, ok, err := appendedBackupLayer(backupURI)
+	if err != nil || !ok {
+		return err
+	}
+	baseStore, err := makeCloudStorage(ctx, baseURI, user)
+	if err != nil {
+		return err
+	}
+	defer baseStore.Close()
+	if exists, err := containsManifest(ctx, baseStore); err != nil || !exists {
+		return err
+	}
+	if !enabled {
+		return removeBackupLayersIndex(ctx, baseStore)
+	}
+	return appendToBackupLayersIndex(ctx, baseStore, layer)
 }
 
 // getBackupManifests fetches the backup manifest from a list of backup URIs.
@@ -188,7 +310,7 @@ func getBackupManifests(
 		return nil, err
 	}
 
-	return manifests, nil
+	return inflateElidedDescriptors(manifests)
 }
 
 // getEncryptionFromBase retrieves the encryption options of a base backup. It
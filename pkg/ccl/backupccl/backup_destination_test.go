@@ -19,6 +19,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
@@ -28,6 +29,8 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -135,7 +138,7 @@ func TestBackupRestoreResolveDestination(t *testing.T) {
 					defaultDest, localitiesDest, err := getURIsByLocalityKV(to, "")
 					require.NoError(t, err)
 
-					collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, err := resolveDest(
+					collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, _, err := resolveDest(
 						ctx, security.RootUserName(),
 						false /* nested */, false, /* appendToLatest */
 						defaultDest, localitiesDest,
@@ -204,7 +207,7 @@ func TestBackupRestoreResolveDestination(t *testing.T) {
 
 					dest, localitiesDest, err := getURIsByLocalityKV(to, "")
 					require.NoError(t, err)
-					collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, err := resolveDest(
+					collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, _, err := resolveDest(
 						ctx, security.RootUserName(),
 						false /* nested */, false, /* appendToLatest */
 						dest, localitiesDest,
@@ -307,7 +310,7 @@ func TestBackupRestoreResolveDestination(t *testing.T) {
 
 					defaultCollection, localityCollections, err := getURIsByLocalityKV(collectionTo, "")
 					require.NoError(t, err)
-					collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, err := resolveDest(
+					collectionURI, defaultURI, chosenSuffix, urisByLocalityKV, prevBackupURIs, _, err := resolveDest(
 						ctx, security.RootUserName(),
 						true /* nested */, appendToLatest,
 						defaultCollection, localityCollections,
@@ -422,4 +425,279 @@ func TestBackupRestoreResolveDestination(t *testing.T) {
 	}
 }
 
+func TestBackupLayersIndex(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	user := security.RootUserName()
+	st := cluster.MakeTestingClusterSettings()
+	backupLayersIndexEnabled.Override(&st.SV, true)
+
+	// The layers are named after yesterday, so that the index is checked for
+	// unrecorded layers.
+	day := timeutil.Now().UTC().AddDate(0, 0, -1).Format("20060102")
+	const baseURI = "nodelocal://1/full?AUTH=implicit"
+	store, err := externalStorageFromURI(ctx, baseURI, user)
+	require.NoError(t, err)
+	defer store.Close()
+	writeFile := func(name string) {
+		require.NoError(t, store.WriteFile(ctx, name, bytes.NewReader([]byte("manifest"))))
+	}
+	appendLayerWith := func(st *cluster.Settings, layer string) {
+		// As when planning the backup, check whether there's an index before
+		// writing the layer.
+		_, indexed, err := findPriorBackupLocationsAndIndex(ctx, store)
+		require.NoError(t, err)
+		writeFile(layer + "/" + backupManifestName)
+		require.NoError(t, maintainBackupLayersIndex(ctx, user, st, externalStorageFromURI,
+			"nodelocal://1/full/"+layer+"?AUTH=implicit", indexed))
+	}
+	appendLayer := func(layer string) { appendLayerWith(st, layer) }
+	requireLayers := func(expected ...string) {
+		t.Helper()
+		locations, err := findPriorBackupLocations(ctx, store)
+		require.NoError(t, err)
+		require.Equal(t, expected, locations)
+		names, err := findPriorBackupNames(ctx, store)
+		require.NoError(t, err)
+		require.Len(t, names, len(expected))
+		for i := range expected {
+			require.Equal(t, expected[i]+"/"+backupManifestName, names[i])
+		}
+	}
+	requireIndexed := func(expected bool) {
+		t.Helper()
+		_, ok, _, err := readBackupLayersIndex(ctx, store)
+		require.NoError(t, err)
+		require.Equal(t, expected, ok)
+	}
+	requireNoIndex := func() {
+		t.Helper()
+		_, err := store.ReadFile(ctx, backupLayersIndexName)
+		require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+	}
+
+	// Layers appended before the full backup exists aren't indexed.
+	appendLayer(day + "/060000.00")
+	requireIndexed(false)
+	writeFile(backupManifestName)
+
+	// The index is seeded from a listing of the existing layers, and then only
+	// appended to.
+	appendLayer(day + "/070000.00")
+	requireIndexed(true)
+	requireLayers(day+"/060000.00", day+"/070000.00")
+
+	// An index missing a layer newer than those it lists is ignored, and is
+	// seeded from a listing again by the next layer.
+	writeFile(day + "/073000.00/" + backupManifestName)
+	requireIndexed(false)
+	requireLayers(day+"/060000.00", day+"/070000.00", day+"/073000.00")
+	appendLayer(day + "/080000.00")
+	requireIndexed(true)
+	requireLayers(day+"/060000.00", day+"/070000.00", day+"/073000.00", day+"/080000.00")
+
+	// An index whose last layer was removed is ignored.
+	require.NoError(t, store.Delete(ctx, day+"/080000.00/"+backupManifestName))
+	requireIndexed(false)
+	requireLayers(day+"/060000.00", day+"/070000.00", day+"/073000.00")
+
+	// As is a malformed index.
+	require.NoError(t, store.WriteFile(ctx, backupLayersIndexName, bytes.NewReader([]byte("LATEST\n"))))
+	requireIndexed(false)
+	requireLayers(day+"/060000.00", day+"/070000.00", day+"/073000.00")
+
+	// As is an index whose last layer is too old to check that it's up to date.
+	require.NoError(t, store.WriteFile(ctx, backupLayersIndexName,
+		bytes.NewReader([]byte("20201225/060000.00/"+backupManifestName+"\n"))))
+	writeFile("20201225/060000.00/" + backupManifestName)
+	requireIndexed(false)
+	require.NoError(t, store.Delete(ctx, "20201225/060000.00/"+backupManifestName))
+
+	// Backups which weren't appended to a full backup leave the index alone.
+	require.NoError(t, maintainBackupLayersIndex(ctx, user, st, externalStorageFromURI,
+		"nodelocal://1/full/other?AUTH=implicit", true /* indexed */))
+	require.NoError(t, maintainBackupLayersIndex(ctx, user, st, externalStorageFromURI,
+		"nodelocal://1/elsewhere/"+day+"/090000.00?AUTH=implicit", true /* indexed */))
+
+	// A layer appended while the index is disabled removes it.
+	appendLayer(day + "/090000.00")
+	requireIndexed(true)
+	backupLayersIndexEnabled.Override(&st.SV, false)
+	appendLayer(day + "/100000.00")
+	requireNoIndex()
+	requireLayers(day+"/060000.00", day+"/070000.00", day+"/073000.00",
+		day+"/090000.00", day+"/100000.00")
+
+	// Once it's removed, layers appended while the index is disabled don't look
+	// at the full backup at all.
+	noStorage := func(context.Context, string, security.SQLUsername) (cloud.ExternalStorage, error) {
+		return nil, errors.New("unexpected access to the full backup")
+	}
+	require.NoError(t, maintainBackupLayersIndex(ctx, user, st, noStorage,
+		"nodelocal://1/full/"+day+"/103000.00?AUTH=implicit", false /* indexed */))
+
+	// As does a layer appended before the cluster version allows the index.
+	backupLayersIndexEnabled.Override(&st.SV, true)
+	appendLayer(day + "/110000.00")
+	requireIndexed(true)
+	prev := clusterversion.ByKey(clusterversion.BackupLayersIndex - 1)
+	oldSt := cluster.MakeTestingClusterSettingsWithVersions(prev, prev, true /* initializeVersion */)
+	backupLayersIndexEnabled.Override(&oldSt.SV, true)
+	appendLayerWith(oldSt, day+"/120000.00")
+	requireNoIndex()
+}
+
+func TestValidateAppendTarget(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	st := cluster.MakeTestingClusterSettings()
+	store, err := externalStorageFromURI(ctx, "nodelocal://1/append?AUTH=implicit", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	clusterID := uuid.MakeV4()
+	writeLayer := func(filename string, cluster uuid.UUID, start, end int64) {
+		require.NoError(t, writeBackupManifest(ctx, st, store, filename, nil, /* encryption */
+			&BackupManifest{ClusterID: cluster, StartTime: makeTestTimestamp(start), EndTime: makeTestTimestamp(end)}))
+	}
+
+	// There is nothing to append to yet.
+	require.Error(t, ValidateAppendTarget(ctx, store, makeTestTimestamp(10), nil /* encryption */))
+
+	writeLayer(backupManifestName, clusterID, 0, 10)
+	require.NoError(t, ValidateAppendTarget(ctx, store, makeTestTimestamp(10), nil /* encryption */))
+	require.EqualError(t, ValidateAppendTarget(ctx, store, makeTestTimestamp(5), nil /* encryption */),
+		"cannot append a backup starting at 0.000000005,0 to a backup whose latest layer "+
+			"full backup ends at 0.000000010,0")
+
+	writeLayer("20201225/060000.00/"+backupManifestName, clusterID, 10, 20)
+	require.NoError(t, ValidateAppendTarget(ctx, store, makeTestTimestamp(20), nil /* encryption */))
+	require.Error(t, ValidateAppendTarget(ctx, store, makeTestTimestamp(10), nil /* encryption */))
+
+	// A layer taken by another cluster breaks the chain.
+	otherID := uuid.MakeV4()
+	writeLayer("20201225/070000.00/"+backupManifestName, otherID, 20, 30)
+	require.EqualError(t, ValidateAppendTarget(ctx, store, makeTestTimestamp(30), nil /* encryption */),
+		fmt.Sprintf("latest layer 20201225/070000.00 of the backup to append to belongs to cluster %s, "+
+			"but its full backup belongs to cluster %s", otherID, clusterID))
+}
+
+func TestValidateAppendChain(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	chain := []BackupManifest{
+		{EndTime: makeTestTimestamp(10)},
+		{StartTime: makeTestTimestamp(10), EndTime: makeTestTimestamp(20)},
+		{StartTime: makeTestTimestamp(20), EndTime: makeTestTimestamp(30)},
+	}
+	uris := []string{"nodelocal://1/full", "nodelocal://1/full/inc1", "nodelocal://1/full/inc2"}
+	require.NoError(t, validateAppendChain(chain, uris))
+	require.NoError(t, validateAppendChain(chain[:1], uris[:1]))
+
+	chain[2].StartTime = makeTestTimestamp(25)
+	require.EqualError(t, validateAppendChain(chain, uris),
+		"cannot append to the backup: its layer nodelocal://1/full/inc2 starts at "+
+			"0.000000025,0, but the layer before it ends at 0.000000020,0")
+}
+
+// pagedStorage lists the files of a store in pages of pageSize, in reverse
+// order if reversePages is set.
+type pagedStorage struct {
+	cloud.ExternalStorage
+	pageSize     int
+	reversePages bool
+}
+
+var _ cloud.PaginatedListStorage = pagedStorage{}
+
+func (s pagedStorage) ListFilesPaginated(
+	ctx context.Context, patternSuffix string, fn func(page []string) error,
+) error {
+	files, err := s.ListFiles(ctx, patternSuffix)
+	if err != nil {
+		return err
+	}
+	var pages [][]string
+	for len(files) > 0 {
+		n := s.pageSize
+		if n > len(files) {
+			n = len(files)
+		}
+		pages = append(pages, files[:n])
+		files = files[n:]
+	}
+	for i := range pages {
+		page := pages[i]
+		if s.reversePages {
+			page = pages[len(pages)-1-i]
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestFindPriorBackupsPaginated(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+
+	store, err := externalStorageFromURI(ctx, "nodelocal://1/full?AUTH=implicit", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	var expected []string
+	for _, layer := range []string{
+		"20201225/060000.00", "20201225/070000.00", "20201225/080000.00",
+		"20201226/060000.00", "20201226/070000.00",
+	} {
+		require.NoError(t, store.WriteFile(ctx, layer+"/"+backupManifestName, bytes.NewReader(nil)))
+		expected = append(expected, layer)
+	}
+
+	for _, pageSize := range []int{1, 2, 10} {
+		for _, reversePages := range []bool{false, true} {
+			t.Run(fmt.Sprintf("page=%d,reverse=%t", pageSize, reversePages), func(t *testing.T) {
+				paged := pagedStorage{ExternalStorage: store, pageSize: pageSize, reversePages: reversePages}
+				locations, err := findPriorBackupLocations(ctx, paged)
+				require.NoError(t, err)
+				require.Equal(t, expected, locations)
+				names, err := findPriorBackupNames(ctx, paged)
+				require.NoError(t, err)
+				require.Len(t, names, len(expected))
+				for i := range expected {
+					require.Equal(t, expected[i]+"/"+backupManifestName, names[i])
+				}
+			})
+		}
+	}
+
+	// An error listing a page is returned.
+	_, err = findPriorBackupLocations(ctx, failingListStorage{store})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "listing failed")
+}
+
+// failingListStorage fails to list any page of files.
+type failingListStorage struct {
+	cloud.ExternalStorage
+}
+
+func (s failingListStorage) ListFilesPaginated(
+	context.Context, string, func(page []string) error,
+) error {
+	return errors.New("listing failed")
+}
+
 // TODO(pbardea): Add tests for resolveBackupCollection.
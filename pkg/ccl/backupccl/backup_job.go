@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -284,6 +285,10 @@ func backup(
 		return RowCount{}, errors.Wrapf(err, "exporting %d ranges", errors.Safe(numTotalSpans))
 	}
 
+	// The metadata of a large backup can take a while to upload, so log how long
+	// each of its files took.
+	writeOpts := manifestWriteOptions{progress: logManifestUploads(ctx)}
+
 	backupID := uuid.MakeV4()
 	backupManifest.ID = backupID
 	// Write additional partial descriptors to each node for partitioned backups.
@@ -315,17 +320,30 @@ func backup(
 					return err
 				}
 				defer store.Close()
-				return writeBackupPartitionDescriptor(ctx, store, filename, encryption, &desc)
+				return writeBackupPartitionDescriptorWithOptions(
+					ctx, store, filename, encryption, &desc, writeOpts)
 			}(); err != nil {
 				return RowCount{}, err
 			}
 		}
 	}
 
-	if err := writeBackupManifest(ctx, settings, defaultStore, backupManifestName, encryption, backupManifest); err != nil {
+	// The checkpointed manifest keeps the full descriptor set so that a resumed
+	// job can still use it, but the final manifest only needs the delta.
+	manifestToWrite := backupManifest
+	if backupManifest.DescriptorsElided {
+		elided := *backupManifest
+		elided.Descriptors = nil
+		manifestToWrite = &elided
+	}
+	if err := writeFinalBackupManifest(
+		ctx, settings, defaultStore, storageByLocalityKV, makeExternalStorage, encryption, manifestToWrite,
+		writeOpts,
+	); err != nil {
 		return RowCount{}, err
 	}
 	var tableStatistics []*stats.TableStatisticProto
+	tableVersions := make(map[descpb.ID]descpb.DescriptorVersion)
 	for i := range backupManifest.Descriptors {
 		if tableDesc := descpb.TableFromDescriptor(&backupManifest.Descriptors[i], hlc.Timestamp{}); tableDesc != nil {
 			// Collect all the table stats for this table.
@@ -336,19 +354,66 @@ func backup(
 			for _, stat := range tableStatisticsAcc {
 				tableStatistics = append(tableStatistics, &stat.TableStatisticProto)
 			}
+			if len(tableStatisticsAcc) > 0 {
+				tableVersions[tableDesc.GetID()] = tableDesc.Version
+			}
 		}
 	}
 	statsTable := StatsTable{
-		Statistics: tableStatistics,
+		Statistics:    tableStatistics,
+		TableVersions: tableVersions,
 	}
 
-	if err := writeTableStatistics(ctx, defaultStore, backupStatisticsFileName, encryption, &statsTable); err != nil {
+	if err := writeTableStatisticsWithOptions(
+		ctx, defaultStore, backupStatisticsFileName, encryption, &statsTable, writeOpts,
+	); err != nil {
 		return RowCount{}, err
 	}
 
 	return backupManifest.EntryCounts, nil
 }
 
+// writeFinalBackupManifest writes the final manifest of a backup to
+// defaultStore and, if mirrorManifestToLocalities is set, mirrors it to the
+// store of each locality of the backup, with opts. Failing to mirror it doesn't
+// fail the backup, whose manifest is committed once it is written to
+// defaultStore.
+func writeFinalBackupManifest(
+	ctx context.Context,
+	settings *cluster.Settings,
+	defaultStore cloud.ExternalStorage,
+	storageByLocalityKV map[string]*roachpb.ExternalStorage,
+	makeExternalStorage cloud.ExternalStorageFactory,
+	encryption *jobspb.BackupEncryptionOptions,
+	manifest *BackupManifest,
+	opts manifestWriteOptions,
+) error {
+	if len(storageByLocalityKV) == 0 || !mirrorManifestToLocalities.Get(&settings.SV) {
+		return writeBackupManifestWithOptions(
+			ctx, settings, defaultStore, backupManifestName, encryption, manifest, opts)
+	}
+	localities := make([]string, 0, len(storageByLocalityKV))
+	for kv := range storageByLocalityKV {
+		localities = append(localities, kv)
+	}
+	sort.Strings(localities)
+	stores := []cloud.ExternalStorage{defaultStore}
+	for _, kv := range localities {
+		store, err := makeExternalStorage(ctx, *storageByLocalityKV[kv])
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		stores = append(stores, store)
+	}
+	err := multiWriteBackupManifest(ctx, settings, stores, backupManifestName, encryption, manifest, opts)
+	if errors.Is(err, errBackupManifestMirror) {
+		log.Warningf(ctx, "backup manifest not mirrored to every locality: %+v", err)
+		return nil
+	}
+	return err
+}
+
 func (b *backupResumer) releaseProtectedTimestamp(
 	ctx context.Context, txn *kv.Txn, pts protectedts.Storage,
 ) error {
@@ -501,6 +566,17 @@ func (b *backupResumer) Resume(
 		}
 	}
 
+	// If this is an incremental backup that was automatically appended to a full
+	// backup, record it in the index of that backup's layers. The backup is
+	// already complete at this point, and without an up to date index its layers
+	// are found by listing them, so failing to do so doesn't fail the backup.
+	if !backupManifest.StartTime.IsEmpty() {
+		if err := maintainBackupLayersIndex(ctx, p.User(), p.ExecCfg().Settings,
+			p.ExecCfg().DistSQLSrv.ExternalStorageFromURI, details.URI, details.LayersIndexed); err != nil {
+			log.Warningf(ctx, "backup layers index not updated: %+v", err)
+		}
+	}
+
 	resultsCh <- tree.Datums{
 		tree.NewDInt(tree.DInt(*b.job.ID())),
 		tree.NewDString(string(jobs.StatusSucceeded)),
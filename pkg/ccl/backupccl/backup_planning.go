@@ -21,6 +21,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/build"
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
 	"github.com/cockroachdb/cockroach/pkg/ccl/utilccl"
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/featureflag"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
@@ -867,7 +868,7 @@ func backupPlanHook(
 
 		// TODO(pbardea): Refactor (defaultURI and urisByLocalityKV) pairs into a
 		// backupDestination struct.
-		collectionURI, defaultURI, resolvedSubdir, urisByLocalityKV, prevs, err :=
+		collectionURI, defaultURI, resolvedSubdir, urisByLocalityKV, prevs, layersIndexed, err :=
 			resolveDest(ctx, p.User(), backupStmt.Nested, backupStmt.AppendToLatest, defaultURI,
 				urisByLocalityKV, makeCloudStorage, endTime, to, incrementalFrom, subdir)
 		if err != nil {
@@ -903,6 +904,11 @@ func backupPlanHook(
 				return err
 			}
 			startTime = prevBackups[len(prevBackups)-1].EndTime
+			if len(incrementalFrom) == 0 {
+				if err := validateAppendChain(prevBackups, prevs); err != nil {
+					return err
+				}
+			}
 		}
 
 		var priorIDs map[descpb.ID]descpb.ID
@@ -1055,11 +1061,19 @@ func backupPlanHook(
 			IntroducedSpans:     newSpans,
 			FormatVersion:       BackupFormatDescriptorTrackingVersion,
 			BuildInfo:           build.GetInfo(),
+			ClusterVersion:      p.ExecCfg().Settings.Version.ActiveVersion(ctx).Version,
 			ClusterID:           p.ExecCfg().ClusterID(),
 			StatisticsFilenames: statsFiles,
 			DescriptorCoverage:  backupStmt.Coverage(),
 		}
 
+		// Nodes which predate elided descriptors would read them as an empty
+		// descriptor set, so they are only elided once every node knows of them.
+		if len(prevBackups) > 0 && elideUnchangedDescriptors.Get(&p.ExecCfg().Settings.SV) &&
+			p.ExecCfg().Settings.Version.IsActive(ctx, clusterversion.BackupElidedDescriptors) {
+			elideDescriptors(&backupManifest, &prevBackups[len(prevBackups)-1])
+		}
+
 		// Sanity check: re-run the validation that RESTORE will do, but this time
 		// including this backup, to ensure that the this backup plus any previous
 		// backups does cover the interval expected.
@@ -1107,6 +1121,27 @@ func backupPlanHook(
 			baseURI = defaultURI
 		}
 
+		// Incremental layers which can refer to the location of the base backup
+		// compress their manifests with a dictionary stored next to it, once
+		// every node can decompress them.
+		if len(prevBackups) > 0 && manifestDictionaryEnabled.Get(&p.ExecCfg().Settings.SV) &&
+			p.ExecCfg().Settings.Version.IsActive(ctx, clusterversion.BackupManifestDictionary) {
+			if dictPath, ok := manifestDictionaryPath(prevs[0], defaultURI); ok {
+				if err := func() error {
+					baseStore, err := makeCloudStorage(ctx, prevs[0], p.User())
+					if err != nil {
+						return err
+					}
+					defer baseStore.Close()
+					return writeManifestDictionaryIfNotExists(
+						ctx, p.ExecCfg().Settings, baseStore, encryptionOptions, &prevBackups[0])
+				}(); err != nil {
+					return errors.Wrap(err, "writing backup manifest compression dictionary")
+				}
+				backupManifest.DictionaryPath = dictPath
+			}
+		}
+
 		// Write backup manifest into a temporary checkpoint file.
 		// This accomplishes 2 purposes:
 		//  1. Persists large state needed for backup job completion.
@@ -1131,6 +1166,7 @@ func backupPlanHook(
 			EncryptionOptions: encryptionOptions,
 			EncryptionInfo:    encryptionInfo,
 			CollectionURI:     collectionURI,
+			LayersIndexed:     layersIndexed,
 		}
 		if len(spans) > 0 && p.ExecCfg().Codec.ForSystemTenant() {
 			protectedtsID := uuid.MakeV4()
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/concurrency/lock"
@@ -26,6 +27,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
@@ -53,8 +55,30 @@ var (
 		time.Second*5,
 		settings.NonNegativeDuration,
 	)
+	// inlineFileSizeThreshold lets backups of many small tables store most of
+	// their files in the manifest, rather than as an object each, since restores
+	// from such backups are dominated by the round-trips to fetch the objects.
+	// Nodes running older versions can't restore the inline files, so files are
+	// only inlined once the cluster version is BackupInlineFiles. The inlined files
+	// are held in memory along with the manifest, so their size is bounded.
+	inlineFileSizeThreshold = settings.RegisterByteSizeSetting(
+		"bulkio.backup.inline_file_size_threshold",
+		"size below which files written by BACKUP are stored in the backup manifest instead of "+
+			"as objects of their own; 0 disables inlining",
+		0,
+		func(v int64) error {
+			if v < 0 || v > maxInlineFileSize {
+				return errors.Newf("inline file size threshold must be between 0 and %s",
+					humanizeutil.IBytes(maxInlineFileSize))
+			}
+			return nil
+		},
+	)
 )
 
+// maxInlineFileSize is the largest value of inlineFileSizeThreshold.
+const maxInlineFileSize = 1 << 20
+
 // TODO(pbardea): It would be nice if we could add some DistSQL processor tests
 // we would probably want to have a mock cloudStorage object that we could
 // verify with.
@@ -143,6 +167,10 @@ func runBackupProcessor(
 	//  *2). See #49798.
 	numSenders := int(kvserver.ExportRequestsLimit.Get(&settings.SV)) * 2
 	targetFileSize := storageccl.ExportRequestTargetFileSize.Get(&settings.SV)
+	inlineThreshold := inlineFileSizeThreshold.Get(&settings.SV)
+	if !settings.Version.IsActive(ctx, clusterversion.BackupInlineFiles) {
+		inlineThreshold = 0
+	}
 
 	// For all backups, partitioned or not, the main BACKUP manifest is stored at
 	// details.URI.
@@ -186,6 +214,7 @@ func runBackupProcessor(
 					MVCCFilter:                          spec.MVCCFilter,
 					Encryption:                          spec.Encryption,
 					TargetFileSize:                      targetFileSize,
+					InlineFileSizeThreshold:             inlineThreshold,
 				}
 
 				// If we're doing re-attempts but are not yet in the priority regime,
@@ -257,6 +286,9 @@ func runBackupProcessor(
 						EntryCounts: countRows(file.Exported, spec.PKIDs),
 						LocalityKV:  file.LocalityKV,
 					}
+					if file.Inline {
+						f.InlineData = file.SST
+					}
 					if span.start != spec.BackupStartTime {
 						f.StartTime = span.start
 						f.EndTime = span.end
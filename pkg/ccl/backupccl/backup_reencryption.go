@@ -0,0 +1,307 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/errors"
+)
+
+// ReencryptBackupMetadata re-encrypts the backup in stores, which was encrypted
+// with the passphrase of oldEnc, with the passphrase of newEnc, so that the
+// passphrase of a backup can be changed without taking it again. stores are the
+// locations of the backup, the first of which must be its default locality, as
+// for a restore. The layers appended to the backup are re-encrypted too; those
+// taken into other locations must be re-encrypted on their own.
+//
+// The key of newEnc must be derived from the new passphrase with the salt
+// recorded in the backup's ENCRYPTION-INFO file, which isn't encrypted and is
+// left as is.
+//
+// The metadata files of the backup, i.e. its manifests and their compression
+// dictionary, partition descriptors and statistics, are decrypted with the old
+// key as they are when the backup is read and encrypted again with the new one.
+// Unlike KMS-encrypted backups, whose files are encrypted with a data key that
+// is only wrapped by the KMS, passphrase-encrypted backups have no data key:
+// their data files are encrypted with the passphrase's key too, so they are
+// re-encrypted as well, which rewrites the whole backup. Backups whose files
+// are inlined in their manifests aren't supported.
+//
+// The files are rewritten in place, one at a time, with the manifest of each
+// layer last. The compression dictionary shared by the incremental layers is
+// rewritten after all of them, since each of their manifests is read with it. A
+// failed attempt leaves some of them encrypted with the new key,
+// which a retry skips, so it must be retried with the same keys until it
+// succeeds.
+func ReencryptBackupMetadata(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	oldEnc, newEnc *jobspb.BackupEncryptionOptions,
+) error {
+	if len(stores) == 0 {
+		return errors.New("no backup locations to re-encrypt")
+	}
+	if oldEnc == nil || newEnc == nil ||
+		oldEnc.Mode != jobspb.EncryptionMode_Passphrase || newEnc.Mode != jobspb.EncryptionMode_Passphrase {
+		return errors.New("only a backup encrypted with a passphrase can be re-encrypted, with another passphrase")
+	}
+	layers, err := findPriorBackupLocations(ctx, stores[0])
+	if err != nil {
+		if !errors.Is(err, cloudimpl.ErrListingUnsupported) {
+			return err
+		}
+		log.Warningf(ctx, "storage sink %T does not support listing, only re-encrypting the base backup",
+			stores[0])
+		layers = nil
+	}
+	r := backupReencrypter{oldEnc: oldEnc, newEnc: newEnc}
+	dicts := make(map[string]struct{})
+	for _, dir := range append([]string{""}, layers...) {
+		dict, err := r.reencryptLayer(ctx, stores, dir)
+		if err != nil {
+			if dir == "" {
+				return errors.Wrap(err, "re-encrypting base backup")
+			}
+			return errors.Wrapf(err, "re-encrypting backup layer %s", dir)
+		}
+		if dict != "" {
+			dicts[dict] = struct{}{}
+		}
+	}
+	for dict := range dicts {
+		if _, err := r.reencryptFile(ctx, stores[0], dict, false); err != nil {
+			return errors.Wrap(err, "re-encrypting backup manifest compression dictionary")
+		}
+	}
+	return nil
+}
+
+// backupReencrypter re-encrypts the files of a backup from one key to another.
+type backupReencrypter struct {
+	oldEnc, newEnc *jobspb.BackupEncryptionOptions
+}
+
+// reencryptLayer re-encrypts the layer of the backup in the directory dir of
+// stores, except for the compression dictionary of its manifest, if any, whose
+// path in the first of stores it returns.
+func (r backupReencrypter) reencryptLayer(
+	ctx context.Context, stores []cloud.ExternalStorage, dir string,
+) (string, error) {
+	manifestName := path.Join(dir, backupManifestName)
+	if _, err := stores[0].Size(ctx, manifestName); errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+		manifestName = path.Join(dir, backupOldManifestName)
+	}
+	m, dict, err := r.readManifest(ctx, stores[0], manifestName)
+	if err != nil {
+		return "", err
+	}
+	for i := range m.Files {
+		if len(m.Files[i].InlineData) > 0 {
+			return "", errors.Newf("cannot re-encrypt backup with inline file %s", m.Files[i].Path)
+		}
+	}
+
+	partitionLocalities := make(map[string]struct{})
+	for _, filename := range m.PartitionDescriptorFilenames {
+		filename = path.Join(dir, filename)
+		found := false
+		for _, store := range stores {
+			desc, err := readBackupPartitionDescriptor(ctx, store, filename, r.oldEnc)
+			if err != nil && !errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+				var newErr error
+				if desc, newErr = readBackupPartitionDescriptor(ctx, store, filename, r.newEnc); newErr == nil {
+					err = nil
+				}
+			}
+			if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+				continue
+			} else if err != nil {
+				return "", errors.Wrapf(err, "reading backup partition descriptor %s", filename)
+			}
+			for i := range desc.Files {
+				if _, err := r.reencryptFile(ctx, store, path.Join(dir, desc.Files[i].Path), false); err != nil {
+					return "", err
+				}
+			}
+			if _, err := r.reencryptFile(ctx, store, filename, false); err != nil {
+				return "", err
+			}
+			partitionLocalities[desc.LocalityKV] = struct{}{}
+			found = true
+			break
+		}
+		if !found {
+			return "", errors.Errorf("expected manifest %s not found in backup locations", filename)
+		}
+	}
+
+	// The main manifest lists the files of every locality, of which those of
+	// the partitions were already re-encrypted.
+	for i := range m.Files {
+		if _, ok := partitionLocalities[m.Files[i].LocalityKV]; ok {
+			continue
+		}
+		if _, err := r.reencryptFile(ctx, stores[0], path.Join(dir, m.Files[i].Path), false); err != nil {
+			return "", err
+		}
+	}
+
+	// The tables backed up together share a statistics file.
+	statsFiles := make(map[string]struct{}, len(m.StatisticsFilenames))
+	for _, filename := range m.StatisticsFilenames {
+		statsFiles[filename] = struct{}{}
+	}
+	for filename := range statsFiles {
+		if _, err := r.reencryptFile(ctx, stores[0], path.Join(dir, filename), false); err != nil {
+			return "", err
+		}
+	}
+	if len(statsFiles) == 0 {
+		// Backups taken before the statistics were split by table have a single
+		// statistics file, if any.
+		if _, err := r.reencryptFile(ctx, stores[0], path.Join(dir, backupStatisticsFileName), true); err != nil {
+			return "", err
+		}
+	}
+
+	return dict, r.reencryptManifest(ctx, stores[0], manifestName)
+}
+
+// readManifest reads the manifest filename in store, decrypted with either key
+// in case an earlier attempt already re-encrypted it, and returns it along with
+// the path of its compression dictionary in store, if any, which is decrypted
+// with either key too. The descriptors are returned as stored.
+func (r backupReencrypter) readManifest(
+	ctx context.Context, store cloud.ExternalStorage, filename string,
+) (BackupManifest, string, error) {
+	descBytes, err := r.decryptFile(ctx, store, filename)
+	if err != nil {
+		return BackupManifest{}, "", err
+	}
+	var dictName string
+	if bytes.HasPrefix(descBytes, dictionaryCompressionPrefix) {
+		dictPath, _, _, err := decodeDictionaryCompressedHeader(descBytes)
+		if err != nil {
+			return BackupManifest{}, "", err
+		}
+		dictName = path.Join(path.Dir(filename), dictPath)
+		if strings.HasPrefix(dictName, "../") {
+			return BackupManifest{}, "", errors.Newf(
+				"compression dictionary %s of manifest %s is outside of the backup location", dictPath, filename)
+		}
+		dict, err := r.decryptFile(ctx, store, dictName)
+		if err != nil {
+			return BackupManifest{}, "", errors.Wrap(err, "reading backup manifest compression dictionary")
+		}
+		descBytes, err = decompressDataWithDictionary(descBytes, dict, decompressedManifestSizeLimit(store))
+		if err != nil {
+			return BackupManifest{}, "", errors.Wrap(err, "decompressing backup manifest")
+		}
+	} else if http.DetectContentType(descBytes) == ZipType {
+		descBytes, err = decompressDataLimit(descBytes, decompressedManifestSizeLimit(store))
+		if err != nil {
+			return BackupManifest{}, "", errors.Wrap(err, "decompressing backup manifest")
+		}
+	}
+	var m BackupManifest
+	if err := protoutil.Unmarshal(descBytes, &m); err != nil {
+		return BackupManifest{}, "", err
+	}
+	return m, dictName, nil
+}
+
+// reencryptManifest re-encrypts the manifest filename in store and replaces
+// its checksum. The checksum is deleted before the manifest is rewritten, so
+// that a failure in between leaves a manifest which can still be read.
+func (r backupReencrypter) reencryptManifest(
+	ctx context.Context, store cloud.ExternalStorage, filename string,
+) error {
+	checksumName := filename + backupManifestChecksumSuffix
+	if _, err := store.Size(ctx, checksumName); err == nil {
+		if err := store.Delete(ctx, checksumName); err != nil {
+			return errors.Wrapf(err, "deleting %s", checksumName)
+		}
+	} else if !errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+		return err
+	}
+	ciphertext, err := r.reencryptFile(ctx, store, filename, false)
+	if err != nil {
+		return err
+	}
+	checksum, err := getChecksum(ciphertext)
+	if err != nil {
+		return errors.Wrap(err, "calculating checksum of manifest")
+	}
+	return writeMetadataFile(ctx, store, checksumName, checksum, manifestWriteOptions{})
+}
+
+// decryptFile returns the contents of filename in store, decrypted with the old
+// key, or with the new one if the file was already re-encrypted.
+func (r backupReencrypter) decryptFile(
+	ctx context.Context, store cloud.ExternalStorage, filename string,
+) ([]byte, error) {
+	data, err := readFileResumable(ctx, store, filename, nil /* peek */)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, _, err := r.decrypt(data, filename)
+	return plaintext, err
+}
+
+// decrypt decrypts data, the contents of filename, with the old key, or with
+// the new one if the file was already re-encrypted, in which case it returns
+// true.
+func (r backupReencrypter) decrypt(data []byte, filename string) ([]byte, bool, error) {
+	plaintext, err := storageccl.DecryptFile(data, r.oldEnc.Key)
+	if err == nil {
+		return plaintext, false, nil
+	}
+	if plaintext, newErr := storageccl.DecryptFile(data, r.newEnc.Key); newErr == nil {
+		return plaintext, true, nil
+	}
+	return nil, false, errors.Wrapf(err, "decrypting %s", filename)
+}
+
+// reencryptFile rewrites filename in store, encrypted with the old key,
+// encrypted with the new key instead, unless it already is, and returns its new
+// contents. If optional is set, a file which doesn't exist is skipped.
+func (r backupReencrypter) reencryptFile(
+	ctx context.Context, store cloud.ExternalStorage, filename string, optional bool,
+) ([]byte, error) {
+	data, err := readFileResumable(ctx, store, filename, nil /* peek */)
+	if err != nil {
+		if optional && errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "reading %s", filename)
+	}
+	plaintext, done, err := r.decrypt(data, filename)
+	if err != nil || done {
+		return data, err
+	}
+	ciphertext, err := storageccl.EncryptFile(plaintext, r.newEnc.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMetadataFile(ctx, store, filename, ciphertext, manifestWriteOptions{}); err != nil {
+		return nil, errors.Wrapf(err, "writing %s", filename)
+	}
+	return ciphertext, nil
+}
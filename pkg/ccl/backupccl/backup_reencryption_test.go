@@ -0,0 +1,138 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReencryptBackupMetadata(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	var stores []cloud.ExternalStorage
+	for _, uri := range []string{"nodelocal://1/backup", "nodelocal://1/backup-east"} {
+		store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+		require.NoError(t, err)
+		defer store.Close()
+		stores = append(stores, store)
+	}
+
+	salt, err := storageccl.GenerateSalt()
+	require.NoError(t, err)
+	oldEnc := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("old"), salt),
+	}
+	newEnc := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("new"), salt),
+	}
+
+	writeData := func(store cloud.ExternalStorage, filename string) {
+		data, err := storageccl.EncryptFile([]byte(filename), oldEnc.Key)
+		require.NoError(t, err)
+		require.NoError(t, store.WriteFile(ctx, filename, bytes.NewReader(data)))
+	}
+	// The manifests of the incremental layers are compressed with the dictionary
+	// at the root of the backup, which they all share.
+	dirs := []string{"", "20201231/000000.00/", "20201231/010000.00/"}
+	settings := cluster.MakeTestingClusterSettings()
+	for _, dir := range dirs {
+		defaultFile, eastFile := makeTestFile("a", "b"), makeTestFile("b", "c")
+		eastFile.LocalityKV = "region=east"
+		eastDesc := BackupPartitionDescriptor{
+			LocalityKV: eastFile.LocalityKV, Files: []BackupManifest_File{eastFile},
+		}
+		const partitionFilename = backupPartitionDescriptorPrefix + "_region=east"
+		require.NoError(t, writeBackupPartitionDescriptor(ctx, stores[1], dir+partitionFilename,
+			oldEnc, &eastDesc))
+		require.NoError(t, writeTableStatistics(ctx, stores[0], dir+backupStatisticsFileName+"-52",
+			oldEnc, &StatsTable{}))
+		writeData(stores[0], dir+defaultFile.Path)
+		writeData(stores[1], dir+eastFile.Path)
+		m := BackupManifest{
+			Descriptors:                  []descpb.Descriptor{makeTestTableDesc(52, 1)},
+			Files:                        []BackupManifest_File{defaultFile, eastFile},
+			LocalityKVs:                  []string{eastFile.LocalityKV},
+			PartitionDescriptorFilenames: []string{partitionFilename},
+			StatisticsFilenames:          map[descpb.ID]string{52: backupStatisticsFileName + "-52"},
+		}
+		if dir != "" {
+			require.NoError(t, writeManifestDictionaryIfNotExists(ctx, settings, stores[0], oldEnc, &m))
+			m.DictionaryPath = "../../" + backupManifestDictionaryName
+		}
+		require.NoError(t, writeBackupManifest(ctx, settings, stores[0], dir+backupManifestName, oldEnc, &m))
+	}
+
+	checkEncryptedWith := func(enc, otherEnc *jobspb.BackupEncryptionOptions) {
+		t.Helper()
+		_, err := readManifestDictionary(ctx, stores[0], backupManifestDictionaryName, enc, nil /* dataKeys */)
+		require.NoError(t, err)
+		_, err = readManifestDictionary(ctx, stores[0], backupManifestDictionaryName, otherEnc, nil /* dataKeys */)
+		require.Error(t, err)
+		for _, dir := range dirs {
+			m, err := readBackupManifest(ctx, stores[0], dir+backupManifestName, enc)
+			require.NoError(t, err)
+			require.Len(t, m.Files, 2)
+			_, err = readBackupManifest(ctx, stores[0], dir+backupManifestName, otherEnc)
+			require.Error(t, err)
+
+			desc, err := readBackupPartitionDescriptor(ctx, stores[1], dir+m.PartitionDescriptorFilenames[0], enc)
+			require.NoError(t, err)
+			require.Equal(t, "region=east", desc.LocalityKV)
+			_, err = readTableStatistics(ctx, stores[0], dir+m.StatisticsFilenames[52], enc, nil /* dataKeys */)
+			require.NoError(t, err)
+			_, err = readTableStatistics(ctx, stores[0], dir+m.StatisticsFilenames[52], otherEnc, nil /* dataKeys */)
+			require.Error(t, err)
+
+			for i, store := range stores {
+				filename := dir + m.Files[i].Path
+				r, err := store.ReadFile(ctx, filename)
+				require.NoError(t, err)
+				data, err := ioutil.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				plaintext, err := storageccl.DecryptFile(data, enc.Key)
+				require.NoError(t, err)
+				require.Equal(t, filename, string(plaintext))
+				_, err = storageccl.DecryptFile(data, otherEnc.Key)
+				require.Error(t, err)
+			}
+		}
+	}
+	checkEncryptedWith(oldEnc, newEnc)
+
+	require.NoError(t, ReencryptBackupMetadata(ctx, stores, oldEnc, newEnc))
+	checkEncryptedWith(newEnc, oldEnc)
+
+	// Re-encrypting again, as a retry would, skips the re-encrypted files.
+	require.NoError(t, ReencryptBackupMetadata(ctx, stores, oldEnc, newEnc))
+	checkEncryptedWith(newEnc, oldEnc)
+
+	// Only backups encrypted with a passphrase can be re-encrypted.
+	kmsEnc := &jobspb.BackupEncryptionOptions{Mode: jobspb.EncryptionMode_KMS}
+	require.Error(t, ReencryptBackupMetadata(ctx, stores, kmsEnc, newEnc))
+	require.Error(t, ReencryptBackupMetadata(ctx, stores, nil /* oldEnc */, newEnc))
+	require.Error(t, ReencryptBackupMetadata(ctx, nil /* stores */, oldEnc, newEnc))
+}
@@ -3786,7 +3786,7 @@ func TestRestoreAsOfSystemTime(t *testing.T) {
 		}
 
 		sqlDB.ExpectErr(
-			t, "invalid RESTORE timestamp",
+			t, "invalid RESTORE timestamp: requested time .* is after the most recent backup at",
 			fmt.Sprintf(`RESTORE data.* FROM $1 AS OF SYSTEM TIME %s WITH into_db='err'`, after),
 			latestBackup,
 		)
@@ -4284,7 +4284,7 @@ func TestEncryptedBackup(t *testing.T) {
 			sqlDB.ExpectErr(t, expectedShowError,
 				fmt.Sprintf(`SHOW BACKUP $1 WITH %s`, incorrectEncryptionOption), backupLoc1)
 			sqlDB.ExpectErr(t,
-				`file appears encrypted -- try specifying one of "encryption_passphrase" or "kms"`,
+				`this backup is encrypted; specify one of "encryption_passphrase" or "kms" to decrypt it`,
 				`SHOW BACKUP $1`, backupLoc1)
 			sqlDB.ExpectErr(t, `could not find or read encryption information`,
 				fmt.Sprintf(`SHOW BACKUP $1 WITH %s`, encryptionOption), plainBackupLoc1)
@@ -4293,6 +4293,14 @@ func TestEncryptedBackup(t *testing.T) {
 				encryptionOption), backupLoc1, backupLoc2, backupLoc1inc, backupLoc2inc)
 
 			sqlDB.CheckQueryResults(t, `SHOW EXPERIMENTAL_FINGERPRINTS FROM TABLE neverappears.neverappears`, before)
+
+			// Restoring again with the files decrypted concurrently yields the same
+			// data.
+			sqlDB.Exec(t, `SET CLUSTER SETTING bulkio.restore.parallel_decryption.enabled = true`)
+			sqlDB.Exec(t, `DROP DATABASE neverappears CASCADE`)
+			sqlDB.Exec(t, fmt.Sprintf(`RESTORE DATABASE neverappears FROM ($1, $2), ($3, $4) WITH %s`,
+				encryptionOption), backupLoc1, backupLoc2, backupLoc1inc, backupLoc2inc)
+			sqlDB.CheckQueryResults(t, `SHOW EXPERIMENTAL_FINGERPRINTS FROM TABLE neverappears.neverappears`, before)
 		})
 	}
 }
@@ -5563,6 +5571,74 @@ func TestBackupRestoreSubsetCreatedStats(t *testing.T) {
 	sqlDB.CheckQueryResults(t, getStatsQuery(`"data 2".foo`), [][]string{})
 }
 
+func TestRestoreSkipStatistics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const numAccounts = 1
+	_, _, sqlDB, dir, cleanupFn := BackupRestoreTestSetup(t, singleNode, numAccounts, InitManualReplication)
+	defer cleanupFn()
+
+	sqlDB.Exec(t, `SET CLUSTER SETTING sql.stats.automatic_collection.enabled=false`)
+	injectStats(t, sqlDB, "data.bank", "id")
+	sqlDB.Exec(t, `BACKUP data.bank TO $1`, LocalFoo)
+
+	// Replace the statistics with a file which can't be read, so that the
+	// restore fails if it reads it at all.
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "foo", backupStatisticsFileName), []byte("not statistics"), 0644))
+
+	sqlDB.Exec(t, `CREATE DATABASE "data 2"`)
+	sqlDB.Exec(t, `RESTORE data.bank FROM $1 WITH skip_statistics, into_db = $2`, LocalFoo, "data 2")
+	sqlDB.CheckQueryResults(t, getStatsQuery(`"data 2".bank`), [][]string{})
+}
+
+func TestRestoreSkipUnreadableFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const numAccounts = 10
+	_, _, sqlDB, dir, cleanupFn := BackupRestoreTestSetup(t, singleNode, numAccounts, InitManualReplication)
+	defer cleanupFn()
+
+	sqlDB.Exec(t, `BACKUP data.bank TO $1`, LocalFoo)
+	sqlDB.Exec(t, `UPDATE data.bank SET balance = balance + 1`)
+	sqlDB.Exec(t, `BACKUP data.bank TO $1`, LocalFoo)
+
+	// Corrupt the data files of the incremental layer, which hold the updated
+	// rows, so that they fail their checksums.
+	var corrupted []string
+	require.NoError(t, filepath.Walk(filepath.Join(dir, "foo"), func(p string, info os.FileInfo, err error) error {
+		if err != nil || filepath.Dir(p) == filepath.Join(dir, "foo") || !strings.HasSuffix(p, ".sst") {
+			return err
+		}
+		corrupted = append(corrupted, filepath.Base(p))
+		return ioutil.WriteFile(p, []byte("not an sstable"), 0644)
+	}))
+	require.NotEmpty(t, corrupted)
+
+	sqlDB.Exec(t, `CREATE DATABASE "data 2"`)
+	sqlDB.ExpectErr(t, "checksum mismatch",
+		`RESTORE data.bank FROM $1 WITH into_db = $2`, LocalFoo, "data 2")
+	sqlDB.Exec(t, `RESTORE data.bank FROM $1 WITH skip_unreadable_files, into_db = $2`, LocalFoo, "data 2")
+
+	// The spans of the unreadable files are skipped altogether, rather than
+	// restored from the full backup with the balances from before the update.
+	sqlDB.CheckQueryResults(t, `SELECT count(*) FROM "data 2".bank AS b JOIN data.bank AS o USING (id)
+WHERE b.balance != o.balance`, [][]string{{"0"}})
+
+	// The skipped spans and files are persisted in the progress of the job.
+	var jobID int64
+	sqlDB.QueryRow(t, `SELECT job_id FROM [SHOW JOBS] WHERE job_type = 'RESTORE' AND status = 'succeeded'`,
+	).Scan(&jobID)
+	progress := jobutils.GetJobProgress(t, sqlDB, jobID).GetRestore()
+	require.NotEmpty(t, progress.SkippedSpans)
+	for _, path := range progress.SkippedFiles {
+		require.Contains(t, corrupted, filepath.Base(path))
+	}
+	require.NotEmpty(t, progress.SkippedFiles)
+}
+
 // Ensure that statistics are restored from correct backup.
 func TestBackupCreatedStatsFromIncrementalBackup(t *testing.T) {
 	defer leaktest.AfterTest(t)()
@@ -7199,3 +7275,50 @@ table_name FROM [SHOW TABLES] ORDER BY schema_name, table_name`
 		return nil
 	})
 }
+
+func TestBackupRestoreInlineFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const numAccounts = 100
+	_, _, sqlDB, dir, cleanupFn := BackupRestoreTestSetup(t, singleNode, numAccounts, InitManualReplication)
+	defer cleanupFn()
+
+	sqlDB.Exec(t, `SET CLUSTER SETTING bulkio.backup.inline_file_size_threshold = '1MiB'`)
+	var expected int
+	sqlDB.QueryRow(t, `SELECT count(*) FROM data.bank`).Scan(&expected)
+
+	for _, tc := range []struct {
+		name string
+		uri  string
+		opts string
+	}{
+		{name: "plain", uri: LocalFoo + "/plain"},
+		{name: "encrypted", uri: LocalFoo + "/encrypted", opts: ` WITH encryption_passphrase = 'abcdefg'`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sqlDB.Exec(t, `BACKUP DATABASE data TO $1`+tc.opts, tc.uri)
+
+			// Every file is small enough to have been stored in the manifest.
+			if err := filepath.Walk(filepath.Join(dir, "foo", tc.name), func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if strings.HasSuffix(path, ".sst") {
+					t.Errorf("unexpected data file %s", path)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			sqlDB.Exec(t, `CREATE DATABASE restored`)
+			sqlDB.Exec(t, `RESTORE data.bank FROM $1 WITH into_db = 'restored'`+
+				strings.ReplaceAll(tc.opts, " WITH", ","), tc.uri)
+			var actual int
+			sqlDB.QueryRow(t, `SELECT count(*) FROM restored.bank`).Scan(&actual)
+			require.Equal(t, expected, actual)
+			sqlDB.Exec(t, `DROP DATABASE restored CASCADE`)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// VerifyReport is the outcome of verifying the files of a backup with
+// VerifyAllChecksums. The files are listed by path, in order.
+type VerifyReport struct {
+	// Passed are the files whose contents match their checksums.
+	Passed []string
+	// Failed are the files which couldn't be read or whose contents don't match
+	// their checksums.
+	Failed []VerifyFailure
+	// Missing are the files which don't exist.
+	Missing []string
+}
+
+// VerifyFailure is a file which failed verification, and why.
+type VerifyFailure struct {
+	Path string
+	Err  error
+}
+
+// OK returns whether all the files of the backup passed verification.
+func (r VerifyReport) OK() bool {
+	return len(r.Failed) == 0 && len(r.Missing) == 0
+}
+
+// VerifyAllChecksums reads each of the data files of the backup described by
+// manifest and checks them against their checksums, as a restore would, using
+// up to concurrency workers, or bulkio.restore.file_concurrency if it isn't
+// positive. stores are the locations of the backup, the first of which must be
+// its default locality, as for a restore; the files of each partition are read
+// from the location holding its partition descriptor.
+//
+// Every file is verified: the files which fail verification are collected in
+// the report rather than returned as errors, which are reserved for failures to
+// verify the backup as a whole, such as a cancelled ctx. Files without a
+// checksum, which older backups may have, pass if they can be read. If progress
+// is set, it's called after each file is verified with the number of files
+// verified so far out of the total; the calls are serialized.
+func VerifyAllChecksums(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	manifest BackupManifest,
+	encryption *jobspb.BackupEncryptionOptions,
+	concurrency int,
+	progress func(verified, total int),
+) (VerifyReport, error) {
+	if len(stores) == 0 {
+		return VerifyReport{}, errors.New("no backup locations to verify")
+	}
+	partitions, err := findBackupPartitions(ctx, stores, manifest, encryption, "" /* prefix */)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	var fileEncryption *roachpb.FileEncryptionOptions
+	if encryption != nil {
+		key, err := getEncryptionKey(ctx, encryption, stores[0].Settings(), stores[0].ExternalIOConf(),
+			nil /* dataKeys */)
+		if err != nil {
+			return VerifyReport{}, err
+		}
+		fileEncryption = &roachpb.FileEncryptionOptions{Key: key}
+	}
+
+	if concurrency < 1 {
+		concurrency = int(backupFileConcurrency.Get(&stores[0].Settings().SV))
+	}
+	files := manifest.Files
+	verifyErrs := make([]error, len(files))
+	var mu struct {
+		syncutil.Mutex
+		verified int
+	}
+	if err := forEachBackupFile(ctx, len(files), concurrency, func(ctx context.Context, i int) error {
+		store := stores[0]
+		if p, ok := partitions[files[i].LocalityKV]; ok {
+			store = stores[p.store]
+		}
+		verifyErrs[i] = verifyBackupFile(ctx, store, files[i], fileEncryption)
+		// A cancelled verification mustn't be mistaken for failed files.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if progress != nil {
+			mu.Lock()
+			mu.verified++
+			progress(mu.verified, len(files))
+			mu.Unlock()
+		}
+		return nil
+	}); err != nil {
+		return VerifyReport{}, err
+	}
+
+	var report VerifyReport
+	for i, err := range verifyErrs {
+		switch {
+		case err == nil:
+			report.Passed = append(report.Passed, files[i].Path)
+		case errors.Is(err, cloudimpl.ErrFileDoesNotExist):
+			report.Missing = append(report.Missing, files[i].Path)
+		default:
+			report.Failed = append(report.Failed, VerifyFailure{Path: files[i].Path, Err: err})
+		}
+	}
+	sort.Strings(report.Passed)
+	sort.Strings(report.Missing)
+	sort.Slice(report.Failed, func(i, j int) bool {
+		return report.Failed[i].Path < report.Failed[j].Path
+	})
+	return report, nil
+}
+
+// verifyBackupFile checks the contents of the backup file f, which is in store
+// unless it's inline, against its checksum.
+func verifyBackupFile(
+	ctx context.Context,
+	store cloud.ExternalStorage,
+	f BackupManifest_File,
+	encryption *roachpb.FileEncryptionOptions,
+) error {
+	data := append([]byte(nil), f.InlineData...)
+	if len(data) == 0 {
+		var err error
+		if data, err = readFileResumable(ctx, store, f.Path, nil /* peek */); err != nil {
+			return err
+		}
+	}
+	_, err := decodeBackupFile(data, roachpb.ImportRequest_File{
+		Path:        f.Path,
+		Sha512:      f.Sha512,
+		Compression: f.Compression,
+	}, encryption)
+	return err
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAllChecksums(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	var stores []cloud.ExternalStorage
+	for _, uri := range []string{"nodelocal://1/backup", "nodelocal://1/backup-east"} {
+		store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+		require.NoError(t, err)
+		defer store.Close()
+		stores = append(stores, store)
+	}
+
+	salt, err := storageccl.GenerateSalt()
+	require.NoError(t, err)
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("abcdefg"), salt),
+	}
+	// makeFile returns a file of the backup, whose contents are its path, and
+	// writes them, encrypted, to store unless it's nil.
+	makeFile := func(start, end string, store cloud.ExternalStorage) BackupManifest_File {
+		f := makeTestFile(start, end)
+		var err error
+		f.Sha512, err = storageccl.SHA512ChecksumData([]byte(f.Path))
+		require.NoError(t, err)
+		if store != nil {
+			data, err := storageccl.EncryptFile([]byte(f.Path), encryption.Key)
+			require.NoError(t, err)
+			require.NoError(t, store.WriteFile(ctx, f.Path, bytes.NewReader(data)))
+		}
+		return f
+	}
+
+	good := makeFile("a", "b", stores[0])
+	corrupt := makeFile("b", "c", stores[0])
+	corrupt.Sha512[0]++
+	missing := makeFile("c", "d", nil /* store */)
+	noChecksum := makeFile("d", "e", stores[0])
+	noChecksum.Sha512 = nil
+	east := makeFile("e", "f", stores[1])
+	east.LocalityKV = "region=east"
+	// The file of the other locality isn't in the default one.
+	eastInDefault := east
+	eastInDefault.LocalityKV = ""
+	eastInDefault.Path = "f-g.sst"
+	require.NoError(t, writeBackupPartitionDescriptor(ctx, stores[1], backupPartitionDescriptorPrefix+"_east",
+		encryption, &BackupPartitionDescriptor{LocalityKV: east.LocalityKV, Files: []BackupManifest_File{east}}))
+	m := BackupManifest{
+		Files:                        []BackupManifest_File{eastInDefault, east, noChecksum, missing, corrupt, good},
+		LocalityKVs:                  []string{east.LocalityKV},
+		PartitionDescriptorFilenames: []string{backupPartitionDescriptorPrefix + "_east"},
+	}
+
+	for _, concurrency := range []int{0, 1, 4} {
+		var calls []int
+		report, err := VerifyAllChecksums(ctx, stores, m, encryption, concurrency,
+			func(verified, total int) {
+				require.Equal(t, len(m.Files), total)
+				calls = append(calls, verified)
+			})
+		require.NoError(t, err)
+		require.False(t, report.OK())
+		require.Equal(t, []string{"a-b.sst", "d-e.sst", "e-f.sst"}, report.Passed)
+		require.Equal(t, []string{"c-d.sst", "f-g.sst"}, report.Missing)
+		require.Len(t, report.Failed, 1)
+		require.Equal(t, "b-c.sst", report.Failed[0].Path)
+		require.EqualError(t, report.Failed[0].Err, "checksum mismatch for b-c.sst")
+		require.Equal(t, []int{1, 2, 3, 4, 5, 6}, calls)
+	}
+
+	// Without the key, none of the files can be decrypted.
+	report, err := VerifyAllChecksums(ctx, stores, BackupManifest{Files: []BackupManifest_File{good}},
+		nil /* encryption */, 1 /* concurrency */, nil /* progress */)
+	require.NoError(t, err)
+	require.Len(t, report.Failed, 1)
+
+	report, err = VerifyAllChecksums(ctx, stores, BackupManifest{Files: []BackupManifest_File{good}},
+		encryption, 1 /* concurrency */, nil /* progress */)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = VerifyAllChecksums(cancelled, stores, BackupManifest{Files: []BackupManifest_File{good}},
+		encryption, 1 /* concurrency */, nil /* progress */)
+	require.True(t, errors.Is(err, context.Canceled), "%+v", err)
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/util/cache"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// restoreDataKeyCacheSize is the number of decrypted data keys cached by a
+// restore job. A backup chain is usually encrypted with a single data key, so
+// this only needs to accommodate chains whose layers used different keys.
+const restoreDataKeyCacheSize = 16
+
+// dataKeyCache is an LRU cache of the data keys decrypted by a KMS, so that a
+// job which reads many encrypted files decrypts each data key only once. The
+// plaintext keys are sensitive, so they are overwritten with zeros when they
+// are evicted or the cache is cleared, and only copies of them are handed out.
+type dataKeyCache struct {
+	mu struct {
+		syncutil.Mutex
+		cache *cache.UnorderedCache
+	}
+}
+
+// dataKeyCacheKey identifies a data key by the KMS which encrypted it and its
+// ciphertext.
+type dataKeyCacheKey struct {
+	kmsURI           string
+	encryptedDataKey string
+}
+
+func newDataKeyCache(size int) *dataKeyCache {
+	c := &dataKeyCache{}
+	c.mu.cache = cache.NewUnorderedCache(cache.Config{
+		Policy: cache.CacheLRU,
+		ShouldEvict: func(n int, _, _ interface{}) bool {
+			return n > size
+		},
+		OnEvicted: func(_, value interface{}) {
+			zeroKey(value.([]byte))
+		},
+	})
+	return c
+}
+
+// get returns a copy of the plaintext data key for encryptedDataKey, calling
+// decrypt to obtain it if it isn't cached. The lock isn't held while decrypting,
+// so concurrent misses for the same key may decrypt it more than once.
+func (c *dataKeyCache) get(
+	kmsURI string, encryptedDataKey []byte, decrypt func() ([]byte, error),
+) ([]byte, error) {
+	key := dataKeyCacheKey{kmsURI: kmsURI, encryptedDataKey: string(encryptedDataKey)}
+	c.mu.Lock()
+	if v, ok := c.mu.cache.Get(key); ok {
+		defer c.mu.Unlock()
+		return append([]byte(nil), v.([]byte)...), nil
+	}
+	c.mu.Unlock()
+
+	plaintext, err := decrypt()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.mu.cache.StealthyGet(key); !ok {
+		c.mu.cache.Add(key, append([]byte(nil), plaintext...))
+	}
+	return plaintext, nil
+}
+
+// Clear zeroes and removes every key in the cache.
+func (c *dataKeyCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.cache.Clear()
+}
+
+func zeroKey(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataKeyCache(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	c := newDataKeyCache(2)
+	var decrypted []string
+	// plaintexts are the keys handed to the cache by decrypt, which it retains.
+	plaintexts := make(map[string][]byte)
+	get := func(ciphertext string) []byte {
+		key, err := c.get("testkms:///key", []byte(ciphertext), func() ([]byte, error) {
+			decrypted = append(decrypted, ciphertext)
+			plaintexts[ciphertext] = bytes.Repeat([]byte(ciphertext), 4)
+			return plaintexts[ciphertext], nil
+		})
+		require.NoError(t, err)
+		return key
+	}
+	cached := func(ciphertext string) []byte {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		v, ok := c.mu.cache.StealthyGet(dataKeyCacheKey{
+			kmsURI: "testkms:///key", encryptedDataKey: ciphertext,
+		})
+		if !ok {
+			return nil
+		}
+		return v.([]byte)
+	}
+
+	require.Equal(t, []byte("aaaa"), get("a"))
+	require.Equal(t, []byte("aaaa"), get("a"))
+	require.Equal(t, []byte("bbbb"), get("b"))
+	require.Equal(t, []string{"a", "b"}, decrypted)
+
+	// The callers' copies of a key are not affected by eviction, but the
+	// cached key is zeroed.
+	aKey := get("a")
+	evicted := cached("b")
+	require.Equal(t, []byte("cccc"), get("c"))
+	require.Equal(t, []string{"a", "b", "c"}, decrypted)
+	require.Equal(t, []byte{0, 0, 0, 0}, evicted)
+	require.Equal(t, []byte("aaaa"), aKey)
+	require.Equal(t, []byte("bbbb"), get("b"))
+	require.Equal(t, []string{"a", "b", "c", "b"}, decrypted)
+
+	// Clearing the cache zeroes every key in it.
+	remaining := [][]byte{cached("b"), cached("c")}
+	c.Clear()
+	for _, key := range remaining {
+		require.Equal(t, []byte{0, 0, 0, 0}, key)
+	}
+	require.Nil(t, cached("b"))
+
+	// Errors are not cached.
+	_, err := c.get("testkms:///key", []byte("d"), func() ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	require.EqualError(t, err, "boom")
+	require.Nil(t, cached("d"))
+}
+
+func TestGetEncryptionKeyUsesDataKeyCache(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	settings := cluster.MakeTestingClusterSettings()
+	kmsURI := constructMockKMSURIsWithKeyID([]string{"abc"})[0]
+	kms, err := MakeTestKMS(kmsURI, nil)
+	require.NoError(t, err)
+	encryptedDataKey, err := kms.Encrypt(ctx, []byte("data-key"))
+	require.NoError(t, err)
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode:    jobspb.EncryptionMode_KMS,
+		KMSInfo: &jobspb.BackupEncryptionOptions_KMSInfo{Uri: kmsURI, EncryptedDataKey: encryptedDataKey},
+	}
+
+	// Without a cache, the key is decrypted by the KMS.
+	key, err := getEncryptionKey(ctx, encryption, settings, base.ExternalIODirConfig{}, nil /* dataKeys */)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data-key"), key)
+
+	c := newDataKeyCache(restoreDataKeyCacheSize)
+	for i := 0; i < 3; i++ {
+		key, err = getEncryptionKey(ctx, encryption, settings, base.ExternalIODirConfig{}, c)
+		require.NoError(t, err)
+		require.Equal(t, []byte("data-key"), key, "attempt %d", i)
+	}
+	require.Equal(t, 1, c.mu.cache.Len())
+	// Clearing the cache at the end of the job leaves the callers' keys alone.
+	c.Clear()
+	require.Equal(t, 0, c.mu.cache.Len())
+	require.Equal(t, []byte("data-key"), key)
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+// +build gofuzz
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/errors"
+)
+
+// fuzzMaxDecompressedManifestSize bounds the memory used to decompress the
+// manifests read by FuzzReadBackupManifest.
+const fuzzMaxDecompressedManifestSize = 64 << 20
+
+var (
+	fuzzEncryption = &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase,
+		Key:  bytes.Repeat([]byte("k"), 32),
+	}
+	fuzzDictionary = []byte("descriptors tables databases schemas types spans files")
+)
+
+// fuzzStorage is an in-memory ExternalStorage holding the files read by
+// readBackupManifest.
+type fuzzStorage struct {
+	cloud.ExternalStorage
+	settings *cluster.Settings
+	files    map[string][]byte
+}
+
+func (s *fuzzStorage) ReadFile(_ context.Context, basename string) (io.ReadCloser, error) {
+	data, ok := s.files[basename]
+	if !ok {
+		return nil, errors.Wrapf(cloudimpl.ErrFileDoesNotExist, "%s", basename)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *fuzzStorage) Settings() *cluster.Settings {
+	return s.settings
+}
+
+func (s *fuzzStorage) ExternalIOConf() base.ExternalIODirConfig {
+	return base.ExternalIODirConfig{}
+}
+
+// FuzzReadBackupManifest feeds data through the decoding done by
+// readBackupManifest. The low bits of the first byte select whether the rest
+// of data is gzip compressed (1), compressed with a dictionary (2) and
+// encrypted (4) first, so that the fuzzer can reach the decoding of the
+// manifest itself as well as that of the compression and encryption, which it
+// exercises with data 0.
+func FuzzReadBackupManifest(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	mode, payload := data[0], data[1:]
+	const dictPath = "dict"
+	var encryption *jobspb.BackupEncryptionOptions
+	dict := fuzzDictionary
+	var err error
+	if mode&1 != 0 {
+		if payload, err = compressData(payload); err != nil {
+			panic(err)
+		}
+	}
+	if mode&2 != 0 {
+		if payload, err = compressDataWithDictionary(payload, dict, dictPath); err != nil {
+			panic(err)
+		}
+	}
+	if mode&4 != 0 {
+		encryption = fuzzEncryption
+		if payload, err = storageccl.EncryptFile(payload, encryption.Key); err != nil {
+			panic(err)
+		}
+		if dict, err = storageccl.EncryptFile(dict, encryption.Key); err != nil {
+			panic(err)
+		}
+	}
+
+	st := cluster.MakeTestingClusterSettings()
+	maxDecompressedManifestSize.Override(&st.SV, fuzzMaxDecompressedManifestSize)
+	store := &fuzzStorage{
+		settings: st,
+		files:    map[string][]byte{backupManifestName: payload, dictPath: dict},
+	}
+	if _, err := readBackupManifest(context.Background(), store, backupManifestName, encryption); err != nil {
+		return 0
+	}
+	return 1
+}
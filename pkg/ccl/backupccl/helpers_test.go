@@ -9,14 +9,17 @@
 package backupccl
 
 import (
+	"bytes"
 	"context"
 	gosql "database/sql"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
@@ -295,3 +298,66 @@ func injectStatsWithRowCount(
 	]'`, tableName, columnName, rowCount, rowCount))
 	return sqlDB.QueryStr(t, getStatsQuery(tableName))
 }
+
+// CanonicalizeFileSpans returns the spans covered by files, sorted in
+// BackupFileDescriptors order, with overlapping and adjacent spans merged, so
+// that backups of the same data split into different files compare equal.
+func CanonicalizeFileSpans(files []BackupManifest_File) []roachpb.Span {
+	sorted := append(BackupFileDescriptors(nil), files...)
+	sort.Sort(sorted)
+	spans := make([]roachpb.Span, 0, len(sorted))
+	for i := range sorted {
+		sp := sorted[i].Span
+		if n := len(spans); n > 0 && bytes.Compare(sp.Key, spans[n-1].EndKey) <= 0 {
+			if bytes.Compare(sp.EndKey, spans[n-1].EndKey) > 0 {
+				spans[n-1].EndKey = sp.EndKey
+			}
+			continue
+		}
+		spans = append(spans, sp)
+	}
+	return spans
+}
+
+// AssertSpansEqual fails the test if expected and actual don't cover the same
+// keys, once both are canonicalized as by CanonicalizeFileSpans, listing the
+// spans which are only in one of them.
+func AssertSpansEqual(t testing.TB, expected, actual []roachpb.Span) {
+	t.Helper()
+	canonicalize := func(spans []roachpb.Span) []roachpb.Span {
+		files := make([]BackupManifest_File, len(spans))
+		for i := range spans {
+			files[i].Span = spans[i]
+		}
+		return CanonicalizeFileSpans(files)
+	}
+	expected, actual = canonicalize(expected), canonicalize(actual)
+	compare := func(a, b roachpb.Span) int {
+		if cmp := bytes.Compare(a.Key, b.Key); cmp != 0 {
+			return cmp
+		}
+		return bytes.Compare(a.EndKey, b.EndKey)
+	}
+
+	var diff strings.Builder
+	var differ bool
+	for i, j := 0, 0; i < len(expected) || j < len(actual); {
+		switch {
+		case j == len(actual) || (i < len(expected) && compare(expected[i], actual[j]) < 0):
+			fmt.Fprintf(&diff, "- %s\n", expected[i])
+			differ = true
+			i++
+		case i == len(expected) || compare(expected[i], actual[j]) > 0:
+			fmt.Fprintf(&diff, "+ %s\n", actual[j])
+			differ = true
+			j++
+		default:
+			fmt.Fprintf(&diff, "  %s\n", expected[i])
+			i++
+			j++
+		}
+	}
+	if differ {
+		t.Errorf("spans differ (-expected +actual):\n%s", diff.String())
+	}
+}
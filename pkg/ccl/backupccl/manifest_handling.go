@@ -10,23 +10,36 @@ package backupccl
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/catalogkv"
@@ -35,9 +48,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
 	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
@@ -67,17 +85,37 @@ const (
 	// backupEncryptionInfoFile is the file name used to store the serialized
 	// EncryptionInfo proto while the backup is in progress.
 	backupEncryptionInfoFile = "ENCRYPTION-INFO"
+	// backupManifestDictionaryName is the file name used to store the
+	// compression dictionary shared by the incremental layers of a backup.
+	backupManifestDictionaryName = "BACKUP-MANIFEST-DICTIONARY"
 )
 
 const (
 	// BackupFormatDescriptorTrackingVersion added tracking of complete DBs.
 	BackupFormatDescriptorTrackingVersion uint32 = 1
+	// backupFormatElidedDescriptorsVersion added incremental manifests which
+	// only store the descriptors that changed since the previous layer.
+	backupFormatElidedDescriptorsVersion uint32 = 2
+	// backupFormatDictionaryCompressionVersion added manifests compressed with
+	// a dictionary shared by the layers of a backup.
+	backupFormatDictionaryCompressionVersion uint32 = 3
+	// backupFormatInlineFilesVersion added small files inlined in manifests.
+	backupFormatInlineFilesVersion uint32 = 4
+	// backupFormatMaxSupportedVersion is the newest format version of backup
+	// manifests which can be read. Manifests with newer versions may use
+	// features which would be misinterpreted, so they are refused. It must be
+	// updated whenever a new format version is added.
+	backupFormatMaxSupportedVersion = backupFormatInlineFilesVersion
 	// ZipType is the format of a GZipped compressed file.
 	ZipType = "application/x-gzip"
 
 	dateBasedIncFolderName  = "/20060102/150405.00"
 	dateBasedIntoFolderName = "/2006/01/02-150405.00"
 	latestFileName          = "LATEST"
+	// backupLayersIndexName is the file in a full backup's directory which, if
+	// present, lists the incremental layers appended to it, sparing a listing
+	// of the directory to find them.
+	backupLayersIndexName = "BACKUP-LAYERS"
 )
 
 // BackupFileDescriptors is an alias on which to implement sort's interface.
@@ -92,15 +130,518 @@ func (r BackupFileDescriptors) Less(i, j int) bool {
 	return bytes.Compare(r[i].Span.EndKey, r[j].Span.EndKey) < 0
 }
 
+// manifestParallelSortThreshold is the number of files in a manifest above which
+// they are sorted in parallel before the manifest is written. The manifests of
+// the largest backups list millions of files, which take a while to sort.
+var manifestParallelSortThreshold = settings.RegisterIntSetting(
+	"bulkio.backup.manifest_parallel_sort_threshold",
+	"number of files in a backup manifest above which they are sorted in parallel "+
+		"when it is written (0 to always sort them sequentially)",
+	0,
+	settings.NonNegativeInt,
+)
+
+// sortBackupFiles sorts files in BackupFileDescriptors order. If parallelThreshold
+// is positive, there are more files than it and there are several CPUs, the
+// files are sorted in chunks, one per CPU, which are then merged in rounds of
+// concurrent pairwise merges. The result is in the same order as that of
+// sort.Sort, except between files with the same span, whose order neither
+// guarantees.
+func sortBackupFiles(files BackupFileDescriptors, parallelThreshold int) {
+	n, procs := len(files), runtime.GOMAXPROCS(0)
+	if parallelThreshold <= 0 || n <= parallelThreshold || procs < 2 {
+		sort.Sort(files)
+		return
+	}
+
+	chunkSize := (n + procs - 1) / procs
+	minInt := func(a, b int) int {
+		if a < b {
+			return a
+		}
+		return b
+	}
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += chunkSize {
+		wg.Add(1)
+		go func(chunk BackupFileDescriptors) {
+			defer wg.Done()
+			sort.Sort(chunk)
+		}(files[lo:minInt(lo+chunkSize, n)])
+	}
+	wg.Wait()
+
+	// Each round merges pairs of adjacent sorted runs from src into dst,
+	// doubling the length of the runs, until a single run remains.
+	src, dst := files, make(BackupFileDescriptors, n)
+	for width := chunkSize; width < n; width *= 2 {
+		for lo := 0; lo < n; lo += 2 * width {
+			mid, hi := minInt(lo+width, n), minInt(lo+2*width, n)
+			wg.Add(1)
+			go func(lo, mid, hi int) {
+				defer wg.Done()
+				mergeBackupFiles(dst[lo:hi], src[lo:mid], src[mid:hi])
+			}(lo, mid, hi)
+		}
+		wg.Wait()
+		src, dst = dst, src
+	}
+	if &src[0] != &files[0] {
+		copy(files, src)
+	}
+}
+
+// mergeBackupFiles merges the sorted files in a and b into dst, which must be
+// as long as both of them. Files of a precede equal files of b.
+func mergeBackupFiles(dst, a, b BackupFileDescriptors) {
+	less := func(x, y *BackupManifest_File) bool {
+		if cmp := bytes.Compare(x.Span.Key, y.Span.Key); cmp != 0 {
+			return cmp < 0
+		}
+		return bytes.Compare(x.Span.EndKey, y.Span.EndKey) < 0
+	}
+	i, j := 0, 0
+	for k := range dst {
+		if j == len(b) || (i < len(a) && !less(&b[j], &a[i])) {
+			dst[k] = a[i]
+			i++
+		} else {
+			dst[k] = b[j]
+			j++
+		}
+	}
+}
+
+// BytewiseProgress tracks the fraction of the data in a set of backup files
+// which has been processed, weighting each file by its size so that progress
+// advances evenly when file sizes vary. It is safe for concurrent use.
+type BytewiseProgress struct {
+	total int64
+	done  int64 // accessed atomically
+}
+
+// NewBytewiseProgress returns a BytewiseProgress tracking the given files.
+func NewBytewiseProgress(files BackupFileDescriptors) *BytewiseProgress {
+	p := &BytewiseProgress{}
+	for i := range files {
+		p.total += bytewiseProgressWeight(&files[i])
+	}
+	return p
+}
+
+// bytewiseProgressWeight returns the weight of a file, which is its size. Every
+// file is given some weight so that empty files also count as progress.
+func bytewiseProgressWeight(f *BackupManifest_File) int64 {
+	if f.EntryCounts.DataSize > 0 {
+		return f.EntryCounts.DataSize
+	}
+	return 1
+}
+
+// Add records that the given file has been processed.
+func (p *BytewiseProgress) Add(f BackupManifest_File) {
+	atomic.AddInt64(&p.done, bytewiseProgressWeight(&f))
+}
+
+// Fraction returns the fraction of the data in the files which has been
+// processed.
+func (p *BytewiseProgress) Fraction() float32 {
+	if p.total == 0 {
+		return 1
+	}
+	done := atomic.LoadInt64(&p.done)
+	if done >= p.total {
+		return 1
+	}
+	return float32(done) / float32(p.total)
+}
+
+// backupFileConcurrency bounds the number of files referenced by a single
+// backup manifest that are operated on concurrently, e.g. when RESTORE fetches
+// the files of a span or a backup is verified.
+var backupFileConcurrency = settings.RegisterIntSetting(
+	"bulkio.restore.file_concurrency",
+	"number of files referenced by a backup manifest that are read or verified concurrently",
+	4,
+	settings.PositiveInt,
+)
+
+// forEachBackupFile invokes fn on the index of each of numFiles files, running
+// at most concurrency invocations at a time. The first error returned by fn
+// cancels the remaining work and is returned.
+func forEachBackupFile(
+	ctx context.Context, numFiles int, concurrency int, fn func(ctx context.Context, i int) error,
+) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > numFiles {
+		concurrency = numFiles
+	}
+	todo := make(chan int, numFiles)
+	for i := 0; i < numFiles; i++ {
+		todo <- i
+	}
+	close(todo)
+
+	return ctxgroup.GroupWorkers(ctx, concurrency, func(ctx context.Context, _ int) error {
+		for i := range todo {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(ctx, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DescSummary is the basic metadata of a descriptor in a backup.
+type DescSummary struct {
+	ID       descpb.ID
+	Name     string
+	ParentID descpb.ID
+	// Kind is the kind of the descriptor, as returned by
+	// catalog.Descriptor.TypeName.
+	Kind string
+}
+
+// DescriptorSummaries returns the summaries of the descriptors in the manifest,
+// read directly from their protos rather than by unwrapping them. Manifests
+// whose descriptors were elided must have been inflated first, see
+// inflateElidedDescriptors.
+func DescriptorSummaries(manifest BackupManifest) []DescSummary {
+	res := make([]DescSummary, 0, len(manifest.Descriptors))
+	for i := range manifest.Descriptors {
+		res = append(res, summarizeDescriptor(&manifest.Descriptors[i]))
+	}
+	return res
+}
+
+func summarizeDescriptor(desc *descpb.Descriptor) DescSummary {
+	summary := DescSummary{ID: descpb.GetDescriptorID(desc), Name: descpb.GetDescriptorName(desc)}
+	switch t := desc.Union.(type) {
+	case *descpb.Descriptor_Table:
+		summary.ParentID, summary.Kind = t.Table.ParentID, "relation"
+	case *descpb.Descriptor_Database:
+		summary.Kind = "database"
+	case *descpb.Descriptor_Type:
+		summary.ParentID, summary.Kind = t.Type.ParentID, "type"
+	case *descpb.Descriptor_Schema:
+		summary.ParentID, summary.Kind = t.Schema.ParentID, "schema"
+	}
+	return summary
+}
+
+// FilesOverlappingSpan returns the subset of files whose spans intersect span.
+// The files must be sorted in BackupFileDescriptors order and not overlap each
+// other, as is the case for the files of a backup manifest. The result aliases
+// the passed slice.
+func FilesOverlappingSpan(files []BackupManifest_File, span roachpb.Span) []BackupManifest_File {
+	// Since the files are disjoint and sorted by start key, their end keys are
+	// sorted too.
+	start := sort.Search(len(files), func(i int) bool {
+		return span.Key.Compare(files[i].Span.EndKey) < 0
+	})
+	endKey := span.EndKey
+	if len(endKey) == 0 {
+		endKey = span.Key.Next()
+	}
+	end := start + sort.Search(len(files)-start, func(i int) bool {
+		return files[start+i].Span.Key.Compare(endKey) >= 0
+	})
+	return files[start:end]
+}
+
+// CoveredSpans returns the spans covered by the files of the backup described
+// by m, as a sorted set of disjoint spans in which overlapping and adjacent
+// spans are merged.
+func CoveredSpans(m BackupManifest) []roachpb.Span {
+	spans := make([]roachpb.Span, len(m.Files))
+	for i := range m.Files {
+		spans[i] = m.Files[i].Span
+	}
+	covered, _ := roachpb.MergeSpans(spans)
+	return covered
+}
+
+// FilesByTable groups the files of the backup described by m by the table whose
+// data they hold, as decoded from the table prefix of the start key of their
+// spans, for tools which restore tables on their own. Only the IDs of tables
+// whose descriptors are in m are used. The files which span more than one
+// table, aren't in the key space of a table, such as those of tenants, or are
+// in that of a table which isn't in m are grouped under descpb.InvalidID.
+//
+// m must hold its full set of descriptors: an incremental backup which only
+// stored the descriptors that changed since the previous one must have been
+// inflated, as loadBackupManifests does, or the files of its unchanged tables
+// are grouped under descpb.InvalidID.
+func FilesByTable(m BackupManifest) map[descpb.ID][]BackupManifest_File {
+	tables := make(map[descpb.ID]struct{})
+	for i := range m.Descriptors {
+		if m.Descriptors[i].GetTable() != nil {
+			tables[descpb.GetDescriptorID(&m.Descriptors[i])] = struct{}{}
+		}
+	}
+	codec := keys.SystemSQLCodec
+	res := make(map[descpb.ID][]BackupManifest_File)
+	for _, f := range m.Files {
+		id := descpb.InvalidID
+		if _, tableID, err := codec.DecodeTablePrefix(f.Span.Key); err == nil {
+			_, inManifest := tables[descpb.ID(tableID)]
+			end := codec.TablePrefix(tableID).PrefixEnd()
+			if inManifest && f.Span.EndKey.Compare(end) <= 0 {
+				id = descpb.ID(tableID)
+			}
+		}
+		res[id] = append(res[id], f)
+	}
+	return res
+}
+
+// UncoveredSpans returns the parts of targets which aren't covered by covered,
+// which must be sorted and disjoint, as returned by CoveredSpans. The targets
+// must not be point spans.
+func UncoveredSpans(covered, targets []roachpb.Span) []roachpb.Span {
+	var uncovered []roachpb.Span
+	for _, target := range targets {
+		key := target.Key
+		i := sort.Search(len(covered), func(i int) bool {
+			return key.Compare(covered[i].EndKey) < 0
+		})
+		for ; i < len(covered) && covered[i].Key.Compare(target.EndKey) < 0; i++ {
+			if key.Compare(covered[i].Key) < 0 {
+				uncovered = append(uncovered, roachpb.Span{Key: key, EndKey: covered[i].Key})
+			}
+			key = covered[i].EndKey
+		}
+		if key.Compare(target.EndKey) < 0 {
+			uncovered = append(uncovered, roachpb.Span{Key: key, EndKey: target.EndKey})
+		}
+	}
+	return uncovered
+}
+
+// FileListFormat is a format in which ExportFileList can write the files of a
+// backup.
+type FileListFormat int
+
+const (
+	// FileListCSV writes a header row followed by one row per file.
+	FileListCSV FileListFormat = iota
+	// FileListJSON writes one JSON object per line per file.
+	FileListJSON
+)
+
+// exportedFile is a file of a backup as written by ExportFileList.
+type exportedFile struct {
+	Path     string `json:"path"`
+	StartKey string `json:"start_key"`
+	EndKey   string `json:"end_key"`
+	Size     int64  `json:"size"`
+	Entries  int64  `json:"entries"`
+}
+
+// ExportFileList writes the path, hex-encoded span, data size and number of
+// entries of each of the files in the manifest to w, in BackupFileDescriptors
+// order, so that tools which don't understand backup protos can consume them.
+func ExportFileList(m BackupManifest, w io.Writer, format FileListFormat) error {
+	files := make(BackupFileDescriptors, len(m.Files))
+	copy(files, m.Files)
+	sort.Sort(files)
+
+	switch format {
+	case FileListCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"path", "start_key", "end_key", "size", "entries"}); err != nil {
+			return err
+		}
+		for i := range files {
+			f := makeExportedFile(&files[i])
+			if err := cw.Write([]string{
+				f.Path, f.StartKey, f.EndKey, strconv.FormatInt(f.Size, 10), strconv.FormatInt(f.Entries, 10),
+			}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FileListJSON:
+		enc := json.NewEncoder(w)
+		for i := range files {
+			if err := enc.Encode(makeExportedFile(&files[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.AssertionFailedf("unknown file list format %d", format)
+	}
+}
+
+func makeExportedFile(f *BackupManifest_File) exportedFile {
+	return exportedFile{
+		Path:     f.Path,
+		StartKey: hex.EncodeToString(f.Span.Key),
+		EndKey:   hex.EncodeToString(f.Span.EndKey),
+		Size:     f.EntryCounts.DataSize,
+		Entries:  f.EntryCounts.Rows + f.EntryCounts.IndexEntries,
+	}
+}
+
+// RewriteFileSpans replaces the span of each of the files with the result of
+// rewrite, e.g. to move the files into the keyspace of another tenant, and then
+// sorts the files in BackupFileDescriptors order. It returns an error if a
+// rewritten span is invalid or overlaps with that of another file.
+func RewriteFileSpans(files []BackupManifest_File, rewrite func(roachpb.Span) roachpb.Span) error {
+	for i := range files {
+		span := rewrite(files[i].Span)
+		if !span.Valid() {
+			return errors.Newf("file %s: span %s was rewritten to invalid span %s",
+				files[i].Path, files[i].Span, span)
+		}
+		files[i].Span = span
+	}
+	sort.Sort(BackupFileDescriptors(files))
+	for i := 1; i < len(files); i++ {
+		if files[i].Span.Key.Compare(files[i-1].Span.EndKey) < 0 {
+			return errors.Newf("files %s and %s overlap after rewriting their spans to %s and %s",
+				files[i-1].Path, files[i].Path, files[i-1].Span, files[i].Span)
+		}
+	}
+	return nil
+}
+
+// BackupDiff describes what changed between two backup manifests, as computed
+// by DiffBackupManifests.
+type BackupDiff struct {
+	// AddedDescriptors and RemovedDescriptors are the IDs of descriptors that
+	// only appear in the newer or only in the older manifest, respectively.
+	AddedDescriptors, RemovedDescriptors []descpb.ID
+	// ChangedDescriptors are the IDs of descriptors that appear in both
+	// manifests but at different versions.
+	ChangedDescriptors []descpb.ID
+	// AddedSpans and RemovedSpans are the file spans that only appear in the
+	// newer or only in the older manifest, respectively.
+	AddedSpans, RemovedSpans []roachpb.Span
+}
+
+// DiffBackupManifests compares the descriptors and files of two backup
+// manifests, reporting what was added, removed or changed in b relative to a.
+// Neither manifest is modified. The manifests must hold their full sets of
+// descriptors: those of incremental backups which only stored the descriptors
+// that changed since the previous one must have been inflated, as
+// loadBackupManifests does.
+func DiffBackupManifests(a, b BackupManifest) (BackupDiff, error) {
+	var diff BackupDiff
+	for _, m := range []*BackupManifest{&a, &b} {
+		if m.DescriptorsElided {
+			return diff, errors.Newf(
+				"backup %s only stores the descriptors that changed since the previous backup", m.ID)
+		}
+	}
+
+	versionsByID := func(m BackupManifest) (map[descpb.ID]descpb.DescriptorVersion, error) {
+		versions := make(map[descpb.ID]descpb.DescriptorVersion, len(m.Descriptors))
+		for i := range m.Descriptors {
+			id, version, _, _ := descpb.GetDescriptorMetadata(&m.Descriptors[i])
+			if _, ok := versions[id]; ok {
+				return nil, errors.Errorf("duplicate descriptor ID %d in backup %s", id, m.ID)
+			}
+			versions[id] = version
+		}
+		return versions, nil
+	}
+	aVersions, err := versionsByID(a)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	bVersions, err := versionsByID(b)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	for id, bVersion := range bVersions {
+		if aVersion, ok := aVersions[id]; !ok {
+			diff.AddedDescriptors = append(diff.AddedDescriptors, id)
+		} else if aVersion != bVersion {
+			diff.ChangedDescriptors = append(diff.ChangedDescriptors, id)
+		}
+	}
+	for id := range aVersions {
+		if _, ok := bVersions[id]; !ok {
+			diff.RemovedDescriptors = append(diff.RemovedDescriptors, id)
+		}
+	}
+	for _, ids := range [][]descpb.ID{
+		diff.AddedDescriptors, diff.RemovedDescriptors, diff.ChangedDescriptors,
+	} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+
+	// Sort copies of the file lists so that the spans can be compared with a
+	// single merge pass.
+	aFiles := append(BackupFileDescriptors(nil), a.Files...)
+	bFiles := append(BackupFileDescriptors(nil), b.Files...)
+	sort.Sort(aFiles)
+	sort.Sort(bFiles)
+	i, j := 0, 0
+	for i < len(aFiles) && j < len(bFiles) {
+		aSpan, bSpan := aFiles[i].Span, bFiles[j].Span
+		cmp := bytes.Compare(aSpan.Key, bSpan.Key)
+		if cmp == 0 {
+			cmp = bytes.Compare(aSpan.EndKey, bSpan.EndKey)
+		}
+		switch {
+		case cmp < 0:
+			diff.RemovedSpans = append(diff.RemovedSpans, aSpan)
+			i++
+		case cmp > 0:
+			diff.AddedSpans = append(diff.AddedSpans, bSpan)
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	for ; i < len(aFiles); i++ {
+		diff.RemovedSpans = append(diff.RemovedSpans, aFiles[i].Span)
+	}
+	for ; j < len(bFiles); j++ {
+		diff.AddedSpans = append(diff.AddedSpans, bFiles[j].Span)
+	}
+	return diff, nil
+}
+
 // ReadBackupManifestFromURI creates an export store from the given URI, then
 // reads and unmarshals a BackupManifest at the standard location in the
-// export storage.
+// export storage. The descriptors of an incremental backup which only stores
+// those that changed since the previous backup are returned as stored, with
+// DescriptorsElided set; they must be inflated from the rest of the chain, as
+// inflateElidedDescriptors does, before being treated as its descriptor set.
+//
+// The URI may instead be a pre-signed HTTP(S) URL of the manifest itself, which
+// carries its credentials in its query string; see signedManifestName.
 func ReadBackupManifestFromURI(
 	ctx context.Context,
 	uri string,
 	user security.SQLUsername,
 	makeExternalStorageFromURI cloud.ExternalStorageFromURIFactory,
 	encryption *jobspb.BackupEncryptionOptions,
+) (BackupManifest, error) {
+	return readBackupManifestFromURI(ctx, uri, user, makeExternalStorageFromURI, encryption,
+		manifestReadOptions{})
+}
+
+// readBackupManifestFromURI is like ReadBackupManifestFromURI, with the
+// manifest read with opts.
+func readBackupManifestFromURI(
+	ctx context.Context,
+	uri string,
+	user security.SQLUsername,
+	makeExternalStorageFromURI cloud.ExternalStorageFromURIFactory,
+	encryption *jobspb.BackupEncryptionOptions,
+	opts manifestReadOptions,
 ) (BackupManifest, error) {
 	exportStore, err := makeExternalStorageFromURI(ctx, uri, user)
 
@@ -108,7 +649,77 @@ func ReadBackupManifestFromURI(
 		return BackupManifest{}, err
 	}
 	defer exportStore.Close()
-	return readBackupManifestFromStore(ctx, exportStore, encryption)
+	if name, ok := signedManifestName(uri); ok {
+		return readBackupManifestWithOptions(ctx, signedManifestStorage{ExternalStorage: exportStore, name: name},
+			name, encryption, opts)
+	}
+	return readBackupManifestFromStoreWithOptions(ctx, exportStore, encryption, opts)
+}
+
+// signedManifestName returns the name of the manifest which uri points at, if
+// it is an HTTP(S) URL of a manifest object with a query string, such as a
+// pre-signed URL which authenticates the request for that one object.
+func signedManifestName(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.RawQuery == "" {
+		return "", false
+	}
+	switch name := path.Base(u.Path); name {
+	case backupManifestName, backupOldManifestName:
+		return name, true
+	}
+	return "", false
+}
+
+// signedManifestStorage serves the manifest named name from an HTTP storage
+// whose base URL is the signed URL of the manifest. The URL, including its
+// query string, is requested as is, since the signature covers its path and
+// it grants access to no other object. The other files of the backup, such as
+// the manifest's checksum, are reported as missing, so a manifest compressed
+// with a dictionary can't be read this way.
+type signedManifestStorage struct {
+	cloud.ExternalStorage
+	name string
+}
+
+// ReadFile is part of the cloud.ExternalStorage interface.
+func (s signedManifestStorage) ReadFile(ctx context.Context, basename string) (io.ReadCloser, error) {
+	if basename != s.name {
+		return nil, errors.Wrapf(cloudimpl.ErrFileDoesNotExist,
+			"cannot read %s with a signed URL of %s", basename, s.name)
+	}
+	return s.ExternalStorage.ReadFile(ctx, "")
+}
+
+// ReadBackupManifestRaw is like ReadBackupManifestFromURI, except that the
+// manifest is returned exactly as stored, for debugging tools: the descriptors
+// aren't backfilled with the ModificationTime of tables written by versions
+// prior to 19.1, and the manifest isn't validated or deduplicated. Restores and
+// SHOW BACKUP must use ReadBackupManifestFromURI instead.
+func ReadBackupManifestRaw(
+	ctx context.Context,
+	uri string,
+	user security.SQLUsername,
+	makeExternalStorageFromURI cloud.ExternalStorageFromURIFactory,
+	encryption *jobspb.BackupEncryptionOptions,
+) (BackupManifest, error) {
+	exportStore, err := makeExternalStorageFromURI(ctx, uri, user)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	defer exportStore.Close()
+	opts := manifestReadOptions{raw: true}
+	backupManifest, err := readBackupManifestWithOptions(ctx, exportStore, backupManifestName,
+		encryption, opts)
+	if err != nil {
+		oldManifest, newErr := readBackupManifestWithOptions(ctx, exportStore, backupOldManifestName,
+			encryption, opts)
+		if newErr != nil {
+			return BackupManifest{}, err
+		}
+		backupManifest = oldManifest
+	}
+	return backupManifest, nil
 }
 
 func readBackupManifestFromStore(
@@ -116,22 +727,154 @@ func readBackupManifestFromStore(
 	exportStore cloud.ExternalStorage,
 	encryption *jobspb.BackupEncryptionOptions,
 ) (BackupManifest, error) {
-	backupManifest, err := readBackupManifest(ctx, exportStore, backupManifestName,
-		encryption)
+	return readBackupManifestFromStoreWithOptions(ctx, exportStore, encryption, manifestReadOptions{})
+}
+
+// readBackupManifestFromStoreWithOptions is like readBackupManifestFromStore,
+// with the manifest read with opts.
+func readBackupManifestFromStoreWithOptions(
+	ctx context.Context,
+	exportStore cloud.ExternalStorage,
+	encryption *jobspb.BackupEncryptionOptions,
+	opts manifestReadOptions,
+) (BackupManifest, error) {
+	backupManifest, err := readBackupManifestWithOptions(ctx, exportStore, backupManifestName,
+		encryption, opts)
 	if err != nil {
-		oldManifest, newErr := readBackupManifest(ctx, exportStore, backupOldManifestName,
-			encryption)
+		oldManifest, newErr := readBackupManifestWithOptions(ctx, exportStore, backupOldManifestName,
+			encryption, opts)
 		if newErr != nil {
+			if encryption == nil {
+				// The manifest may be unreadable because it's encrypted, which is
+				// reported more clearly when the backup's encryption info is found.
+				if encrypted, infoErr := containsEncryptionInfo(ctx, exportStore); infoErr != nil {
+					return BackupManifest{}, errors.CombineErrors(
+						errors.Wrap(infoErr, "checking whether the unreadable backup manifest is encrypted"), err)
+				} else if encrypted {
+					return BackupManifest{}, errors.Newf(
+						"this backup is encrypted; specify one of \"%s\" or \"%s\" to decrypt it",
+						backupOptEncPassphrase, backupOptEncKMS)
+				}
+			}
 			return BackupManifest{}, err
 		}
 		backupManifest = oldManifest
 	}
 	backupManifest.Dir = exportStore.Conf()
-	// TODO(dan): Sanity check this BackupManifest: non-empty EndTime,
-	// non-empty Paths, and non-overlapping Spans and keyranges in Files.
+	if st := exportStore.Settings(); st != nil && manifestValidationEnabled.Get(&st.SV) {
+		if err := validateManifestTimes(&backupManifest); err != nil {
+			return BackupManifest{}, err
+		}
+		if err := validateManifestClusterVersion(&backupManifest, st.Version.BinaryVersion()); err != nil {
+			return BackupManifest{}, err
+		}
+		if err := validateManifestFilePaths(
+			ctx, &backupManifest, manifestDedupeFilesEnabled.Get(&st.SV),
+		); err != nil {
+			return BackupManifest{}, err
+		}
+	}
+	// TODO(dan): Sanity check this BackupManifest: non-empty Paths, and
+	// non-overlapping Spans and keyranges in Files.
 	return backupManifest, nil
 }
 
+// manifestValidationEnabled controls whether manifests are sanity checked as
+// they are read, to catch manifests which were written incorrectly before they
+// cause confusing failures later on.
+var manifestValidationEnabled = settings.RegisterBoolSetting(
+	"bulkio.backup.manifest_validation.enabled",
+	"sanity check backup manifests as they are read",
+	false,
+)
+
+// validateManifestClusterVersion checks that the backup was taken by a cluster
+// whose version is supported by binaryVersion. A newer cluster may have written
+// data and descriptors which this binary would misinterpret. Backups taken
+// before the version was recorded aren't checked.
+func validateManifestClusterVersion(m *BackupManifest, binaryVersion roachpb.Version) error {
+	if m.ClusterVersion == (roachpb.Version{}) || !binaryVersion.Less(m.ClusterVersion) {
+		return nil
+	}
+	return pgerror.Newf(pgcode.FeatureNotSupported,
+		"backup was taken by a cluster at version %s, which is newer than the "+
+			"version %s supported by this binary; it must be restored by a binary at "+
+			"version %s or later", m.ClusterVersion, binaryVersion, m.ClusterVersion)
+}
+
+// validateManifestTimes checks that the times recorded in the manifest are
+// consistent with one another.
+func validateManifestTimes(m *BackupManifest) error {
+	if m.EndTime.Less(m.StartTime) {
+		return errors.Newf("invalid backup manifest: end time %s is before start time %s",
+			m.EndTime, m.StartTime)
+	}
+	if m.EndTime.IsEmpty() && len(m.Files) > 0 {
+		return errors.Newf("invalid backup manifest: end time is unset for a backup of %d files",
+			len(m.Files))
+	}
+	if !m.RevisionStartTime.IsEmpty() &&
+		(m.RevisionStartTime.Less(m.StartTime) || m.EndTime.Less(m.RevisionStartTime)) {
+		return errors.Newf("invalid backup manifest: revision start time %s is outside of [%s, %s]",
+			m.RevisionStartTime, m.StartTime, m.EndTime)
+	}
+	return nil
+}
+
+// manifestDedupeFilesEnabled controls whether validation removes the duplicate
+// entries for a file from a manifest, rather than rejecting the manifest.
+var manifestDedupeFilesEnabled = settings.RegisterBoolSetting(
+	"bulkio.backup.manifest_validation.dedupe_files.enabled",
+	"remove duplicate file entries from backup manifests as they are validated, instead of failing",
+	false,
+)
+
+// validateManifestFilePaths checks that no two of the files in the manifest
+// have the same path, as can happen if a backup retried incorrectly, which
+// would cause the file to be ingested twice by a restore. If dedupe is set, the
+// duplicates are instead removed from the manifest, keeping the first entry for
+// each path, and a warning is logged.
+func validateManifestFilePaths(ctx context.Context, m *BackupManifest, dedupe bool) error {
+	seen := make(map[string]int, len(m.Files))
+	var duplicates []string
+	deduped := m.Files[:0:0]
+	for i := range m.Files {
+		f := &m.Files[i]
+		if first, ok := seen[f.Path]; ok {
+			duplicates = append(duplicates, fmt.Sprintf("%s (spans %s and %s)",
+				f.Path, m.Files[first].Span, f.Span))
+			continue
+		}
+		seen[f.Path] = i
+		deduped = append(deduped, *f)
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	if !dedupe {
+		return errors.Newf("invalid backup manifest: %d duplicate file entries: %s",
+			len(duplicates), strings.Join(duplicates, "; "))
+	}
+	log.Warningf(ctx, "removing %d duplicate file entries from backup manifest: %s",
+		len(duplicates), strings.Join(duplicates, "; "))
+	m.Files = deduped
+	return nil
+}
+
+// containsEncryptionInfo returns whether exportStore contains the encryption
+// info of an encrypted backup.
+func containsEncryptionInfo(ctx context.Context, exportStore cloud.ExternalStorage) (bool, error) {
+	r, err := exportStore.ReadFile(ctx, backupEncryptionInfoFile)
+	if err != nil {
+		if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	r.Close()
+	return true, nil
+}
+
 func containsManifest(ctx context.Context, exportStore cloud.ExternalStorage) (bool, error) {
 	r, err := exportStore.ReadFile(ctx, backupManifestName)
 	if err != nil {
@@ -169,6 +912,501 @@ func decompressData(descBytes []byte) ([]byte, error) {
 	return ioutil.ReadAll(r)
 }
 
+const defaultMaxDecompressedManifestSize = 2 << 30 // 2 GiB
+
+// maxDecompressedManifestSize bounds the size to which a compressed manifest
+// read from external storage may decompress, so that a small crafted file
+// can't exhaust the memory of the node reading it.
+var maxDecompressedManifestSize = settings.RegisterByteSizeSetting(
+	"bulkio.backup.max_decompressed_manifest_size",
+	"maximum size to which a compressed backup manifest may decompress when read",
+	defaultMaxDecompressedManifestSize,
+	settings.PositiveInt,
+)
+
+// decompressedManifestSizeLimit returns the maximum size of decompressed
+// manifests read from store.
+func decompressedManifestSizeLimit(store cloud.ExternalStorage) int64 {
+	if st := store.Settings(); st != nil {
+		return maxDecompressedManifestSize.Get(&st.SV)
+	}
+	return defaultMaxDecompressedManifestSize
+}
+
+// decompressDataLimit is like decompressData, but returns an error if the data
+// decompresses to more than maxBytes. It should be used for data which isn't
+// trusted.
+func decompressDataLimit(descBytes []byte, maxBytes int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewBuffer(descBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errors.Newf("data decompresses to more than the maximum of %s",
+			humanizeutil.IBytes(maxBytes))
+	}
+	return data, nil
+}
+
+// dictionaryCompressionPrefix starts the contents of manifests compressed with
+// a dictionary. It is followed by the uvarint length of the path of the
+// dictionary, that path, the crc32 checksum of the dictionary and the deflate
+// stream.
+var dictionaryCompressionPrefix = []byte("CRDB-DICT")
+
+// manifestDictionaryEnabled controls whether the manifests of incremental
+// backups are compressed with a dictionary built from the descriptors of the
+// base backup.
+var manifestDictionaryEnabled = settings.RegisterBoolSetting(
+	"bulkio.backup.manifest_dictionary.enabled",
+	"if true, incremental backup manifests are compressed with a dictionary shared by the "+
+		"backup chain; such backups can't be read by nodes running older versions",
+	false,
+)
+
+// makeManifestDictionary builds a compression dictionary from the descriptors
+// in the given base backup, which the manifests of later layers mostly repeat.
+func makeManifestDictionary(base *BackupManifest) ([]byte, error) {
+	var buf []byte
+	for i := range base.Descriptors {
+		b, err := protoutil.Marshal(&base.Descriptors[i])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	// The compressor only considers the last window worth of the dictionary.
+	const maxDictionarySize = 32 << 10
+	if len(buf) > maxDictionarySize {
+		buf = buf[len(buf)-maxDictionarySize:]
+	}
+	return buf, nil
+}
+
+// compressDataWithDictionary compresses data using the dictionary stored at
+// dictPath and returns the compressed bytes.
+func compressDataWithDictionary(data, dict []byte, dictPath string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(dictionaryCompressionPrefix)
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(dictPath)))])
+	buf.WriteString(dictPath)
+	binary.BigEndian.PutUint32(scratch[:4], crc32.ChecksumIEEE(dict))
+	buf.Write(scratch[:4])
+	w, err := flate.NewWriterDict(&buf, flate.DefaultCompression, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeDictionaryCompressedHeader returns the dictionary path and checksum
+// recorded in data, which must start with dictionaryCompressionPrefix, along
+// with the remaining compressed bytes.
+func decodeDictionaryCompressedHeader(data []byte) (string, uint32, []byte, error) {
+	data = data[len(dictionaryCompressionPrefix):]
+	n, l := binary.Uvarint(data)
+	// NB: n is compared to the remaining length without adding to it, since a
+	// corrupt length could overflow.
+	if l <= 0 || n > uint64(len(data)-l) || uint64(len(data)-l)-n < 4 {
+		return "", 0, nil, errors.New("malformed dictionary compression header")
+	}
+	data = data[l:]
+	dictPath := string(data[:n])
+	checksum := binary.BigEndian.Uint32(data[n : n+4])
+	return dictPath, checksum, data[n+4:], nil
+}
+
+// decompressDataWithDictionary decompresses data produced by
+// compressDataWithDictionary, given the dictionary it was compressed with. Like
+// decompressDataLimit, it returns an error if the data decompresses to more
+// than maxBytes.
+func decompressDataWithDictionary(data, dict []byte, maxBytes int64) ([]byte, error) {
+	_, checksum, data, err := decodeDictionaryCompressedHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(dict) != checksum {
+		return nil, errors.New("compression dictionary does not match the one the data was compressed with")
+	}
+	r := flate.NewReaderDict(bytes.NewReader(data), dict)
+	defer r.Close()
+	decompressed, err := ioutil.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decompressed)) > maxBytes {
+		return nil, errors.Newf("data decompresses to more than the maximum of %s",
+			humanizeutil.IBytes(maxBytes))
+	}
+	return decompressed, nil
+}
+
+// readManifestDictionary reads the compression dictionary from filename in the
+// provided export store.
+func readManifestDictionary(
+	ctx context.Context,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	dataKeys *dataKeyCache,
+) ([]byte, error) {
+	r, err := exportStore.ReadFile(ctx, filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading backup manifest compression dictionary")
+	}
+	defer r.Close()
+	dict, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if encryption != nil {
+		encryptionKey, err := getEncryptionKey(ctx, encryption, exportStore.Settings(),
+			exportStore.ExternalIOConf(), dataKeys)
+		if err != nil {
+			return nil, err
+		}
+		return storageccl.DecryptFile(dict, encryptionKey)
+	}
+	return dict, nil
+}
+
+// writeManifestDictionaryIfNotExists writes the compression dictionary built
+// from the base backup to the root of the provided export store, which is
+// expected to hold the base backup, unless one was already written there by a
+// previous incremental backup of the chain.
+func writeManifestDictionaryIfNotExists(
+	ctx context.Context,
+	settings *cluster.Settings,
+	exportStore cloud.ExternalStorage,
+	encryption *jobspb.BackupEncryptionOptions,
+	base *BackupManifest,
+) error {
+	r, err := exportStore.ReadFile(ctx, backupManifestDictionaryName)
+	if err == nil {
+		return r.Close()
+	}
+	if !errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+		return errors.Wrap(err, "checking for existing backup manifest compression dictionary")
+	}
+	dict, err := makeManifestDictionary(base)
+	if err != nil {
+		return err
+	}
+	if encryption != nil {
+		encryptionKey, err := getEncryptionKey(ctx, encryption, settings, exportStore.ExternalIOConf(),
+			nil /* dataKeys */)
+		if err != nil {
+			return err
+		}
+		dict, err = storageccl.EncryptFile(dict, encryptionKey)
+		if err != nil {
+			return err
+		}
+	}
+	return writeMetadataFile(ctx, exportStore, backupManifestDictionaryName, dict, manifestWriteOptions{})
+}
+
+// manifestDictionaryPath returns the path of the compression dictionary
+// written next to the base backup at baseURI relative to the backup at uri, or
+// false if the backup at uri can't refer to it, e.g. because it is stored on a
+// different host.
+func manifestDictionaryPath(baseURI, uri string) (string, bool) {
+	base, err := url.Parse(baseURI)
+	if err != nil {
+		return "", false
+	}
+	layer, err := url.Parse(uri)
+	if err != nil {
+		return "", false
+	}
+	if base.Scheme != layer.Scheme || base.Host != layer.Host || base.RawQuery != layer.RawQuery {
+		return "", false
+	}
+	basePath, layerPath := path.Clean("/"+base.Path), path.Clean("/"+layer.Path)
+	var up []string
+	for layerPath != basePath && layerPath != "/" && !strings.HasPrefix(basePath, layerPath+"/") {
+		layerPath = path.Dir(layerPath)
+		up = append(up, "..")
+	}
+	return path.Join(append(append(up, strings.TrimPrefix(basePath, layerPath)),
+		backupManifestDictionaryName)...), true
+}
+
+// maxManifestReadResumes bounds the number of times an interrupted read of a
+// manifest is resumed before giving up.
+const maxManifestReadResumes = 3
+
+// manifestPeekSize is the size of the head of a manifest which is passed to
+// the peek function of readFileResumable.
+const manifestPeekSize = 64
+
+// readFileResumable reads all of the named file from store. If the read is
+// interrupted, it is resumed from where it left off when the store supports
+// range reads, and restarted from the beginning of the file otherwise. A
+// resumed read only reads the version of the file which was being read, so
+// that a file overwritten in between, such as a checkpoint, isn't returned as
+// a splice of two versions: the read is restarted from the beginning of the
+// new version instead, as it is when the store can't tell the version.
+//
+// If peek is non-nil, it is called with the first manifestPeekSize bytes of
+// the file, or all of it if it is shorter, before the rest is read; if it
+// returns an error, the read is abandoned and the error returned. Peeking is
+// best-effort: it is skipped if the head of the file can't be read at once.
+func readFileResumable(
+	ctx context.Context, store cloud.ExternalStorage, filename string, peek func(head []byte) error,
+) ([]byte, error) {
+	rr, rangeReads := store.(cloud.RangeReadStorage)
+	var version string
+	open := func() (r io.ReadCloser, err error) {
+		if rangeReads {
+			r, version, err = rr.ReadFileAt(ctx, filename, 0, "" /* version */)
+			return r, err
+		}
+		return store.ReadFile(ctx, filename)
+	}
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if peek != nil {
+		if _, err := io.CopyN(&buf, r, manifestPeekSize); err == nil || err == io.EOF {
+			if err := peek(buf.Bytes()); err != nil {
+				_ = r.Close()
+				return nil, err
+			}
+		}
+	}
+	for resumes := 0; ; resumes++ {
+		_, err = buf.ReadFrom(r)
+		_ = r.Close()
+		if err == nil {
+			return buf.Bytes(), nil
+		}
+		if resumes >= maxManifestReadResumes || ctx.Err() != nil {
+			return nil, err
+		}
+		if rangeReads && version != "" {
+			log.Warningf(ctx, "resuming read of %s at offset %d after error: %v", filename, buf.Len(), err)
+			r, _, err = rr.ReadFileAt(ctx, filename, int64(buf.Len()), version)
+			if errors.Is(err, cloudimpl.ErrFileChanged) {
+				log.Warningf(ctx, "restarting read of %s, which changed while it was read", filename)
+				buf.Reset()
+				r, err = open()
+			}
+		} else {
+			log.Warningf(ctx, "restarting read of %s after error: %v", filename, err)
+			buf.Reset()
+			r, err = open()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// manifestReadStats accumulates the time spent in each phase of reading
+// manifests with readBackupManifestWithOptions, for the reads made with it set
+// in their manifestReadOptions.
+type manifestReadStats struct {
+	mu struct {
+		syncutil.Mutex
+		manifests int
+		bytes     int64
+		manifestReadPhases
+	}
+}
+
+// manifestReadPhases are the durations of the phases of reading a manifest.
+type manifestReadPhases struct {
+	// fetch is the time spent reading the manifest, its checksum and its
+	// compression dictionary from the store.
+	fetch      time.Duration
+	decrypt    time.Duration
+	decompress time.Duration
+	unmarshal  time.Duration
+}
+
+func (s *manifestReadStats) record(bytes int64, phases manifestReadPhases) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.manifests++
+	s.mu.bytes += bytes
+	s.mu.fetch += phases.fetch
+	s.mu.decrypt += phases.decrypt
+	s.mu.decompress += phases.decompress
+	s.mu.unmarshal += phases.unmarshal
+}
+
+func (s *manifestReadStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("%d manifests (%s): fetch %s, decrypt %s, decompress %s, unmarshal %s",
+		s.mu.manifests, humanizeutil.IBytes(s.mu.bytes),
+		s.mu.fetch, s.mu.decrypt, s.mu.decompress, s.mu.unmarshal)
+}
+
+// manifestWriteProgressFn is called as the metadata files of a backup are
+// uploaded, with the name of the file, the number of its bytes consumed by the
+// store so far and its size. The number of bytes consumed can go back if the
+// store rewinds the file to retry the upload.
+type manifestWriteProgressFn func(filename string, written, size int64)
+
+// manifestWriteOptions are the options with which the manifests, partition
+// descriptors and statistics of a backup are written. The zero value writes
+// them as usual.
+type manifestWriteOptions struct {
+	// progress, if set, is called with the progress of the uploads. Without it,
+	// uploads aren't tracked.
+	progress manifestWriteProgressFn
+}
+
+// writeMetadataFile writes data to filename in store, reporting the progress of
+// the upload to opts.progress, if set.
+func writeMetadataFile(
+	ctx context.Context,
+	store cloud.ExternalStorage,
+	filename string,
+	data []byte,
+	opts manifestWriteOptions,
+) error {
+	var r io.ReadSeeker = bytes.NewReader(data)
+	if opts.progress != nil {
+		r = &progressReader{r: bytes.NewReader(data), filename: filename, fn: opts.progress}
+	}
+	return store.WriteFile(ctx, filename, r)
+}
+
+// progressReader reports the number of bytes read from r to fn as they are
+// read. It deliberately only implements io.ReadSeeker, as io.Copy would
+// otherwise bypass Read with bytes.Reader's WriteTo.
+type progressReader struct {
+	r        *bytes.Reader
+	filename string
+	fn       manifestWriteProgressFn
+}
+
+var _ io.ReadSeeker = &progressReader{}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.fn(p.filename, p.r.Size()-int64(p.r.Len()), p.r.Size())
+	}
+	return n, err
+}
+
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	return p.r.Seek(offset, whence)
+}
+
+// logManifestUploads returns a manifestWriteProgressFn which logs the size and
+// throughput of each file once it has been uploaded, to help diagnose slow
+// uploads of the metadata of large backups.
+func logManifestUploads(ctx context.Context) manifestWriteProgressFn {
+	var mu syncutil.Mutex
+	started := make(map[string]time.Time)
+	return func(filename string, written, size int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		start, ok := started[filename]
+		if !ok {
+			start = timeutil.Now()
+			started[filename] = start
+		}
+		if written < size {
+			return
+		}
+		delete(started, filename)
+		elapsed := timeutil.Since(start)
+		if elapsed < time.Millisecond {
+			elapsed = time.Millisecond
+		}
+		log.Infof(ctx, "uploaded %s (%s) in %s (%s/s)", filename, humanizeutil.IBytes(size),
+			elapsed, humanizeutil.IBytes(int64(float64(size)/elapsed.Seconds())))
+	}
+}
+
+// backupManifestFilesFieldNumber is the field number of BackupManifest.Files.
+const backupManifestFilesFieldNumber = 4
+
+// unmarshalBackupManifestSkippingFiles is like protoutil.Unmarshal, except that
+// it leaves the Files of the manifest empty, without decoding them. It walks
+// the fields of the encoded manifest and copies all but the Files into a new
+// buffer which is then decoded as usual.
+func unmarshalBackupManifestSkippingFiles(data []byte, m *BackupManifest) error {
+	filtered := make([]byte, 0, len(data))
+	// kept is the start of the run of fields to be copied to filtered.
+	kept := 0
+	for i := 0; i < len(data); {
+		start := i
+		tag, n := binary.Uvarint(data[i:])
+		if n <= 0 {
+			return errors.Newf("invalid field tag at offset %d of backup manifest", start)
+		}
+		i += n
+		fieldNum, wireType := tag>>3, tag&7
+		switch wireType {
+		case 0: // varint
+			_, n = binary.Uvarint(data[i:])
+			if n <= 0 {
+				return errors.Newf("invalid varint at offset %d of backup manifest", i)
+			}
+			i += n
+		case 1: // fixed64
+			i += 8
+		case 2: // length-delimited
+			l, n := binary.Uvarint(data[i:])
+			if n <= 0 || l > uint64(len(data)-i-n) {
+				return errors.Newf("invalid length at offset %d of backup manifest", i)
+			}
+			i += n + int(l)
+		case 5: // fixed32
+			i += 4
+		default:
+			return errors.Newf("unsupported wire type %d at offset %d of backup manifest", wireType, start)
+		}
+		if i > len(data) {
+			return errors.Newf("truncated field at offset %d of backup manifest", start)
+		}
+		if fieldNum == backupManifestFilesFieldNumber {
+			filtered = append(filtered, data[kept:start]...)
+			kept = i
+		}
+	}
+	filtered = append(filtered, data[kept:]...)
+	return protoutil.Unmarshal(filtered, m)
+}
+
+// manifestReadOptions are the options with which a manifest is read by
+// readBackupManifestWithOptions. The zero value reads it as usual.
+type manifestReadOptions struct {
+	// dataKeys, if set, caches the KMS data keys decrypted to read the manifest.
+	dataKeys *dataKeyCache
+	// stats, if set, records the time spent reading the manifest. Without it,
+	// reads aren't timed.
+	stats *manifestReadStats
+	// skipFiles, if set, leaves the Files of the manifest empty without decoding
+	// them, for callers which only need its descriptors. The Files of a large
+	// backup can make up most of its manifest.
+	skipFiles bool
+	// raw, if set, returns the descriptors of the manifest as stored, for
+	// ReadBackupManifestRaw.
+	raw bool
+}
+
 // readBackupManifest reads and unmarshals a BackupManifest from filename in
 // the provided export store.
 func readBackupManifest(
@@ -177,15 +1415,49 @@ func readBackupManifest(
 	filename string,
 	encryption *jobspb.BackupEncryptionOptions,
 ) (BackupManifest, error) {
-	r, err := exportStore.ReadFile(ctx, filename)
-	if err != nil {
-		return BackupManifest{}, err
+	return readBackupManifestWithOptions(ctx, exportStore, filename, encryption, manifestReadOptions{})
+}
+
+// readBackupManifestWithOptions is like readBackupManifest, with the manifest
+// read with opts.
+func readBackupManifestWithOptions(
+	ctx context.Context,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	opts manifestReadOptions,
+) (BackupManifest, error) {
+	stats := opts.stats
+	var phases manifestReadPhases
+	var phaseStart time.Time
+	if stats != nil {
+		phaseStart = timeutil.Now()
+	}
+	// endPhase adds the time since the end of the previous phase to d.
+	endPhase := func(d *time.Duration) {
+		if stats != nil {
+			now := timeutil.Now()
+			*d += now.Sub(phaseStart)
+			phaseStart = now
+		}
 	}
-	defer r.Close()
-	descBytes, err := ioutil.ReadAll(r)
+
+	var peek func([]byte) error
+	if encryption == nil {
+		// Don't download all of an encrypted manifest only to fail to decode it.
+		peek = func(head []byte) error {
+			if storageccl.AppearsEncrypted(head) {
+				return errors.Newf("file appears encrypted -- try specifying one of \"%s\" or \"%s\"",
+					backupOptEncPassphrase, backupOptEncKMS)
+			}
+			return nil
+		}
+	}
+	descBytes, err := readFileResumable(ctx, exportStore, filename, peek)
 	if err != nil {
 		return BackupManifest{}, err
 	}
+	storedSize := int64(len(descBytes))
 
 	checksumFile, err := exportStore.ReadFile(ctx, filename+backupManifestChecksumSuffix)
 	if err == nil {
@@ -209,11 +1481,12 @@ func readBackupManifest(
 			return BackupManifest{}, err
 		}
 	}
+	endPhase(&phases.fetch)
 
 	var encryptionKey []byte
 	if encryption != nil {
 		encryptionKey, err = getEncryptionKey(ctx, encryption, exportStore.Settings(),
-			exportStore.ExternalIOConf())
+			exportStore.ExternalIOConf(), opts.dataKeys)
 		if err != nil {
 			return BackupManifest{}, err
 		}
@@ -222,18 +1495,41 @@ func readBackupManifest(
 			return BackupManifest{}, err
 		}
 	}
+	endPhase(&phases.decrypt)
 
-	fileType := http.DetectContentType(descBytes)
-	if fileType == ZipType {
-		descBytes, err = decompressData(descBytes)
+	if bytes.HasPrefix(descBytes, dictionaryCompressionPrefix) {
+		dictPath, _, _, err := decodeDictionaryCompressedHeader(descBytes)
+		if err != nil {
+			return BackupManifest{}, err
+		}
+		dict, err := readManifestDictionary(
+			ctx, exportStore, path.Join(path.Dir(filename), dictPath), encryption, opts.dataKeys)
+		if err != nil {
+			return BackupManifest{}, err
+		}
+		endPhase(&phases.fetch)
+		descBytes, err = decompressDataWithDictionary(
+			descBytes, dict, decompressedManifestSizeLimit(exportStore))
+		if err != nil {
+			return BackupManifest{}, errors.Wrap(
+				err, "decompressing backup manifest")
+		}
+	} else if fileType := http.DetectContentType(descBytes); fileType == ZipType {
+		descBytes, err = decompressDataLimit(descBytes, decompressedManifestSizeLimit(exportStore))
 		if err != nil {
 			return BackupManifest{}, errors.Wrap(
 				err, "decompressing backup manifest")
 		}
 	}
+	endPhase(&phases.decompress)
 
 	var backupManifest BackupManifest
-	if err := protoutil.Unmarshal(descBytes, &backupManifest); err != nil {
+	if opts.skipFiles {
+		err = unmarshalBackupManifestSkippingFiles(descBytes, &backupManifest)
+	} else {
+		err = protoutil.Unmarshal(descBytes, &backupManifest)
+	}
+	if err != nil {
 		if encryption == nil && storageccl.AppearsEncrypted(descBytes) {
 			return BackupManifest{}, errors.Wrapf(
 				err, "file appears encrypted -- try specifying one of \"%s\" or \"%s\"",
@@ -241,25 +1537,38 @@ func readBackupManifest(
 		}
 		return BackupManifest{}, err
 	}
-	for _, d := range backupManifest.Descriptors {
-		// Calls to GetTable are generally frowned upon.
-		// This specific call exists to provide backwards compatibility with
-		// backups created prior to version 19.1. Starting in v19.1 the
-		// ModificationTime is always written in backups for all versions
-		// of table descriptors. In earlier cockroach versions only later
-		// table descriptor versions contain a non-empty ModificationTime.
-		// Later versions of CockroachDB use the MVCC timestamp to fill in
-		// the ModificationTime for table descriptors. When performing a restore
-		// we no longer have access to that MVCC timestamp but we can set it
-		// to a value we know will be safe.
-		//
-		// nolint:descriptormarshal
-		if t := d.GetTable(); t == nil {
-			continue
-		} else if t.Version == 1 && t.ModificationTime.IsEmpty() {
-			t.ModificationTime = hlc.Timestamp{WallTime: 1}
+	if backupManifest.FormatVersion > backupFormatMaxSupportedVersion {
+		return BackupManifest{}, errors.Newf(
+			"backup format version %d is newer than supported version %d; "+
+				"the backup must be read by a newer version of CockroachDB",
+			backupManifest.FormatVersion, backupFormatMaxSupportedVersion)
+	}
+	// Raw reads return the descriptors as stored.
+	if !opts.raw {
+		for _, d := range backupManifest.Descriptors {
+			// Calls to GetTable are generally frowned upon.
+			// This specific call exists to provide backwards compatibility with
+			// backups created prior to version 19.1. Starting in v19.1 the
+			// ModificationTime is always written in backups for all versions
+			// of table descriptors. In earlier cockroach versions only later
+			// table descriptor versions contain a non-empty ModificationTime.
+			// Later versions of CockroachDB use the MVCC timestamp to fill in
+			// the ModificationTime for table descriptors. When performing a restore
+			// we no longer have access to that MVCC timestamp but we can set it
+			// to a value we know will be safe.
+			//
+			// nolint:descriptormarshal
+			if t := d.GetTable(); t == nil {
+				continue
+			} else if t.Version == 1 && t.ModificationTime.IsEmpty() {
+				t.ModificationTime = hlc.Timestamp{WallTime: 1}
+			}
 		}
 	}
+	endPhase(&phases.unmarshal)
+	if stats != nil {
+		stats.record(storedSize, phases)
+	}
 	return backupManifest, nil
 }
 
@@ -280,7 +1589,7 @@ func readBackupPartitionDescriptor(
 	}
 	if encryption != nil {
 		encryptionKey, err := getEncryptionKey(ctx, encryption, exportStore.Settings(),
-			exportStore.ExternalIOConf())
+			exportStore.ExternalIOConf(), nil /* dataKeys */)
 		if err != nil {
 			return BackupPartitionDescriptor{}, err
 		}
@@ -292,7 +1601,7 @@ func readBackupPartitionDescriptor(
 
 	fileType := http.DetectContentType(descBytes)
 	if fileType == ZipType {
-		descBytes, err = decompressData(descBytes)
+		descBytes, err = decompressDataLimit(descBytes, decompressedManifestSizeLimit(exportStore))
 		if err != nil {
 			return BackupPartitionDescriptor{}, errors.Wrap(
 				err, "decompressing backup partition descriptor")
@@ -305,88 +1614,341 @@ func readBackupPartitionDescriptor(
 	return backupManifest, err
 }
 
-// readTableStatistics reads and unmarshals a StatsTable from filename in
-// the provided export store, and returns its pointer.
-func readTableStatistics(
+// readTableStatistics reads and unmarshals a StatsTable from filename in
+// the provided export store, and returns its pointer. The KMS data key, if
+// any, is cached in dataKeys, if set.
+func readTableStatistics(
+	ctx context.Context,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	dataKeys *dataKeyCache,
+) (*StatsTable, error) {
+	r, err := exportStore.ReadFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	statsBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if encryption != nil {
+		encryptionKey, err := getEncryptionKey(ctx, encryption, exportStore.Settings(),
+			exportStore.ExternalIOConf(), dataKeys)
+		if err != nil {
+			return nil, err
+		}
+		statsBytes, err = storageccl.DecryptFile(statsBytes, encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var tableStats StatsTable
+	if err := protoutil.Unmarshal(statsBytes, &tableStats); err != nil {
+		return nil, err
+	}
+	return &tableStats, err
+}
+
+// ResolveTableStatistics returns the freshest table statistics of the backup
+// chain described by manifests, whose layers are in stores. These are the
+// statistics of the latest layer which has any: layers which didn't collect
+// statistics, or whose statistics files are missing, are skipped in favor of
+// earlier ones. If no layer has statistics, an empty StatsTable is returned.
+func ResolveTableStatistics(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	manifests []BackupManifest,
+	encryption *jobspb.BackupEncryptionOptions,
+) (*StatsTable, error) {
+	if len(stores) != len(manifests) {
+		return nil, errors.AssertionFailedf(
+			"%d stores for a backup chain of %d layers", len(stores), len(manifests))
+	}
+	for i := len(manifests) - 1; i >= 0; i-- {
+		m := &manifests[i]
+		// Backups taken prior to 20.2 store their statistics in the manifest.
+		if len(m.DeprecatedStatistics) > 0 {
+			return &StatsTable{Statistics: m.DeprecatedStatistics}, nil
+		}
+		filenames := make([]string, 0, len(m.StatisticsFilenames))
+		seen := make(map[string]struct{}, len(m.StatisticsFilenames))
+		for _, filename := range m.StatisticsFilenames {
+			if _, ok := seen[filename]; !ok {
+				seen[filename] = struct{}{}
+				filenames = append(filenames, filename)
+			}
+		}
+		sort.Strings(filenames)
+
+		var resolved StatsTable
+		missing := false
+		for _, filename := range filenames {
+			statsTable, err := readTableStatistics(ctx, stores[i], filename, encryption, nil /* dataKeys */)
+			if err != nil {
+				if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+					log.Warningf(ctx, "statistics file %s of backup layer %d is missing, "+
+						"falling back to the statistics of earlier layers", filename, i)
+					missing = true
+					break
+				}
+				return nil, errors.Wrapf(err, "reading statistics file %s", filename)
+			}
+			resolved.Statistics = append(resolved.Statistics, statsTable.Statistics...)
+			for id, version := range statsTable.TableVersions {
+				if resolved.TableVersions == nil {
+					resolved.TableVersions = make(map[descpb.ID]descpb.DescriptorVersion)
+				}
+				resolved.TableVersions[id] = version
+			}
+		}
+		if !missing && len(filenames) > 0 {
+			return &resolved, nil
+		}
+	}
+	return &StatsTable{}, nil
+}
+
+func writeBackupManifest(
+	ctx context.Context,
+	settings *cluster.Settings,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	desc *BackupManifest,
+) error {
+	return writeBackupManifestWithOptions(ctx, settings, exportStore, filename, encryption, desc,
+		manifestWriteOptions{})
+}
+
+// writeBackupManifestWithOptions is like writeBackupManifest, with the
+// manifest written with opts.
+func writeBackupManifestWithOptions(
 	ctx context.Context,
+	settings *cluster.Settings,
 	exportStore cloud.ExternalStorage,
 	filename string,
 	encryption *jobspb.BackupEncryptionOptions,
-) (*StatsTable, error) {
-	r, err := exportStore.ReadFile(ctx, filename)
+	desc *BackupManifest,
+	opts manifestWriteOptions,
+) error {
+	descBuf, err := encodeBackupManifest(ctx, settings, exportStore, filename, encryption, desc)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer r.Close()
-	statsBytes, err := ioutil.ReadAll(r)
+	return writeEncodedBackupManifest(ctx, settings, exportStore, filename, descBuf, opts)
+}
+
+// errBackupManifestMirror marks the errors returned by multiWriteBackupManifest
+// for failures to write its mirrors.
+var errBackupManifestMirror = errors.New("failed to mirror backup manifest")
+
+// mirrorManifestToLocalities controls whether the manifest of a backup taken
+// to several localities is mirrored to each of them, in addition to being
+// written to the default one, so that losing the default locality doesn't lose
+// the backup's metadata.
+var mirrorManifestToLocalities = settings.RegisterBoolSetting(
+	"bulkio.backup.mirror_manifest_to_localities.enabled",
+	"if true, the manifest of a backup taken to several localities is mirrored to each of them",
+	false,
+)
+
+// multiWriteBackupManifest writes the manifest to each of stores, the first of
+// which is the primary destination and the rest mirrors of it, so that losing
+// the metadata in one destination doesn't lose the backup. The manifest is
+// encoded once, using the primary for any dictionary and encryption
+// configuration, and the writes are made concurrently. The compression
+// dictionary of the manifest, if any, is copied from the primary to each mirror
+// before the manifest is written to it, so that the mirrors can be read on
+// their own.
+//
+// Mirroring is best-effort and not transactional: the manifest is committed
+// once it has been written to the primary, regardless of whether the mirrors
+// were written. The returned error combines the failures of every destination;
+// if only mirrors failed, it is marked with errBackupManifestMirror, so that
+// callers may tell that the manifest was nonetheless committed.
+func multiWriteBackupManifest(
+	ctx context.Context,
+	settings *cluster.Settings,
+	stores []cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	desc *BackupManifest,
+	opts manifestWriteOptions,
+) error {
+	if len(stores) == 0 {
+		return errors.New("no backup locations to write the manifest to")
+	}
+	descBuf, err := encodeBackupManifest(ctx, settings, stores[0], filename, encryption, desc)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if encryption != nil {
-		encryptionKey, err := getEncryptionKey(ctx, encryption, exportStore.Settings(),
-			exportStore.ExternalIOConf())
-		if err != nil {
-			return nil, err
+	var dict []byte
+	dictName := path.Join(path.Dir(filename), desc.DictionaryPath)
+	if desc.DictionaryPath != "" && len(stores) > 1 {
+		// The dictionary is copied as stored, i.e. encrypted if the manifest is.
+		if dict, err = readFileResumable(ctx, stores[0], dictName, nil /* peek */); err != nil {
+			return errors.Wrap(err, "reading backup manifest compression dictionary")
 		}
-		statsBytes, err = storageccl.DecryptFile(statsBytes, encryptionKey)
-		if err != nil {
-			return nil, err
+	}
+	errs := make([]error, len(stores))
+	if err := ctxgroup.GroupWorkers(ctx, len(stores), func(ctx context.Context, i int) error {
+		if i > 0 && dict != nil {
+			if errs[i] = writeMetadataFile(ctx, stores[i], dictName, dict, opts); errs[i] != nil {
+				return nil
+			}
 		}
+		errs[i] = writeEncodedBackupManifest(ctx, settings, stores[i], filename, descBuf, opts)
+		return nil
+	}); err != nil {
+		return err
 	}
-	var tableStats StatsTable
-	if err := protoutil.Unmarshal(statsBytes, &tableStats); err != nil {
-		return nil, err
+
+	var mirrorErr error
+	for i := 1; i < len(errs); i++ {
+		if errs[i] != nil {
+			mirrorErr = errors.CombineErrors(mirrorErr, errors.Wrapf(errs[i], "mirror %d", i))
+		}
 	}
-	return &tableStats, err
+	if errs[0] != nil {
+		return errors.CombineErrors(errs[0], mirrorErr)
+	}
+	if mirrorErr != nil {
+		return errors.Mark(errors.Wrap(mirrorErr, "mirroring backup manifest"), errBackupManifestMirror)
+	}
+	return nil
 }
 
-func writeBackupManifest(
+// requiredBackupFormatVersion returns the format version needed to read desc,
+// i.e. that of the newest format feature it uses, so that readers which don't
+// know about the feature refuse it rather than misinterpret it.
+func requiredBackupFormatVersion(desc *BackupManifest) uint32 {
+	for i := range desc.Files {
+		if len(desc.Files[i].InlineData) > 0 {
+			return backupFormatInlineFilesVersion
+		}
+	}
+	if desc.DictionaryPath != "" {
+		return backupFormatDictionaryCompressionVersion
+	}
+	if desc.DescriptorsElided {
+		return backupFormatElidedDescriptorsVersion
+	}
+	return 0
+}
+
+// encodeBackupManifest marshals, compresses and, if requested, encrypts desc, to
+// be written to filename in exportStore.
+func encodeBackupManifest(
 	ctx context.Context,
 	settings *cluster.Settings,
 	exportStore cloud.ExternalStorage,
 	filename string,
 	encryption *jobspb.BackupEncryptionOptions,
 	desc *BackupManifest,
-) error {
-	sort.Sort(BackupFileDescriptors(desc.Files))
+) ([]byte, error) {
+	sortBackupFiles(desc.Files, int(manifestParallelSortThreshold.Get(&settings.SV)))
+	if v := requiredBackupFormatVersion(desc); desc.FormatVersion < v {
+		desc.FormatVersion = v
+	}
 
 	descBuf, err := protoutil.Marshal(desc)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	descBuf, err = compressData(descBuf)
-	if err != nil {
-		return errors.Wrap(err, "compressing backup manifest")
+	if desc.DictionaryPath != "" {
+		dictPath := path.Join(path.Dir(filename), desc.DictionaryPath)
+		dict, err := readManifestDictionary(ctx, exportStore, dictPath, encryption, nil /* dataKeys */)
+		if err != nil {
+			return nil, err
+		}
+		descBuf, err = compressDataWithDictionary(descBuf, dict, desc.DictionaryPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "compressing backup manifest")
+		}
+	} else {
+		descBuf, err = compressData(descBuf)
+		if err != nil {
+			return nil, errors.Wrap(err, "compressing backup manifest")
+		}
 	}
 
 	if encryption != nil {
-		encryptionKey, err := getEncryptionKey(ctx, encryption, settings, exportStore.ExternalIOConf())
+		encryptionKey, err := getEncryptionKey(ctx, encryption, settings, exportStore.ExternalIOConf(),
+			nil /* dataKeys */)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		descBuf, err = storageccl.EncryptFile(descBuf, encryptionKey)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return descBuf, nil
+}
+
+// verifyManifestWrites makes writeBackupManifest read back each manifest it
+// writes, to catch stores which silently truncate or corrupt writes when the
+// backup is taken rather than when it is restored.
+var verifyManifestWrites = settings.RegisterBoolSetting(
+	"bulkio.backup.verify_manifest_writes.enabled",
+	"read back every backup manifest after writing it and fail the backup if it differs "+
+		"from what was written",
+	false,
+)
 
-	if err := exportStore.WriteFile(ctx, filename, bytes.NewReader(descBuf)); err != nil {
+// writeEncodedBackupManifest writes a manifest encoded by encodeBackupManifest
+// to filename in exportStore, followed by its checksum. If verifyManifestWrites
+// is set, the manifest is read back before its checksum is written.
+func writeEncodedBackupManifest(
+	ctx context.Context,
+	settings *cluster.Settings,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	descBuf []byte,
+	opts manifestWriteOptions,
+) error {
+	if err := writeMetadataFile(ctx, exportStore, filename, descBuf, opts); err != nil {
 		return err
 	}
+	if settings != nil && verifyManifestWrites.Get(&settings.SV) {
+		if err := verifyWrittenFile(ctx, exportStore, filename, descBuf); err != nil {
+			return err
+		}
+	}
 
 	// Write the checksum file after we've successfully wrote the manifest.
 	checksum, err := getChecksum(descBuf)
 	if err != nil {
 		return errors.Wrap(err, "calculating checksum")
 	}
-	if err := exportStore.WriteFile(ctx, filename+backupManifestChecksumSuffix, bytes.NewReader(checksum)); err != nil {
+	if err := writeMetadataFile(
+		ctx, exportStore, filename+backupManifestChecksumSuffix, checksum, opts,
+	); err != nil {
 		return errors.Wrap(err, "writing manifest checksum")
 	}
 
 	return nil
 }
 
+// verifyWrittenFile reads filename back from store and returns an error if it
+// doesn't contain exactly the written data.
+func verifyWrittenFile(
+	ctx context.Context, store cloud.ExternalStorage, filename string, written []byte,
+) error {
+	read, err := readFileResumable(ctx, store, filename, nil /* peek */)
+	if err != nil {
+		return errors.Wrapf(err, "reading back %s to verify it", filename)
+	}
+	if !bytes.Equal(read, written) {
+		return errors.Errorf("%s was corrupted when written: read back %d bytes which differ "+
+			"from the %d bytes written", filename, len(read), len(written))
+	}
+	return nil
+}
+
 // getChecksum returns a 32 bit keyed-checksum for the given data.
 func getChecksum(data []byte) ([]byte, error) {
 	const checksumSizeBytes = 4
@@ -398,11 +1960,145 @@ func getChecksum(data []byte) ([]byte, error) {
 	return hash.Sum(nil)[:checksumSizeBytes], nil
 }
 
+// BackupDigest returns a SHA256 digest covering the metadata files of the
+// backup described by manifest and of the incremental layers appended to it:
+// the manifest of each layer and its checksum, partition descriptors and
+// statistics, and the encryption info, compression dictionary and layers index
+// they share. The files are hashed as they are stored, along with their names,
+// in a canonical order, so that the digest can be recorded when a backup is
+// taken and compared before it is restored to detect tampering with any of
+// them. Files other than the manifests and partition descriptors may be absent,
+// which is reflected in the digest. The partition descriptors are looked up in
+// all of stores; the other files must be in stores[0], the default locality.
+// The manifests of the appended layers are read with encryption to find their
+// partition descriptors and statistics. If the layers of stores[0] can't be
+// found, e.g. because it can't be listed, only the base backup is covered.
+func BackupDigest(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	manifest BackupManifest,
+	encryption *jobspb.BackupEncryptionOptions,
+) ([]byte, error) {
+	if len(stores) == 0 {
+		return nil, errors.New("no backup locations to digest")
+	}
+	hash := sha256.New()
+	add := func(store cloud.ExternalStorage, filename string, optional bool) (bool, error) {
+		r, err := store.ReadFile(ctx, filename)
+		if err != nil {
+			if optional && errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+				// Hash the absence of the file, so that removing it changes the digest.
+				fmt.Fprintf(hash, "%s\x00-\n", filename)
+				return true, nil
+			}
+			return false, err
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return false, errors.Wrapf(err, "reading %s", filename)
+		}
+		fmt.Fprintf(hash, "%s\x00%d\n", filename, len(data))
+		_, _ = hash.Write(data)
+		return true, nil
+	}
+	manifestName := func(dir string) string {
+		name := path.Join(dir, backupManifestName)
+		if _, err := stores[0].Size(ctx, name); errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+			return path.Join(dir, backupOldManifestName)
+		}
+		return name
+	}
+	// addLayer hashes the metadata files of the layer described by m in the
+	// directory dir.
+	addLayer := func(dir string, m BackupManifest) error {
+		filename := manifestName(dir)
+		if _, err := add(stores[0], filename, false); err != nil {
+			return err
+		}
+		if _, err := add(stores[0], filename+backupManifestChecksumSuffix, true); err != nil {
+			return err
+		}
+
+		partitions := append([]string(nil), m.PartitionDescriptorFilenames...)
+		sort.Strings(partitions)
+		for _, filename := range partitions {
+			filename = path.Join(dir, filename)
+			found := false
+			for _, store := range stores {
+				var err error
+				if found, err = add(store, filename, false); err == nil {
+					break
+				} else if !errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+					return err
+				}
+			}
+			if !found {
+				return errors.Errorf("expected manifest %s not found in backup locations", filename)
+			}
+		}
+
+		statsFiles := make([]string, 0, len(m.StatisticsFilenames))
+		for _, filename := range m.StatisticsFilenames {
+			statsFiles = append(statsFiles, filename)
+		}
+		if len(statsFiles) == 0 {
+			statsFiles = append(statsFiles, backupStatisticsFileName)
+		}
+		sort.Strings(statsFiles)
+		for i, filename := range statsFiles {
+			if i > 0 && filename == statsFiles[i-1] {
+				continue
+			}
+			if _, err := add(stores[0], path.Join(dir, filename), true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := addLayer("", manifest); err != nil {
+		return nil, err
+	}
+	for _, filename := range []string{
+		backupEncryptionInfoFile, backupManifestDictionaryName, backupLayersIndexName,
+	} {
+		if _, err := add(stores[0], filename, true); err != nil {
+			return nil, err
+		}
+	}
+
+	layers, err := findPriorBackupLocations(ctx, stores[0])
+	if err != nil {
+		if !errors.Is(err, cloudimpl.ErrListingUnsupported) {
+			return nil, err
+		}
+		log.Warningf(ctx, "storage sink %T does not support listing, only digesting the base backup",
+			stores[0])
+		layers = nil
+	}
+	for _, dir := range layers {
+		m, err := readBackupManifest(ctx, stores[0], manifestName(dir), encryption)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading backup layer %s", dir)
+		}
+		if err := addLayer(dir, m); err != nil {
+			return nil, errors.Wrapf(err, "digesting backup layer %s", dir)
+		}
+	}
+	return hash.Sum(nil), nil
+}
+
+// getEncryptionKey returns the key with which the files of a backup are
+// encrypted with encryption, decrypting its data key with its KMS if needed. If
+// dataKeys is set, the decrypted data keys are cached in it, so that a job
+// which reads many files asks the KMS to decrypt each of them only once.
 func getEncryptionKey(
 	ctx context.Context,
 	encryption *jobspb.BackupEncryptionOptions,
 	settings *cluster.Settings,
 	ioConf base.ExternalIODirConfig,
+	dataKeys *dataKeyCache,
 ) ([]byte, error) {
 	if encryption == nil {
 		return nil, errors.New("FileEncryptionOptions is nil when retrieving encryption key")
@@ -411,28 +2107,61 @@ func getEncryptionKey(
 	case jobspb.EncryptionMode_Passphrase:
 		return encryption.Key, nil
 	case jobspb.EncryptionMode_KMS:
+		decrypt := func() ([]byte, error) {
+			return decryptDataKeyWithTimeout(ctx, encryption.KMSInfo, &backupKMSEnv{
+				settings: settings,
+				conf:     &ioConf,
+			}, kmsTimeout.Get(&settings.SV))
+		}
+		if dataKeys != nil {
+			return dataKeys.get(encryption.KMSInfo.Uri, encryption.KMSInfo.EncryptedDataKey, decrypt)
+		}
+		return decrypt()
+	}
+
+	return nil, errors.New("invalid encryption mode")
+}
+
+// kmsTimeout bounds the time spent waiting on a KMS to decrypt a data key, so
+// that an unresponsive KMS fails the operation instead of hanging it.
+var kmsTimeout = settings.RegisterDurationSetting(
+	"bulkio.backup.kms.timeout",
+	"the amount of time to wait for a KMS to decrypt a backup's data key before failing (0 to wait indefinitely)",
+	30*time.Second,
+	settings.NonNegativeDuration,
+)
+
+// decryptDataKeyWithTimeout contacts the KMS described by info to decrypt its
+// data key, giving up if it doesn't respond within timeout, if set.
+func decryptDataKeyWithTimeout(
+	ctx context.Context,
+	info *jobspb.BackupEncryptionOptions_KMSInfo,
+	env cloud.KMSEnv,
+	timeout time.Duration,
+) ([]byte, error) {
+	var plaintextDataKey []byte
+	decrypt := func(ctx context.Context) error {
 		// Contact the selected KMS to derive the decrypted data key.
-		kms, err := cloud.KMSFromURI(encryption.KMSInfo.Uri, &backupKMSEnv{
-			settings: settings,
-			conf:     &ioConf,
-		})
+		kms, err := cloud.KMSFromURI(info.Uri, env)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
 		defer func() {
 			_ = kms.Close()
 		}()
-
-		plaintextDataKey, err := kms.Decrypt(ctx, encryption.KMSInfo.EncryptedDataKey)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to decrypt data key")
-		}
-
-		return plaintextDataKey, nil
+		plaintextDataKey, err = kms.Decrypt(ctx, info.EncryptedDataKey)
+		return errors.Wrap(err, "failed to decrypt data key")
 	}
-
-	return nil, errors.New("invalid encryption mode")
+	var err error
+	if timeout > 0 {
+		err = contextutil.RunWithTimeout(ctx, "decrypting backup data key with KMS", timeout, decrypt)
+	} else {
+		err = decrypt(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plaintextDataKey, nil
 }
 
 // writeBackupPartitionDescriptor writes metadata (containing a locality KV and
@@ -445,6 +2174,26 @@ func writeBackupPartitionDescriptor(
 	encryption *jobspb.BackupEncryptionOptions,
 	desc *BackupPartitionDescriptor,
 ) error {
+	return writeBackupPartitionDescriptorWithOptions(ctx, exportStore, filename, encryption, desc,
+		manifestWriteOptions{})
+}
+
+// writeBackupPartitionDescriptorWithOptions is like
+// writeBackupPartitionDescriptor, with the descriptor written with opts.
+func writeBackupPartitionDescriptorWithOptions(
+	ctx context.Context,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	desc *BackupPartitionDescriptor,
+	opts manifestWriteOptions,
+) error {
+	// The locality is parsed when the backup is restored, so a malformed one
+	// must fail the backup rather than leave it unrestorable.
+	var tier roachpb.Tier
+	if err := tier.FromString(desc.LocalityKV); err != nil {
+		return errors.Wrapf(err, "invalid locality of backup partition descriptor %s", filename)
+	}
 	descBuf, err := protoutil.Marshal(desc)
 	if err != nil {
 		return err
@@ -455,7 +2204,7 @@ func writeBackupPartitionDescriptor(
 	}
 	if encryption != nil {
 		encryptionKey, err := getEncryptionKey(ctx, encryption, exportStore.Settings(),
-			exportStore.ExternalIOConf())
+			exportStore.ExternalIOConf(), nil /* dataKeys */)
 		if err != nil {
 			return err
 		}
@@ -465,7 +2214,7 @@ func writeBackupPartitionDescriptor(
 		}
 	}
 
-	return exportStore.WriteFile(ctx, filename, bytes.NewReader(descBuf))
+	return writeMetadataFile(ctx, exportStore, filename, descBuf, opts)
 }
 
 // writeTableStatistics writes a StatsTable object to a file of the filename
@@ -477,6 +2226,20 @@ func writeTableStatistics(
 	filename string,
 	encryption *jobspb.BackupEncryptionOptions,
 	stats *StatsTable,
+) error {
+	return writeTableStatisticsWithOptions(ctx, exportStore, filename, encryption, stats,
+		manifestWriteOptions{})
+}
+
+// writeTableStatisticsWithOptions is like writeTableStatistics, with the
+// statistics written with opts.
+func writeTableStatisticsWithOptions(
+	ctx context.Context,
+	exportStore cloud.ExternalStorage,
+	filename string,
+	encryption *jobspb.BackupEncryptionOptions,
+	stats *StatsTable,
+	opts manifestWriteOptions,
 ) error {
 	statsBuf, err := protoutil.Marshal(stats)
 	if err != nil {
@@ -484,7 +2247,7 @@ func writeTableStatistics(
 	}
 	if encryption != nil {
 		encryptionKey, err := getEncryptionKey(ctx, encryption, exportStore.Settings(),
-			exportStore.ExternalIOConf())
+			exportStore.ExternalIOConf(), nil /* dataKeys */)
 		if err != nil {
 			return err
 		}
@@ -493,7 +2256,7 @@ func writeTableStatistics(
 			return err
 		}
 	}
-	return exportStore.WriteFile(ctx, filename, bytes.NewReader(statsBuf))
+	return writeMetadataFile(ctx, exportStore, filename, statsBuf, opts)
 }
 
 func loadBackupManifests(
@@ -502,12 +2265,13 @@ func loadBackupManifests(
 	user security.SQLUsername,
 	makeExternalStorageFromURI cloud.ExternalStorageFromURIFactory,
 	encryption *jobspb.BackupEncryptionOptions,
+	opts manifestReadOptions,
 ) ([]BackupManifest, error) {
 	backupManifests := make([]BackupManifest, len(uris))
 
 	for i, uri := range uris {
-		desc, err := ReadBackupManifestFromURI(ctx, uri, user, makeExternalStorageFromURI,
-			encryption)
+		desc, err := readBackupManifestFromURI(ctx, uri, user, makeExternalStorageFromURI,
+			encryption, opts)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to read backup descriptor")
 		}
@@ -516,7 +2280,7 @@ func loadBackupManifests(
 	if len(backupManifests) == 0 {
 		return nil, errors.Newf("no backups found")
 	}
-	return backupManifests, nil
+	return inflateElidedDescriptors(backupManifests)
 }
 
 // getLocalityInfo takes a list of stores and their URIs, along with the main
@@ -530,56 +2294,241 @@ func getLocalityInfo(
 	encryption *jobspb.BackupEncryptionOptions,
 	prefix string,
 ) (jobspb.RestoreDetails_BackupLocalityInfo, error) {
+	info, _, err := getLocalityInfoAndSizes(ctx, stores, uris, mainBackupManifest, encryption, prefix)
+	return info, err
+}
+
+// BackupLocalitySizes returns the amount of data, in bytes, held by each
+// locality of the partitioned backup described by mainBackupManifest, whose
+// locations are the stores and their URIs. The sizes are keyed by the original
+// locality of each partition, and the data in the default locality is reported
+// under defaultLocalityValue.
+func BackupLocalitySizes(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	uris []string,
+	mainBackupManifest BackupManifest,
+	encryption *jobspb.BackupEncryptionOptions,
+	prefix string,
+) (map[string]uint64, error) {
+	_, sizes, err := getLocalityInfoAndSizes(ctx, stores, uris, mainBackupManifest, encryption, prefix)
+	return sizes, err
+}
+
+// getLocalityInfoAndSizes is getLocalityInfo, additionally returning the sizes
+// of the partitions it finds, as described by BackupLocalitySizes.
+func getLocalityInfoAndSizes(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	uris []string,
+	mainBackupManifest BackupManifest,
+	encryption *jobspb.BackupEncryptionOptions,
+	prefix string,
+) (jobspb.RestoreDetails_BackupLocalityInfo, map[string]uint64, error) {
 	var info jobspb.RestoreDetails_BackupLocalityInfo
-	// Now get the list of expected partial per-store backup manifest filenames
-	// and attempt to find them.
-	urisByOrigLocality := make(map[string]string)
+	sizes := make(map[string]uint64)
+	partitions, err := findBackupPartitions(ctx, stores, mainBackupManifest, encryption, prefix)
+	if err != nil {
+		return info, nil, err
+	}
+	urisByOrigLocality := make(map[string]string, len(partitions))
+	for origLocalityKV, p := range partitions {
+		urisByOrigLocality[origLocalityKV] = uris[p.store]
+		for _, f := range p.desc.Files {
+			sizes[origLocalityKV] += uint64(f.EntryCounts.DataSize)
+		}
+	}
+	if st := stores[0].Settings(); st != nil && strictPartitionDescriptorsEnabled.Get(&st.SV) {
+		if err := checkNoUnlistedPartitionDescriptors(
+			ctx, stores, uris, mainBackupManifest, prefix,
+		); err != nil {
+			return info, nil, err
+		}
+	}
+	info.URIsByOriginalLocalityKV = urisByOrigLocality
+	// The main manifest lists the files of every locality, of which those of
+	// the partitions were already counted.
+	var defaultSize uint64
+	for _, f := range mainBackupManifest.Files {
+		if _, ok := urisByOrigLocality[f.LocalityKV]; !ok {
+			defaultSize += uint64(f.EntryCounts.DataSize)
+		}
+	}
+	sizes[defaultLocalityValue] = defaultSize
+	return info, sizes, nil
+}
+
+// backupPartition is a partition of a backup, as found by findBackupPartitions.
+type backupPartition struct {
+	// store is the index of the location holding the partition.
+	store int
+	desc  BackupPartitionDescriptor
+}
+
+// findBackupPartitions searches stores for the partition descriptors listed in
+// mainBackupManifest, returning the partitions found, keyed by their original
+// locality. The descriptors are in the directory prefix of the stores.
+func findBackupPartitions(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	mainBackupManifest BackupManifest,
+	encryption *jobspb.BackupEncryptionOptions,
+	prefix string,
+) (map[string]backupPartition, error) {
+	partitions := make(map[string]backupPartition)
+	for _, filename := range mainBackupManifest.PartitionDescriptorFilenames {
+		if prefix != "" {
+			filename = path.Join(prefix, filename)
+		}
+		found := false
+		for i, store := range stores {
+			if desc, err := readBackupPartitionDescriptor(ctx, store, filename, encryption); err == nil {
+				if desc.BackupID != mainBackupManifest.ID {
+					return nil, errors.Errorf(
+						"expected backup part to have backup ID %s, found %s",
+						mainBackupManifest.ID, desc.BackupID,
+					)
+				}
+				origLocalityKV := desc.LocalityKV
+				kv := roachpb.Tier{}
+				if err := kv.FromString(origLocalityKV); err != nil {
+					return nil, errors.Wrapf(err, "reading backup partition descriptor %s", filename)
+				}
+				if _, ok := partitions[origLocalityKV]; ok {
+					return nil, errors.Errorf("duplicate locality %s found in backup", origLocalityKV)
+				}
+				partitions[origLocalityKV] = backupPartition{store: i, desc: desc}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("expected manifest %s not found in backup locations", filename)
+		}
+	}
+	return partitions, nil
+}
+
+// defaultRestoreThroughputBytesPerSec is the restore throughput assumed by
+// EstimateRestoreDuration when none is given.
+const defaultRestoreThroughputBytesPerSec = 50 << 20 // 50 MiB/s
+
+// backupChainDataSize returns the amount of data, in bytes, in the files of the
+// chain of backups in backupManifests. A file listed more than once, whether in
+// the same layer or in several of them, is only counted once.
+func backupChainDataSize(backupManifests []BackupManifest) uint64 {
+	type fileKey struct {
+		dir, locality, path string
+	}
+	seen := make(map[fileKey]struct{})
+	var size uint64
+	for i := range backupManifests {
+		m := &backupManifests[i]
+		dir := m.Dir.String()
+		for j := range m.Files {
+			f := &m.Files[j]
+			key := fileKey{dir: dir, locality: f.LocalityKV, path: path.Clean(f.Path)}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			size += uint64(f.EntryCounts.DataSize)
+		}
+	}
+	return size
+}
+
+// EstimateRestoreDuration returns a rough estimate of the time it takes to
+// restore the chain of backups in backupManifests, as resolved for the
+// restore, at the given throughput, or at defaultRestoreThroughputBytesPerSec
+// if it is zero, e.g. one observed for past restores on the cluster. It only
+// accounts for the size of the data to restore, not for the number of files or
+// for the size of the cluster restoring it.
+func EstimateRestoreDuration(
+	backupManifests []BackupManifest, throughputBytesPerSec uint64,
+) time.Duration {
+	if throughputBytesPerSec == 0 {
+		throughputBytesPerSec = defaultRestoreThroughputBytesPerSec
+	}
+	seconds := float64(backupChainDataSize(backupManifests)) / float64(throughputBytesPerSec)
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// strictPartitionDescriptorsEnabled controls whether the locations of a
+// partitioned backup are checked for partition descriptors which its main
+// manifest doesn't list. The files of such a partition would never be restored.
+var strictPartitionDescriptorsEnabled = settings.RegisterBoolSetting(
+	"bulkio.restore.strict_partition_descriptors.enabled",
+	"fail restores of backups whose locations hold partition descriptors which aren't listed by "+
+		"the backup's manifest; the locations must support listing",
+	false,
+)
+
+// checkNoUnlistedPartitionDescriptors returns an error if any of the stores,
+// whose URIs are uris, holds a partition descriptor in prefix which isn't one
+// of those listed in mainBackupManifest. The stores must support listing.
+func checkNoUnlistedPartitionDescriptors(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	uris []string,
+	mainBackupManifest BackupManifest,
+	prefix string,
+) error {
+	listed := make(map[string]struct{}, len(mainBackupManifest.PartitionDescriptorFilenames))
 	for _, filename := range mainBackupManifest.PartitionDescriptorFilenames {
-		if prefix != "" {
-			filename = path.Join(prefix, filename)
+		listed[path.Join(prefix, filename)] = struct{}{}
+	}
+	for i, store := range stores {
+		filenames, err := store.ListFiles(ctx, path.Join(prefix, backupPartitionDescriptorPrefix+"_*"))
+		if err != nil {
+			return errors.Wrapf(err, "listing backup partition descriptors in %s to check them against "+
+				"the backup's manifest", RedactURIForErrorMessage(uris[i]))
 		}
-		found := false
-		for i, store := range stores {
-			if desc, err := readBackupPartitionDescriptor(ctx, store, filename, encryption); err == nil {
-				if desc.BackupID != mainBackupManifest.ID {
-					return info, errors.Errorf(
-						"expected backup part to have backup ID %s, found %s",
-						mainBackupManifest.ID, desc.BackupID,
-					)
-				}
-				origLocalityKV := desc.LocalityKV
-				kv := roachpb.Tier{}
-				if err := kv.FromString(origLocalityKV); err != nil {
-					return info, errors.Wrapf(err, "reading backup manifest from %s",
-						RedactURIForErrorMessage(uris[i]))
-				}
-				if _, ok := urisByOrigLocality[origLocalityKV]; ok {
-					return info, errors.Errorf("duplicate locality %s found in backup", origLocalityKV)
-				}
-				urisByOrigLocality[origLocalityKV] = uris[i]
-				found = true
-				break
+		var unlisted []string
+		for _, filename := range filenames {
+			if _, ok := listed[path.Clean(filename)]; !ok {
+				unlisted = append(unlisted, filename)
 			}
 		}
-		if !found {
-			return info, errors.Errorf("expected manifest %s not found in backup locations", filename)
+		if len(unlisted) > 0 {
+			sort.Strings(unlisted)
+			return errors.WithHint(
+				errors.Newf("backup location %s holds partition descriptors which the backup's manifest "+
+					"doesn't list, whose data wouldn't be restored: %s",
+					RedactURIForErrorMessage(uris[i]), strings.Join(unlisted, ", ")),
+				"the manifest may be out of date or the location may hold another backup's files")
 		}
 	}
-	info.URIsByOriginalLocalityKV = urisByOrigLocality
-	return info, nil
+	return nil
 }
 
 const incBackupSubdirGlob = "[0-9]*/[0-9]*.[0-9][0-9]/"
 
+// backupLayersIndexEnabled controls whether the incremental layers appended
+// to a backup are recorded in an index, which is then read in place of a
+// listing of the backup's directory to find them. Nodes running older versions
+// don't record the layers they append, so the index is only maintained once
+// the cluster version is BackupLayersIndex; see readBackupLayersIndex for how
+// layers appended without being recorded, e.g. by another cluster, are
+// detected.
+var backupLayersIndexEnabled = settings.RegisterBoolSetting(
+	"bulkio.backup.layer_index.enabled",
+	"if true, the incremental layers appended to a backup are recorded in an index, "+
+		"so that they can be found without listing the backup's directory",
+	false,
+)
+
 // findPriorBackupNames finds "appended" incremental backups, as done by
 // findPriorBackupLocations and appends the backup manifest file name to
 // the URI.
 func findPriorBackupNames(ctx context.Context, store cloud.ExternalStorage) ([]string, error) {
-	prev, err := store.ListFiles(ctx, incBackupSubdirGlob+backupManifestName)
+	if indexed, ok, _, err := readBackupLayersIndex(ctx, store); err != nil || ok {
+		return indexed, err
+	}
+	prev, err := listPriorBackupLayers(ctx, store, backupManifestName, false /* trimManifestName */)
 	if err != nil {
 		return nil, errors.Wrap(err, "reading previous backup layers")
 	}
-	sort.Strings(prev)
 	return prev, nil
 }
 
@@ -587,29 +2536,328 @@ func findPriorBackupNames(ctx context.Context, store cloud.ExternalStorage) ([]s
 // for the subdirectories matching the naming pattern (e.g. YYMMDD/HHmmss.ss).
 // Using file-system searching rather than keeping an explicit list allows
 // layers to be manually moved/removed/etc without needing to update/maintain
-// said list.
+// said list. Backups with very many layers may however keep such a list, see
+// backupLayersIndexEnabled, in which case it is used instead.
 func findPriorBackupLocations(ctx context.Context, store cloud.ExternalStorage) ([]string, error) {
-	backupManifestSuffix := backupManifestName
-	prev, err := store.ListFiles(ctx, incBackupSubdirGlob+backupManifestSuffix)
+	prev, _, err := findPriorBackupLocationsAndIndex(ctx, store)
+	return prev, err
+}
+
+// findPriorBackupLocationsAndIndex is like findPriorBackupLocations, but also
+// returns whether the backup has an index of its layers, whether or not it
+// could be used.
+func findPriorBackupLocationsAndIndex(
+	ctx context.Context, store cloud.ExternalStorage,
+) ([]string, bool, error) {
+	indexed, ok, indexExists, err := readBackupLayersIndex(ctx, store)
+	if err != nil || ok {
+		for i := range indexed {
+			indexed[i] = path.Dir(indexed[i])
+		}
+		return indexed, indexExists, err
+	}
+
+	prev, err := listPriorBackupLayers(ctx, store, backupManifestName, true /* trimManifestName */)
 	if err != nil {
-		return nil, errors.Wrap(err, "reading previous backup layers")
+		return nil, false, errors.Wrap(err, "reading previous backup layers")
 	}
 
 	if len(prev) == 0 {
 		// 20.1 nodes and earlier will have an oldBackupManifestName so we check for
 		// that too.
-		backupManifestSuffix = backupOldManifestName
-		prev, err = store.ListFiles(ctx, incBackupSubdirGlob+backupManifestSuffix)
+		prev, err = listPriorBackupLayers(ctx, store, backupOldManifestName, true /* trimManifestName */)
 		if err != nil {
-			return nil, errors.Wrap(err, "reading previous backup layers")
+			return nil, false, errors.Wrap(err, "reading previous backup layers")
+		}
+	}
+	return prev, indexExists, nil
+}
+
+// listPriorBackupLayers returns the sorted paths of the manifests named
+// manifestName of the incremental layers in store, or of the subdirectories
+// they are in if trimManifestName is set. The names of the subdirectories are
+// timestamps, so this is the order in which the layers were written.
+//
+// Collections can have tens of thousands of layers, and stores such as S3 list
+// every file under the collection to find them, so if store lists its files in
+// pages, the layers of each page are added to the result as the page is listed
+// rather than collecting the whole listing first. Pages are usually listed in
+// order, in which case each one just extends the result.
+func listPriorBackupLayers(
+	ctx context.Context, store cloud.ExternalStorage, manifestName string, trimManifestName bool,
+) ([]string, error) {
+	var layers []string
+	add := func(page []string) error {
+		start := len(layers)
+		for _, f := range page {
+			if trimManifestName {
+				f = strings.TrimSuffix(f, "/"+manifestName)
+			}
+			layers = append(layers, f)
+		}
+		if added := layers[start:]; !sort.StringsAreSorted(added) {
+			sort.Strings(added)
+		}
+		if start > 0 && start < len(layers) && layers[start] < layers[start-1] {
+			sort.Strings(layers)
+		}
+		return nil
+	}
+	if pl, ok := store.(cloud.PaginatedListStorage); ok {
+		if err := pl.ListFilesPaginated(ctx, incBackupSubdirGlob+manifestName, add); err != nil {
+			return nil, err
+		}
+		return layers, nil
+	}
+	layers, err := store.ListFiles(ctx, incBackupSubdirGlob+manifestName)
+	if err != nil {
+		return nil, err
+	}
+	if trimManifestName {
+		for i := range layers {
+			layers[i] = strings.TrimSuffix(layers[i], "/"+manifestName)
 		}
 	}
+	sort.Strings(layers)
+	return layers, nil
+}
 
-	for i := range prev {
-		prev[i] = strings.TrimSuffix(prev[i], "/"+backupManifestSuffix)
+// incrementalLayerURIs returns the URIs of the incremental layer in the
+// subdirectory subDir of each of the partitions of a backup at baseURIs. Only
+// the paths of the URIs are changed, so the rest of each URI, e.g. the query
+// parameters used to authenticate with the storage it points to, is preserved.
+// If a base URI's path was escaped in a non-default way, that escaping is
+// preserved too, since it may be needed to address the same objects.
+func incrementalLayerURIs(baseURIs []*url.URL, subDir string) []string {
+	uris := make([]string, len(baseURIs))
+	for i := range baseURIs {
+		u := *baseURIs[i] // NB: makes a copy to avoid mutating the baseURI.
+		if u.RawPath != "" {
+			u.RawPath = path.Join(u.RawPath, subDir)
+		}
+		u.Path = path.Join(u.Path, subDir)
+		uris[i] = u.String()
+	}
+	return uris
+}
+
+// backupLayersIndexMaxCheckedDays bounds the number of days after the last
+// layer listed in the index of a backup whose layers are listed to check that
+// no layer was appended to the backup without being recorded. An index whose
+// last layer is older than that is ignored.
+const backupLayersIndexMaxCheckedDays = 7
+
+// readBackupLayersIndex returns the manifests of the incremental layers listed
+// in the index of the backup in store, in the order in which they were
+// appended, whether the index was found and is consistent with the backup, and
+// whether the backup has an index at all, consistent or not.
+// An index which can't be used, e.g. because it is malformed, lists a layer
+// which has since been removed or misses a layer newer than those it lists, is
+// ignored so that the layers are found by listing the directory instead.
+//
+// Layers are named after the time at which they were taken, so an unrecorded
+// layer newer than those listed is looked for in the directory of each day
+// from that of the last listed layer until today, each of which only holds the
+// files of that day's layers. An unrecorded layer older than the last listed
+// one, which could only have been named after a lagging clock, isn't detected.
+func readBackupLayersIndex(
+	ctx context.Context, store cloud.ExternalStorage,
+) ([]string, bool, bool, error) {
+	r, err := store.ReadFile(ctx, backupLayersIndexName)
+	if err != nil {
+		if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+			return nil, false, false, nil
+		}
+		return nil, false, false, errors.Wrap(err, "reading backup layers index")
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, false, true, errors.Wrap(err, "reading backup layers index")
+	}
+
+	layers := strings.Fields(string(buf))
+	for i, layer := range layers {
+		if ok, _ := path.Match(incBackupSubdirGlob+"*", layer); !ok {
+			log.Warningf(ctx, "ignoring backup layers index listing malformed layer %q", layer)
+			return nil, false, true, nil
+		}
+		if base := path.Base(layer); base != backupManifestName && base != backupOldManifestName {
+			log.Warningf(ctx, "ignoring backup layers index listing malformed layer %q", layer)
+			return nil, false, true, nil
+		}
+		if i > 0 && layers[i-1] >= layer {
+			log.Warningf(ctx, "ignoring backup layers index listing layers out of order: %q after %q",
+				layer, layers[i-1])
+			return nil, false, true, nil
+		}
+	}
+	// Layers are only ever appended, so if the last one is still there the index
+	// is assumed to be up to date.
+	if len(layers) > 0 {
+		last, err := store.ReadFile(ctx, layers[len(layers)-1])
+		if err != nil {
+			if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+				log.Warningf(ctx, "ignoring backup layers index listing missing layer %q",
+					layers[len(layers)-1])
+				return nil, false, true, nil
+			}
+			return nil, false, true, errors.Wrap(err, "checking backup layers index")
+		}
+		last.Close()
+
+		newer, ok, err := findNewerBackupLayer(ctx, store, layers[len(layers)-1], timeutil.Now())
+		if err != nil {
+			return nil, false, true, errors.Wrap(err, "checking backup layers index")
+		}
+		if !ok {
+			log.Warningf(ctx, "ignoring backup layers index whose last layer %q is more than %d days old",
+				layers[len(layers)-1], backupLayersIndexMaxCheckedDays)
+			return nil, false, true, nil
+		}
+		if newer != "" {
+			log.Warningf(ctx, "ignoring backup layers index missing layer %q", newer)
+			return nil, false, true, nil
+		}
+	}
+	return layers, true, true, nil
+}
+
+// findNewerBackupLayer returns the manifest of a layer of the backup in store
+// newer than last, the manifest of one of its layers, if there is one, by
+// listing the directory of each day from the day of last until now. It returns
+// false if there are more than backupLayersIndexMaxCheckedDays such days. A
+// store which can't be listed is assumed not to hold any newer layer.
+func findNewerBackupLayer(
+	ctx context.Context, store cloud.ExternalStorage, last string, now time.Time,
+) (string, bool, error) {
+	day, err := time.Parse("20060102", strings.SplitN(last, "/", 2)[0])
+	if err != nil {
+		return "", false, errors.Wrapf(err, "parsing day of backup layer %q", last)
+	}
+	// Allow for the clock of the node which took the newest layer being ahead.
+	until := now.UTC().AddDate(0, 0, 1)
+	for days := 0; !day.After(until); day, days = day.AddDate(0, 0, 1), days+1 {
+		if days >= backupLayersIndexMaxCheckedDays {
+			return "", false, nil
+		}
+		files, err := store.ListFiles(ctx, day.Format("20060102")+"/[0-9]*.[0-9][0-9]/BACKUP*")
+		if err != nil {
+			if errors.Is(err, cloudimpl.ErrListingUnsupported) {
+				return "", true, nil
+			}
+			return "", false, err
+		}
+		for _, f := range files {
+			if base := path.Base(f); base != backupManifestName && base != backupOldManifestName {
+				continue
+			}
+			if path.Dir(f) > path.Dir(last) {
+				return f, true, nil
+			}
+		}
+	}
+	return "", true, nil
+}
+
+// appendToBackupLayersIndex records layer, the manifest of an incremental layer
+// which was just appended to the backup in store, in the backup's index of
+// layers. If the backup doesn't have a usable index yet, it is created from a
+// listing of the layers, which includes the new one.
+func appendToBackupLayersIndex(
+	ctx context.Context, store cloud.ExternalStorage, layer string,
+) error {
+	layers, ok, _, err := readBackupLayersIndex(ctx, store)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if layers, err = store.ListFiles(ctx, incBackupSubdirGlob+backupManifestName); err != nil {
+			return errors.Wrap(err, "listing backup layers to index them")
+		}
+		sort.Strings(layers)
+	} else if len(layers) == 0 || layers[len(layers)-1] < layer {
+		layers = append(layers, layer)
+	}
+	return writeBackupLayersIndex(ctx, store, layers)
+}
+
+func writeBackupLayersIndex(ctx context.Context, store cloud.ExternalStorage, layers []string) error {
+	var buf bytes.Buffer
+	for _, layer := range layers {
+		buf.WriteString(layer)
+		buf.WriteByte('\n')
+	}
+	if err := store.WriteFile(ctx, backupLayersIndexName, bytes.NewReader(buf.Bytes())); err != nil {
+		return errors.Wrap(err, "writing backup layers index")
+	}
+	return nil
+}
+
+// removeBackupLayersIndex removes the index of the layers of the backup in
+// store, if it has one. It is called when a layer is appended without being
+// recorded, so that the index isn't used after it became stale.
+func removeBackupLayersIndex(ctx context.Context, store cloud.ExternalStorage) error {
+	r, err := store.ReadFile(ctx, backupLayersIndexName)
+	if err != nil {
+		if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+			return nil
+		}
+		return errors.Wrap(err, "reading backup layers index")
+	}
+	r.Close()
+	return errors.Wrap(store.Delete(ctx, backupLayersIndexName), "removing backup layers index")
+}
+
+// maxBackupLayers bounds the number of layers of a chain of backups which are
+// resolved to restore it. Resolving a layer reads its manifest, so a collection
+// which accumulated an unreasonable number of layers, e.g. by being appended
+// to every minute, could otherwise exhaust the memory of the node before the
+// restore even starts.
+var maxBackupLayers = settings.RegisterIntSetting(
+	"bulkio.restore.max_backup_layers",
+	"maximum number of layers of a chain of backups which are resolved to restore it "+
+		"(0 for no limit)",
+	10000,
+	settings.NonNegativeInt,
+)
+
+// checkNumBackupLayers returns an error if a chain of numLayers layers has more
+// layers than allowed by maxBackupLayers.
+func checkNumBackupLayers(st *cluster.Settings, numLayers int) error {
+	if st == nil {
+		return nil
+	}
+	if limit := maxBackupLayers.Get(&st.SV); limit > 0 && int64(numLayers) > limit {
+		return errors.WithHint(
+			errors.Newf("backup has %d layers, more than the maximum of %d which can be resolved",
+				numLayers, limit),
+			"consolidate the chain, e.g. by taking a new full backup, or raise "+
+				"bulkio.restore.max_backup_layers")
+	}
+	return nil
+}
+
+// resolvedBackupLayer describes the layer of a chain of backups which was
+// resolved to cover a restore's target time.
+type resolvedBackupLayer struct {
+	// index is the position of the layer in the chain, of which there were
+	// numLayers before the chain was truncated to end with the layer.
+	index, numLayers   int
+	startTime, endTime hlc.Timestamp
+}
+
+// backupEncryptionResolver returns the encryption options with which to read
+// the layer of a backup whose default locality is at layerURI, allowing each
+// layer of a chain to be encrypted with a distinct key.
+type backupEncryptionResolver func(layerURI string) (*jobspb.BackupEncryptionOptions, error)
+
+// singleBackupEncryption returns a backupEncryptionResolver which reads every
+// layer with the same encryption options.
+func singleBackupEncryption(encryption *jobspb.BackupEncryptionOptions) backupEncryptionResolver {
+	return func(string) (*jobspb.BackupEncryptionOptions, error) {
+		return encryption, nil
 	}
-	sort.Strings(prev)
-	return prev, nil
 }
 
 // resolveBackupManifests resolves a list of list of URIs that point to the
@@ -617,29 +2865,40 @@ func findPriorBackupLocations(ctx context.Context, store cloud.ExternalStorage)
 // actual backup manifests and metadata required to RESTORE. If only one layer
 // is explicitly provided, it is inspected to see if it contains "appended"
 // layers internally that are then expanded into the result layers returned,
-// similar to if those layers had been specified in `from` explicitly.
+// similar to if those layers had been specified in `from` explicitly. The
+// layer which covers endTime, which is the last of the returned layers, is
+// also described in the returned resolvedBackupLayer. Each layer is read with
+// the encryption options returned for it by encryptionForLayer.
 func resolveBackupManifests(
 	ctx context.Context,
 	baseStores []cloud.ExternalStorage,
 	mkStore cloud.ExternalStorageFromURIFactory,
 	from [][]string,
 	endTime hlc.Timestamp,
-	encryption *jobspb.BackupEncryptionOptions,
+	encryptionForLayer backupEncryptionResolver,
 	user security.SQLUsername,
 ) (
 	defaultURIs []string,
 	mainBackupManifests []BackupManifest,
 	localityInfo []jobspb.RestoreDetails_BackupLocalityInfo,
+	resolved resolvedBackupLayer,
 	_ error,
 ) {
-	baseManifest, err := readBackupManifestFromStore(ctx, baseStores[0], encryption)
+	baseEncryption, err := encryptionForLayer(from[0][0])
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, resolvedBackupLayer{}, err
+	}
+	baseManifest, err := readBackupManifestFromStore(ctx, baseStores[0], baseEncryption)
+	if err != nil {
+		return nil, nil, nil, resolvedBackupLayer{}, err
 	}
 
 	// If explicit incremental backups were are passed, we simply load them one
 	// by one as specified and return the results.
 	if len(from) > 1 {
+		if err := checkNumBackupLayers(baseStores[0].Settings(), len(from)); err != nil {
+			return nil, nil, nil, resolvedBackupLayer{}, err
+		}
 		defaultURIs = make([]string, len(from))
 		localityInfo = make([]jobspb.RestoreDetails_BackupLocalityInfo, len(from))
 		mainBackupManifests = make([]BackupManifest, len(from))
@@ -652,26 +2911,30 @@ func resolveBackupManifests(
 			for j := range uris {
 				stores[j], err = mkStore(ctx, uris[j], user)
 				if err != nil {
-					return nil, nil, nil, errors.Wrapf(err, "export configuration")
+					return nil, nil, nil, resolvedBackupLayer{}, errors.Wrapf(err, "export configuration")
 				}
 				defer stores[j].Close()
 			}
 
+			encryption, err := encryptionForLayer(uris[0])
+			if err != nil {
+				return nil, nil, nil, resolvedBackupLayer{}, err
+			}
 			mainBackupManifests[i], err = readBackupManifestFromStore(ctx, stores[0], encryption)
 			if err != nil {
-				return nil, nil, nil, err
+				return nil, nil, nil, resolvedBackupLayer{}, err
 			}
 			if len(uris) > 1 {
 				localityInfo[i], err = getLocalityInfo(
 					ctx, stores, uris, mainBackupManifests[i], encryption, "", /* prefix */
 				)
 				if err != nil {
-					return nil, nil, nil, err
+					return nil, nil, nil, resolvedBackupLayer{}, err
 				}
 			}
 		}
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, nil, nil, resolvedBackupLayer{}, err
 		}
 	} else {
 		// Since incremental layers were *not* explicitly specified, search for any
@@ -684,11 +2947,14 @@ func resolveBackupManifests(
 				// and restore the specified base.
 				prev = nil
 			} else {
-				return nil, nil, nil, err
+				return nil, nil, nil, resolvedBackupLayer{}, err
 			}
 		}
 
 		numLayers := len(prev) + 1
+		if err := checkNumBackupLayers(baseStores[0].Settings(), numLayers); err != nil {
+			return nil, nil, nil, resolvedBackupLayer{}, err
+		}
 
 		defaultURIs = make([]string, numLayers)
 		mainBackupManifests = make([]BackupManifest, numLayers)
@@ -698,10 +2964,10 @@ func resolveBackupManifests(
 		defaultURIs[0] = from[0][0]
 		mainBackupManifests[0] = baseManifest
 		localityInfo[0], err = getLocalityInfo(
-			ctx, baseStores, from[0], baseManifest, encryption, "", /* prefix */
+			ctx, baseStores, from[0], baseManifest, baseEncryption, "", /* prefix */
 		)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, nil, nil, resolvedBackupLayer{}, err
 		}
 
 		// If we discovered additional layers, handle them too.
@@ -713,42 +2979,62 @@ func resolveBackupManifests(
 			for i := range from[0] {
 				baseURIs[i], err = url.Parse(from[0][i])
 				if err != nil {
-					return nil, nil, nil, err
+					return nil, nil, nil, resolvedBackupLayer{}, err
 				}
 			}
 
 			// For each layer, we need to load the base manifest then calculate the URI and the
 			// locality info for each partition.
 			for i := range prev {
-				defaultManifestForLayer, err := readBackupManifest(ctx, baseStores[0], prev[i], encryption)
-				if err != nil {
-					return nil, nil, nil, err
-				}
-				mainBackupManifests[i+1] = defaultManifestForLayer
-
 				// prev[i] is the path to the manifest file itself for layer i -- the
 				// dirname piece of that path is the subdirectory in each of the
 				// partitions in which we'll also expect to find a partition manifest.
 				subDir := path.Dir(prev[i])
-				partitionURIs := make([]string, numPartitions)
-				for j := range baseURIs {
-					u := *baseURIs[j] // NB: makes a copy to avoid mutating the baseURI.
-					u.Path = path.Join(u.Path, subDir)
-					partitionURIs[j] = u.String()
-				}
+				partitionURIs := incrementalLayerURIs(baseURIs, subDir)
 				defaultURIs[i+1] = partitionURIs[0]
+
+				encryption, err := encryptionForLayer(partitionURIs[0])
+				if err != nil {
+					return nil, nil, nil, resolvedBackupLayer{}, err
+				}
+				defaultManifestForLayer, err := readBackupManifest(ctx, baseStores[0], prev[i], encryption)
+				if err != nil {
+					return nil, nil, nil, resolvedBackupLayer{}, err
+				}
+				mainBackupManifests[i+1] = defaultManifestForLayer
 				localityInfo[i+1], err = getLocalityInfo(ctx, baseStores, partitionURIs, defaultManifestForLayer, encryption, subDir)
 				if err != nil {
-					return nil, nil, nil, err
+					return nil, nil, nil, resolvedBackupLayer{}, err
 				}
 			}
 		}
 	}
 
+	mainBackupManifests, err = inflateElidedDescriptors(mainBackupManifests)
+	if err != nil {
+		return nil, nil, nil, resolvedBackupLayer{}, err
+	}
+
+	numLayers := len(mainBackupManifests)
+
 	// Check that the requested target time, if specified, is valid for the list
 	// of incremental backups resolved, truncating the results to the backup that
 	// contains the target time.
 	if !endTime.IsEmpty() {
+		// A time after the end of every layer is far more likely to be a mistyped
+		// timestamp than a gap in the chain, so call it out explicitly.
+		var latest hlc.Timestamp
+		for i := range mainBackupManifests {
+			latest.Forward(mainBackupManifests[i].EndTime)
+		}
+		if latest.Less(endTime) {
+			return nil, nil, nil, resolvedBackupLayer{}, errors.Errorf(
+				"invalid RESTORE timestamp: requested time %s is after the most recent backup at %s",
+				timeutil.Unix(0, endTime.WallTime).UTC(),
+				timeutil.Unix(0, latest.WallTime).UTC(),
+			)
+		}
+
 		ok := false
 		for i, b := range mainBackupManifests {
 			// Find the backup that covers the requested time.
@@ -764,12 +3050,12 @@ func resolveBackupManifests(
 					if b.MVCCFilter != MVCCFilter_All {
 						const errPrefix = "invalid RESTORE timestamp: restoring to arbitrary time requires that BACKUP for requested time be created with '%s' option."
 						if i == 0 {
-							return nil, nil, nil, errors.Errorf(
+							return nil, nil, nil, resolvedBackupLayer{}, errors.Errorf(
 								errPrefix+" nearest backup time is %s", backupOptRevisionHistory,
 								timeutil.Unix(0, b.EndTime.WallTime).UTC(),
 							)
 						}
-						return nil, nil, nil, errors.Errorf(
+						return nil, nil, nil, resolvedBackupLayer{}, errors.Errorf(
 							errPrefix+" nearest BACKUP times are %s or %s",
 							backupOptRevisionHistory,
 							timeutil.Unix(0, mainBackupManifests[i-1].EndTime.WallTime).UTC(),
@@ -782,7 +3068,7 @@ func resolveBackupManifests(
 					// only captured since the GC window. Note that the RevisionStartTime is
 					// the latest for ranges backed up.
 					if endTime.LessEq(b.RevisionStartTime) {
-						return nil, nil, nil, errors.Errorf(
+						return nil, nil, nil, resolvedBackupLayer{}, errors.Errorf(
 							"invalid RESTORE timestamp: BACKUP for requested time only has revision history"+
 								" from %v", timeutil.Unix(0, b.RevisionStartTime.WallTime).UTC(),
 						)
@@ -793,13 +3079,20 @@ func resolveBackupManifests(
 		}
 
 		if !ok {
-			return nil, nil, nil, errors.Errorf(
+			return nil, nil, nil, resolvedBackupLayer{}, errors.Errorf(
 				"invalid RESTORE timestamp: supplied backups do not cover requested time",
 			)
 		}
 	}
 
-	return defaultURIs, mainBackupManifests, localityInfo, nil
+	last := len(mainBackupManifests) - 1
+	resolved = resolvedBackupLayer{
+		index:     last,
+		numLayers: numLayers,
+		startTime: mainBackupManifests[last].StartTime,
+		endTime:   mainBackupManifests[last].EndTime,
+	}
+	return defaultURIs, mainBackupManifests, localityInfo, resolved, nil
 }
 
 // TODO(anzoteh96): benchmark the performance of different search algorithms,
@@ -821,20 +3114,367 @@ func getBackupIndexAtTime(backupManifests []BackupManifest, asOf hlc.Timestamp)
 	return backupManifestIndex, nil
 }
 
+// MinimalCoveringChain returns the contiguous run of layers of a chain of
+// backups, along with their URIs, which covers all of the times in [t1, t2].
+// Each layer covers the times in (StartTime, EndTime], so the run starts with
+// the first layer which ends at or after t1. It returns an error if the chain
+// has a gap within the interval or doesn't extend over all of it.
+//
+// The run holds the changes made within the interval, but unless t1 falls
+// within the full backup it doesn't start with it, in which case it can't be
+// restored on its own: the data as of any time in the interval is only
+// restored by the whole prefix of the chain up to the layer covering that
+// time, so the layers before the run must be restored along with it.
+func MinimalCoveringChain(
+	manifests []BackupManifest, uris []string, t1, t2 hlc.Timestamp,
+) ([]BackupManifest, []string, error) {
+	if len(manifests) != len(uris) {
+		return nil, nil, errors.AssertionFailedf(
+			"expected a URI for each of the %d manifests, got %d", len(manifests), len(uris))
+	}
+	if t2.Less(t1) {
+		return nil, nil, errors.Newf("invalid time range: %s is after %s", t1, t2)
+	}
+	if len(manifests) == 0 {
+		return nil, nil, errors.New("no backups to cover the requested time range")
+	}
+	if t1.Less(manifests[0].StartTime) {
+		return nil, nil, errors.Newf("backups start at %s, after the start of the requested time range %s",
+			manifests[0].StartTime, t1)
+	}
+	if last := manifests[len(manifests)-1]; last.EndTime.Less(t2) {
+		return nil, nil, errors.Newf("backups end at %s, before the end of the requested time range %s",
+			last.EndTime, t2)
+	}
+
+	start := sort.Search(len(manifests), func(i int) bool {
+		return t1.LessEq(manifests[i].EndTime)
+	})
+	end := start + sort.Search(len(manifests)-start, func(i int) bool {
+		return t2.LessEq(manifests[start+i].EndTime)
+	})
+	// The first layer must cover t1 itself, which a gap just before it leaves
+	// uncovered.
+	if start > 0 && t1.LessEq(manifests[start].StartTime) {
+		return nil, nil, errors.Newf("backups do not cover the requested time range: "+
+			"there is a gap between %s and %s", manifests[start-1].EndTime, manifests[start].StartTime)
+	}
+	for i := start + 1; i <= end; i++ {
+		if !manifests[i].StartTime.Equal(manifests[i-1].EndTime) {
+			return nil, nil, errors.Newf("backups do not cover the requested time range: "+
+				"there is a gap between %s and %s", manifests[i-1].EndTime, manifests[i].StartTime)
+		}
+	}
+	return manifests[start : end+1], uris[start : end+1], nil
+}
+
+// elideUnchangedDescriptors controls whether incremental backups only persist
+// the descriptors that changed since the previous backup in the chain.
+var elideUnchangedDescriptors = settings.RegisterBoolSetting(
+	"bulkio.backup.elide_unchanged_descriptors.enabled",
+	"if true, incremental backups only store the descriptors that changed since the previous backup",
+	false,
+)
+
+// elideDescriptors records in manifest the descriptors that were added,
+// changed or dropped relative to prev, the previous backup in the chain, and
+// marks it so that only those changes need to be persisted. prev must carry
+// its full descriptor set.
+func elideDescriptors(manifest *BackupManifest, prev *BackupManifest) {
+	prevByID := make(map[descpb.ID]*descpb.Descriptor, len(prev.Descriptors))
+	for i := range prev.Descriptors {
+		prevByID[descpb.GetDescriptorID(&prev.Descriptors[i])] = &prev.Descriptors[i]
+	}
+	var delta []BackupManifest_DescriptorRevision
+	for i := range manifest.Descriptors {
+		desc := manifest.Descriptors[i]
+		id := descpb.GetDescriptorID(&desc)
+		if prevDesc, ok := prevByID[id]; ok {
+			delete(prevByID, id)
+			if prevDesc.Equal(&desc) {
+				continue
+			}
+		}
+		delta = append(delta, BackupManifest_DescriptorRevision{
+			Time: manifest.EndTime, ID: id, Desc: &desc,
+		})
+	}
+	for id := range prevByID {
+		delta = append(delta, BackupManifest_DescriptorRevision{Time: manifest.EndTime, ID: id})
+	}
+	sort.Slice(delta, func(i, j int) bool { return delta[i].ID < delta[j].ID })
+	manifest.DescriptorsElided = true
+	manifest.DescriptorDelta = delta
+}
+
+// inflateElidedDescriptors returns the chain of backups with the full
+// descriptor set of each backup whose descriptors were elided reconstructed
+// from the backups preceding it. The passed manifests are not modified.
+func inflateElidedDescriptors(backupManifests []BackupManifest) ([]BackupManifest, error) {
+	elided := false
+	for i := range backupManifests {
+		elided = elided || backupManifests[i].DescriptorsElided
+	}
+	if !elided {
+		return backupManifests, nil
+	}
+
+	res := make([]BackupManifest, len(backupManifests))
+	copy(res, backupManifests)
+	for i := range res {
+		if !res[i].DescriptorsElided {
+			continue
+		}
+		if i == 0 {
+			return nil, errors.Newf(
+				"backup ending at %s only stores changed descriptors but no prior backup was found",
+				res[i].EndTime)
+		}
+		byID := make(map[descpb.ID]descpb.Descriptor, len(res[i-1].Descriptors))
+		for _, desc := range res[i-1].Descriptors {
+			byID[descpb.GetDescriptorID(&desc)] = desc
+		}
+		for _, rev := range res[i].DescriptorDelta {
+			if rev.Desc == nil {
+				delete(byID, rev.ID)
+			} else {
+				byID[rev.ID] = *rev.Desc
+			}
+		}
+		descs := make([]descpb.Descriptor, 0, len(byID))
+		for _, desc := range byID {
+			descs = append(descs, desc)
+		}
+		sort.Slice(descs, func(i, j int) bool {
+			return descpb.GetDescriptorID(&descs[i]) < descpb.GetDescriptorID(&descs[j])
+		})
+		res[i].Descriptors = descs
+		res[i].DescriptorsElided = false
+		res[i].DescriptorDelta = nil
+	}
+	return res, nil
+}
+
+// loadSQLDescsFromBackupsAtTime returns the descriptors in the chain of backups
+// as of asOf, along with the backup that covers that time. The manifests must
+// have had their elided descriptors inflated, which loadBackupManifests and
+// resolveBackupManifests take care of.
+//
+// If idRewrite is non-nil, the IDs of the descriptors and the IDs of their
+// parent databases and schemas which are in it are rewritten to the IDs they
+// map to, on copies of the descriptors in the manifests, before they are
+// validated. Other references between descriptors aren't rewritten.
+//
+// If validate is non-nil, it is called on each of the returned descriptors and
+// an error listing every descriptor which fails validation is returned. If
+// validateParents is set, the descriptors are also checked with
+// ValidateDescriptorParents.
 func loadSQLDescsFromBackupsAtTime(
-	backupManifests []BackupManifest, asOf hlc.Timestamp,
+	ctx context.Context,
+	backupManifests []BackupManifest,
+	asOf hlc.Timestamp,
+	idRewrite map[descpb.ID]descpb.ID,
+	validate func(catalog.Descriptor) error,
+	validateParents bool,
+) ([]catalog.Descriptor, BackupManifest, error) {
+	descs, manifest := loadSQLDescsFromBackupsAtTimeUnvalidated(ctx, backupManifests, asOf)
+	if idRewrite != nil {
+		for i := range descs {
+			descs[i] = rewriteDescriptorIDs(ctx, descs[i], idRewrite)
+		}
+	}
+	if validateParents {
+		if err := ValidateDescriptorParents(descs); err != nil {
+			return nil, BackupManifest{}, err
+		}
+	}
+	if validate == nil {
+		return descs, manifest, nil
+	}
+	var invalid []string
+	for _, desc := range descs {
+		if err := validate(desc); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%q (%d): %v", desc.GetName(), desc.GetID(), err))
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, BackupManifest{}, errors.Newf("backup contains %d invalid descriptors: %s",
+			len(invalid), strings.Join(invalid, "; "))
+	}
+	return descs, manifest, nil
+}
+
+// LoadSQLDescsFromLayer returns the descriptors of the chain of backups in
+// backupManifests as of the end of the layer at index layerIdx, i.e. the
+// descriptors which a restore as of that layer's EndTime would restore, for
+// manual recovery from a known layer. Only the layers up to layerIdx are
+// considered, so the state is taken from that layer itself even though the
+// next one starts at the same time. The manifests are expected to be ordered
+// as by resolveBackupManifests.
+func LoadSQLDescsFromLayer(
+	ctx context.Context, backupManifests []BackupManifest, layerIdx int,
+) ([]catalog.Descriptor, error) {
+	if layerIdx < 0 || layerIdx >= len(backupManifests) {
+		return nil, errors.Newf("layer %d out of range: backup has %d layers",
+			layerIdx, len(backupManifests))
+	}
+	descs, _ := loadSQLDescsFromBackupsAtTimeUnvalidated(ctx,
+		backupManifests[:layerIdx+1], backupManifests[layerIdx].EndTime)
+	return descs, nil
+}
+
+// rewriteDescriptorIDs returns a copy of desc whose ID and parent database and
+// schema IDs are rewritten as by idRewrite, or desc itself if none of them are
+// in it.
+func rewriteDescriptorIDs(
+	ctx context.Context, desc catalog.Descriptor, idRewrite map[descpb.ID]descpb.ID,
+) catalog.Descriptor {
+	rewrite := func(id *descpb.ID) bool {
+		if newID, ok := idRewrite[*id]; ok && newID != *id {
+			*id = newID
+			return true
+		}
+		return false
+	}
+	raw := protoutil.Clone(desc.DescriptorProto()).(*descpb.Descriptor)
+	var rewritten bool
+	// nolint:descriptormarshal
+	switch t := raw.Union.(type) {
+	case *descpb.Descriptor_Table:
+		rewritten = rewrite(&t.Table.ID)
+		rewritten = rewrite(&t.Table.ParentID) || rewritten
+		rewritten = rewrite(&t.Table.UnexposedParentSchemaID) || rewritten
+	case *descpb.Descriptor_Database:
+		rewritten = rewrite(&t.Database.ID)
+	case *descpb.Descriptor_Type:
+		rewritten = rewrite(&t.Type.ID)
+		rewritten = rewrite(&t.Type.ParentID) || rewritten
+		rewritten = rewrite(&t.Type.ParentSchemaID) || rewritten
+	case *descpb.Descriptor_Schema:
+		rewritten = rewrite(&t.Schema.ID)
+		rewritten = rewrite(&t.Schema.ParentID) || rewritten
+	}
+	if !rewritten {
+		return desc
+	}
+	return catalogkv.UnwrapDescriptorRaw(ctx, raw)
+}
+
+// ValidateDescriptorParents checks that the parent database of every table,
+// type and schema in descs is also in descs, returning an error listing the
+// descriptors whose parent is missing. Restoring such a descriptor would
+// otherwise fail with an unhelpful error once its parent is looked up.
+func ValidateDescriptorParents(descs []catalog.Descriptor) error {
+	dbs := make(map[descpb.ID]struct{})
+	for _, desc := range descs {
+		if _, ok := desc.(catalog.DatabaseDescriptor); ok {
+			dbs[desc.GetID()] = struct{}{}
+		}
+	}
+	var orphans []string
+	for _, desc := range descs {
+		switch desc.(type) {
+		case catalog.TableDescriptor, catalog.TypeDescriptor, catalog.SchemaDescriptor:
+		default:
+			continue
+		}
+		if _, ok := dbs[desc.GetParentID()]; !ok {
+			orphans = append(orphans, fmt.Sprintf("%s %q (%d) in database %d",
+				desc.TypeName(), desc.GetName(), desc.GetID(), desc.GetParentID()))
+		}
+	}
+	if len(orphans) > 0 {
+		sort.Strings(orphans)
+		return errors.Newf("backup contains %d descriptors whose parent database is missing: %s",
+			len(orphans), strings.Join(orphans, "; "))
+	}
+	return nil
+}
+
+func loadSQLDescsFromBackupsAtTimeUnvalidated(
+	ctx context.Context, backupManifests []BackupManifest, asOf hlc.Timestamp,
+) ([]catalog.Descriptor, BackupManifest) {
+	return LoadMatchingSQLDescsFromBackupsAtTime(ctx, backupManifests, asOf, nil /* match */)
+}
+
+// LoadMatchingSQLDescsFromBackupsAtTime is like loadSQLDescsFromBackupsAtTime,
+// but only returns the descriptors whose summaries satisfy match, if it is
+// non-nil. Descriptors are matched before they are unwrapped, so that only
+// those needed are materialized, which makes it cheap to extract a few
+// descriptors, such as the system tables holding cluster settings, from a
+// backup of a large cluster. The revisions are merged as by
+// loadSQLDescsFromBackupsAtTime when asOf is set, so an object whose database
+// was not yet backed up is omitted even if it matches.
+func LoadMatchingSQLDescsFromBackupsAtTime(
+	ctx context.Context,
+	backupManifests []BackupManifest,
+	asOf hlc.Timestamp,
+	match func(DescSummary) bool,
 ) ([]catalog.Descriptor, BackupManifest) {
+	var allDescs []catalog.Descriptor
+	lastBackupManifest, _ := forEachMatchingSQLDescAtTime(ctx, backupManifests, asOf, match,
+		func(desc catalog.Descriptor) error {
+			allDescs = append(allDescs, desc)
+			return nil
+		})
+	return allDescs, lastBackupManifest
+}
+
+// ForEachDescriptorAtTime calls fn with each of the descriptors which
+// loadSQLDescsFromBackupsAtTime would return, without collecting them, so that
+// a caller can process the descriptors of a large catalog one at a time. Each
+// descriptor is only unwrapped right before fn is called with it. If fn returns
+// an error, the iteration stops and the error is returned, unless it is
+// iterutil.StopIteration.
+//
+// When asOf is set and the backup has revision history, the state of the
+// catalog at asOf must still be reconstructed before the first descriptor can
+// be emitted, since a later revision may drop or replace it, and the parent
+// database of each object must be known to filter out objects whose database
+// was not yet backed up. That state only references the raw descriptors in the
+// manifests though, so the memory it uses is proportional to the number of
+// descriptors rather than to their size.
+func ForEachDescriptorAtTime(
+	ctx context.Context,
+	backupManifests []BackupManifest,
+	asOf hlc.Timestamp,
+	fn func(catalog.Descriptor) error,
+) error {
+	_, err := forEachMatchingSQLDescAtTime(ctx, backupManifests, asOf, nil /* match */, fn)
+	if iterutil.Done(err) {
+		return nil
+	}
+	return err
+}
+
+// forEachMatchingSQLDescAtTime calls fn with the descriptors in the chain of
+// backups as of asOf whose summaries satisfy match, if it is non-nil, in order
+// of ID when the revisions are merged, and returns the backup that covers asOf.
+func forEachMatchingSQLDescAtTime(
+	ctx context.Context,
+	backupManifests []BackupManifest,
+	asOf hlc.Timestamp,
+	match func(DescSummary) bool,
+	fn func(catalog.Descriptor) error,
+) (BackupManifest, error) {
 	lastBackupManifest := backupManifests[len(backupManifests)-1]
 
-	unwrapDescriptors := func(raw []descpb.Descriptor) []catalog.Descriptor {
-		ret := make([]catalog.Descriptor, 0, len(raw))
+	matches := func(raw *descpb.Descriptor) bool {
+		return match == nil || match(summarizeDescriptor(raw))
+	}
+	forEachDescriptor := func(raw []descpb.Descriptor) error {
 		for i := range raw {
-			ret = append(ret, catalogkv.UnwrapDescriptorRaw(context.TODO(), &raw[i]))
+			if !matches(&raw[i]) {
+				continue
+			}
+			if err := fn(catalogkv.UnwrapDescriptorRaw(ctx, &raw[i])); err != nil {
+				return err
+			}
 		}
-		return ret
+		return nil
 	}
 	if asOf.IsEmpty() {
-		return unwrapDescriptors(lastBackupManifest.Descriptors), lastBackupManifest
+		return lastBackupManifest, forEachDescriptor(lastBackupManifest.Descriptors)
 	}
 
 	for _, b := range backupManifests {
@@ -844,11 +3484,26 @@ func loadSQLDescsFromBackupsAtTime(
 		lastBackupManifest = b
 	}
 	if len(lastBackupManifest.DescriptorChanges) == 0 {
-		return unwrapDescriptors(lastBackupManifest.Descriptors), lastBackupManifest
+		return lastBackupManifest, forEachDescriptor(lastBackupManifest.Descriptors)
+	}
+
+	// The revisions are expected to be ordered by time, which the loop below
+	// relies on to stop at asOf. Sort them defensively if they aren't, since
+	// acting on them out of order would silently produce the wrong descriptors.
+	changes := lastBackupManifest.DescriptorChanges
+	if !sort.SliceIsSorted(changes, func(i, j int) bool {
+		return changes[i].Time.Less(changes[j].Time)
+	}) {
+		log.Warningf(ctx, "descriptor changes of backup ending at %s are not ordered by time; sorting them",
+			lastBackupManifest.EndTime)
+		changes = append([]BackupManifest_DescriptorRevision(nil), changes...)
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Time.Less(changes[j].Time)
+		})
 	}
 
 	byID := make(map[descpb.ID]*descpb.Descriptor, len(lastBackupManifest.Descriptors))
-	for _, rev := range lastBackupManifest.DescriptorChanges {
+	for _, rev := range changes {
 		if asOf.Less(rev.Time) {
 			break
 		}
@@ -859,11 +3514,19 @@ func loadSQLDescsFromBackupsAtTime(
 		}
 	}
 
-	allDescs := make([]catalog.Descriptor, 0, len(byID))
-	for _, raw := range byID {
+	ids := make([]descpb.ID, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		raw := byID[id]
+		if !matches(raw) {
+			continue
+		}
 		// A revision may have been captured before it was in a DB that is
 		// backed up -- if the DB is missing, filter the object.
-		desc := catalogkv.UnwrapDescriptorRaw(context.TODO(), raw)
+		desc := catalogkv.UnwrapDescriptorRaw(ctx, raw)
 		var isObject bool
 		switch desc.(type) {
 		case catalog.TableDescriptor, catalog.TypeDescriptor, catalog.SchemaDescriptor:
@@ -872,9 +3535,11 @@ func loadSQLDescsFromBackupsAtTime(
 		if isObject && byID[desc.GetParentID()] == nil {
 			continue
 		}
-		allDescs = append(allDescs, desc)
+		if err := fn(desc); err != nil {
+			return lastBackupManifest, err
+		}
 	}
-	return allDescs, lastBackupManifest
+	return lastBackupManifest, nil
 }
 
 // sanitizeLocalityKV returns a sanitized version of the input string where all
@@ -987,6 +3652,83 @@ func checkForPreviousBackup(
 	return nil
 }
 
+// collectionSubdirGlob matches the subdirectories in which backups into a
+// collection are written, see dateBasedIntoFolderName.
+const collectionSubdirGlob = "[0-9]*/[0-9]*/[0-9]*-[0-9]*.[0-9][0-9]/"
+
+// CheckpointInfo describes the checkpoint of a backup which is in progress, or
+// which was abandoned before it completed.
+type CheckpointInfo struct {
+	// Path is the path of the checkpoint file in the store.
+	Path string
+	// JobID is the ID of the job which wrote a temporary checkpoint, or 0.
+	JobID     int64
+	StartTime hlc.Timestamp
+	EndTime   hlc.Timestamp
+	// Spans is the number of spans the backup covers, and Files the number of
+	// files which it had exported as of the checkpoint, which hold EntryCounts.
+	Spans       int
+	Files       int
+	EntryCounts RowCount
+	// Err is set if the checkpoint could not be read.
+	Err error
+}
+
+// ListInProgressBackups finds the backup checkpoints in store, which may be a
+// single backup, with its appended incremental layers, or a collection of
+// backups, and reports how far along each of the backups which wrote them had
+// gotten. A checkpoint which can't be read, for instance because it was written
+// with a different encryption key, is reported with its Err set. The
+// checkpoints are sorted by path.
+//
+// The stores don't expose when files were last written, so the reported
+// progress is as of an unknown time; comparing the results of two calls
+// shows whether a backup is making progress.
+func ListInProgressBackups(
+	ctx context.Context, store cloud.ExternalStorage, encryption *jobspb.BackupEncryptionOptions,
+) ([]CheckpointInfo, error) {
+	var paths []string
+	for _, dir := range []string{
+		"", incBackupSubdirGlob, collectionSubdirGlob, collectionSubdirGlob + incBackupSubdirGlob,
+	} {
+		// The trailing glob also matches temporary checkpoints, which are suffixed
+		// with the ID of the job which wrote them.
+		matches, err := store.ListFiles(ctx, dir+backupManifestCheckpointName+"*")
+		if err != nil {
+			return nil, errors.Wrap(err, "listing backup checkpoints")
+		}
+		for _, m := range matches {
+			if !strings.HasSuffix(m, backupManifestChecksumSuffix) {
+				paths = append(paths, m)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	res := make([]CheckpointInfo, 0, len(paths))
+	for _, p := range paths {
+		info := CheckpointInfo{Path: p}
+		if suffix := strings.TrimPrefix(path.Base(p), backupManifestCheckpointName); suffix != "" {
+			jobID, err := strconv.ParseInt(strings.TrimPrefix(suffix, "-"), 10, 64)
+			if err != nil {
+				// Some other file which happens to share the prefix.
+				continue
+			}
+			info.JobID = jobID
+		}
+		m, err := readBackupManifest(ctx, store, p, encryption)
+		if err != nil {
+			info.Err = err
+		} else {
+			info.StartTime, info.EndTime = m.StartTime, m.EndTime
+			info.Spans, info.Files = len(m.Spans), len(m.Files)
+			info.EntryCounts = m.EntryCounts
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
 // tempCheckpointFileNameForJob returns temporary filename for backup manifest checkpoint.
 func tempCheckpointFileNameForJob(jobID int64) string {
 	return fmt.Sprintf("%s-%d", backupManifestCheckpointName, jobID)
@@ -0,0 +1,2669 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/stats"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestTableDesc(id descpb.ID, version descpb.DescriptorVersion) descpb.Descriptor {
+	return descpb.Descriptor{Union: &descpb.Descriptor_Table{
+		Table: &descpb.TableDescriptor{ID: id, Name: "t", ParentID: 1, Version: version},
+	}}
+}
+
+func makeTestFile(start, end string) BackupManifest_File {
+	return BackupManifest_File{
+		Span: roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+		Path: start + "-" + end + ".sst",
+	}
+}
+
+func makeTestLocalityFile(start, end, locality string, size int64) BackupManifest_File {
+	f := makeTestFile(start, end)
+	f.LocalityKV = locality
+	f.EntryCounts.DataSize = size
+	return f
+}
+
+func makeTestTimestamp(wall int64) hlc.Timestamp {
+	return hlc.Timestamp{WallTime: wall}
+}
+
+func TestDiffBackupManifests(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	a := BackupManifest{
+		Descriptors: []descpb.Descriptor{
+			makeTestTableDesc(52, 1), makeTestTableDesc(53, 1), makeTestTableDesc(54, 3),
+		},
+		Files: []BackupManifest_File{
+			makeTestFile("c", "d"), makeTestFile("a", "b"), makeTestFile("e", "f"),
+		},
+	}
+	b := BackupManifest{
+		Descriptors: []descpb.Descriptor{
+			makeTestTableDesc(54, 3), makeTestTableDesc(53, 2), makeTestTableDesc(55, 1),
+		},
+		Files: []BackupManifest_File{
+			makeTestFile("a", "b"), makeTestFile("e", "g"), makeTestFile("c", "d"),
+		},
+	}
+
+	diff, err := DiffBackupManifests(a, b)
+	require.NoError(t, err)
+	require.Equal(t, []descpb.ID{55}, diff.AddedDescriptors)
+	require.Equal(t, []descpb.ID{52}, diff.RemovedDescriptors)
+	require.Equal(t, []descpb.ID{53}, diff.ChangedDescriptors)
+	require.Equal(t, []roachpb.Span{makeTestFile("e", "g").Span}, diff.AddedSpans)
+	require.Equal(t, []roachpb.Span{makeTestFile("e", "f").Span}, diff.RemovedSpans)
+
+	// The inputs must not have been reordered.
+	require.Equal(t, "c-d.sst", a.Files[0].Path)
+
+	// Diffing a manifest against itself yields nothing.
+	diff, err = DiffBackupManifests(a, a)
+	require.NoError(t, err)
+	require.Equal(t, BackupDiff{}, diff)
+
+	// Manifests whose descriptors were elided must be inflated first.
+	elided := b
+	elided.DescriptorsElided = true
+	_, err = DiffBackupManifests(a, elided)
+	require.Error(t, err)
+
+	a.Descriptors = append(a.Descriptors, makeTestTableDesc(52, 2))
+	_, err = DiffBackupManifests(a, b)
+	require.Error(t, err)
+}
+
+func TestForEachBackupFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var files BackupFileDescriptors
+	for i := 0; i < 100; i++ {
+		files = append(files, makeTestFile(fmt.Sprintf("%03d", i), fmt.Sprintf("%03d", i+1)))
+	}
+
+	for _, concurrency := range []int{0, 1, 4, 1000} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			var inFlight, maxInFlight, visited int32
+			require.NoError(t, forEachBackupFile(ctx, len(files), concurrency,
+				func(context.Context, int) error {
+					n := atomic.AddInt32(&inFlight, 1)
+					defer atomic.AddInt32(&inFlight, -1)
+					for {
+						prev := atomic.LoadInt32(&maxInFlight)
+						if n <= prev || atomic.CompareAndSwapInt32(&maxInFlight, prev, n) {
+							break
+						}
+					}
+					atomic.AddInt32(&visited, 1)
+					return nil
+				}))
+			require.Equal(t, int32(len(files)), visited)
+			limit := int32(concurrency)
+			if limit < 1 {
+				limit = 1
+			}
+			require.LessOrEqual(t, maxInFlight, limit)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		boom := errors.New("boom")
+		err := forEachBackupFile(ctx, len(files), 4, func(_ context.Context, i int) error {
+			if i == 10 {
+				return boom
+			}
+			return nil
+		})
+		require.True(t, errors.Is(err, boom))
+	})
+
+	require.NoError(t, forEachBackupFile(ctx, 0, 4, func(context.Context, int) error {
+		return errors.New("unexpected call")
+	}))
+}
+
+func TestLazyStatisticsFromBackup(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://1/backup", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Column 2 of table 52 was dropped after the statistics were collected on
+	// version 1 of the table.
+	statsTable := StatsTable{
+		Statistics: []*stats.TableStatisticProto{
+			{TableID: 52, Name: "a", ColumnIDs: []descpb.ColumnID{1}},
+			{TableID: 52, Name: "b", ColumnIDs: []descpb.ColumnID{2}},
+			{TableID: 52, Name: "ab", ColumnIDs: []descpb.ColumnID{1, 2}},
+			{TableID: 53, Name: "x", ColumnIDs: []descpb.ColumnID{1}},
+			{TableID: 54, Name: "untouched", ColumnIDs: []descpb.ColumnID{7}},
+		},
+		TableVersions: map[descpb.ID]descpb.DescriptorVersion{52: 1, 53: 4},
+	}
+	tables := map[descpb.ID]catalog.TableDescriptor{
+		52: tabledesc.NewImmutable(descpb.TableDescriptor{
+			ID: 52, Name: "t", Version: 2, Columns: []descpb.ColumnDescriptor{{ID: 1, Name: "a"}},
+		}),
+		53: tabledesc.NewImmutable(descpb.TableDescriptor{
+			ID: 53, Name: "u", Version: 4, Columns: []descpb.ColumnDescriptor{{ID: 1, Name: "x"}},
+		}),
+	}
+	readAll := func(
+		lazyStats map[descpb.ID]func(context.Context) ([]*stats.TableStatisticProto, error),
+	) []string {
+		var names []string
+		for _, id := range []descpb.ID{52, 53, 54} {
+			read, ok := lazyStats[id]
+			require.True(t, ok, "no statistics for table %d", id)
+			res, err := read(ctx)
+			require.NoError(t, err)
+			for _, stat := range res {
+				names = append(names, stat.Name)
+			}
+		}
+		return names
+	}
+
+	manifest := BackupManifest{StatisticsFilenames: map[descpb.ID]string{
+		52: backupStatisticsFileName, 53: backupStatisticsFileName, 54: backupStatisticsFileName,
+	}}
+	// Nothing is read until the statistics of a table are requested, so the
+	// file may be written after the accessors were created.
+	stores := []cloud.ExternalStorage{store}
+	lazyStats := lazyStatisticsFromBackup(stores, nil /* encryption */, nil, /* dataKeys */
+		[]BackupManifest{manifest}, tables)
+	require.Len(t, lazyStats, 3)
+	require.NoError(t, writeTableStatistics(ctx, store, backupStatisticsFileName, nil, &statsTable))
+	require.Equal(t, []string{"a", "x", "untouched"}, readAll(lazyStats))
+
+	// Statistics inlined in pre-20.2 manifests are not version tagged, so they
+	// are always checked against the columns of the restored table.
+	manifest = BackupManifest{DeprecatedStatistics: statsTable.Statistics}
+	require.Equal(t, []string{"a", "x", "untouched"},
+		readAll(lazyStatisticsFromBackup(stores, nil /* encryption */, nil, /* dataKeys */
+			[]BackupManifest{manifest}, tables)))
+
+	// A missing statistics file is only reported when it is read.
+	missing := BackupManifest{StatisticsFilenames: map[descpb.ID]string{52: "missing"}}
+	lazyStats = lazyStatisticsFromBackup(stores, nil /* encryption */, nil, /* dataKeys */
+		[]BackupManifest{missing}, tables)
+	_, err = lazyStats[52](ctx)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+
+	// The statistics of a table are those of the latest layer which has any for
+	// it, falling back to those of earlier layers if its file is missing.
+	newer := StatsTable{
+		Statistics:    []*stats.TableStatisticProto{{TableID: 53, Name: "newer", ColumnIDs: []descpb.ColumnID{1}}},
+		TableVersions: map[descpb.ID]descpb.DescriptorVersion{53: 4},
+	}
+	require.NoError(t, writeTableStatistics(ctx, store, "newer", nil, &newer))
+	chain := []BackupManifest{{StatisticsFilenames: map[descpb.ID]string{
+		52: backupStatisticsFileName, 53: backupStatisticsFileName, 54: backupStatisticsFileName,
+	}}, {StatisticsFilenames: map[descpb.ID]string{52: "missing", 53: "newer"}}}
+	require.Equal(t, []string{"a", "newer", "untouched"},
+		readAll(lazyStatisticsFromBackup([]cloud.ExternalStorage{store, store}, nil, /* encryption */
+			nil /* dataKeys */, chain, tables)))
+}
+
+func TestCheckStatisticsFilesExist(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://1/backup", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, writeTableStatistics(ctx, store, backupStatisticsFileName, nil, &StatsTable{}))
+
+	manifest := BackupManifest{StatisticsFilenames: map[descpb.ID]string{
+		52: backupStatisticsFileName, 53: backupStatisticsFileName, 54: "missing",
+	}}
+	rewrites := DescRewriteMap{52: {ID: 62}, 53: {ID: 63}}
+	stores := []cloud.ExternalStorage{store}
+	require.NoError(t, checkStatisticsFilesExist(ctx, stores, []BackupManifest{manifest}, rewrites))
+
+	// Only the files of the restored tables must exist.
+	rewrites[54] = &jobspb.RestoreDetails_DescriptorRewrite{ID: 64}
+	err = checkStatisticsFilesExist(ctx, stores, []BackupManifest{manifest}, rewrites)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+	require.Contains(t, err.Error(), "checking table statistics file missing of backup")
+
+	// A missing file is fine if an earlier layer has statistics for the table.
+	chain := []BackupManifest{
+		{StatisticsFilenames: map[descpb.ID]string{54: backupStatisticsFileName}},
+		manifest,
+	}
+	require.NoError(t, checkStatisticsFilesExist(ctx, []cloud.ExternalStorage{store, store}, chain, rewrites))
+	chain[0].StatisticsFilenames[54] = "also-missing"
+	err = checkStatisticsFilesExist(ctx, []cloud.ExternalStorage{store, store}, chain, rewrites)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+	require.Contains(t, err.Error(), "checking table statistics file missing of backup")
+}
+
+func TestReadBackupManifestFromURIUsesStorageTransport(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	user := security.RootUserName()
+
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		name := path.Base(r.URL.Path)
+		switch r.Method {
+		case "PUT":
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			files[name] = data
+			w.WriteHeader(201)
+		case "GET":
+			data, ok := files[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		default:
+			http.Error(w, "unsupported method "+r.Method, 400)
+		}
+	}))
+	defer srv.Close()
+
+	settings := cluster.MakeTestingClusterSettings()
+	externalStorageFromURI := func(
+		ctx context.Context, uri string, user security.SQLUsername,
+	) (cloud.ExternalStorage, error) {
+		conf, err := cloudimpl.ExternalStorageConfFromURI(uri, user)
+		if err != nil {
+			return nil, err
+		}
+		return cloudimpl.MakeExternalStorage(ctx, conf, base.ExternalIODirConfig{}, settings,
+			nil /* blobClientFactory */, nil /* ie */, nil /* kvDB */)
+	}
+	setCA := func(ca string) {
+		u := settings.MakeUpdater()
+		require.NoError(t, u.Set(cloudimpl.CloudstorageHTTPCASetting, ca, "s"))
+	}
+
+	// The test server uses a self-signed certificate, so the store can only
+	// reach it if it was configured with that certificate as a custom root.
+	setCA(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})))
+	uri := srv.URL + "/backup"
+	store, err := externalStorageFromURI(ctx, uri, user)
+	require.NoError(t, err)
+	defer store.Close()
+	expected := BackupManifest{
+		Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1)},
+		Files:       []BackupManifest_File{makeTestFile("a", "b")},
+	}
+	require.NoError(t, writeBackupManifest(ctx, settings, store, backupManifestName, nil, &expected))
+
+	manifest, err := ReadBackupManifestFromURI(ctx, uri, user, externalStorageFromURI, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected.Files, manifest.Files)
+	require.Len(t, manifest.Descriptors, 1)
+
+	// Without the custom root the server's certificate is rejected.
+	setCA("")
+	_, err = ReadBackupManifestFromURI(ctx, uri, user, externalStorageFromURI, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "certificate")
+}
+
+func TestReadBackupManifestFromSignedURI(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	user := security.RootUserName()
+
+	// The server only serves the requests whose signature matches their path,
+	// as a store serving pre-signed URLs would.
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	sign := func(p string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(p))) }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			files[r.URL.Path] = data
+			w.WriteHeader(201)
+		case "GET":
+			if r.URL.Query().Get("sig") != sign(r.URL.Path) {
+				http.Error(w, "signature mismatch", 403)
+				return
+			}
+			data, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		default:
+			http.Error(w, "unsupported method "+r.Method, 400)
+		}
+	}))
+	defer srv.Close()
+
+	settings := cluster.MakeTestingClusterSettings()
+	externalStorageFromURI := func(
+		ctx context.Context, uri string, user security.SQLUsername,
+	) (cloud.ExternalStorage, error) {
+		conf, err := cloudimpl.ExternalStorageConfFromURI(uri, user)
+		if err != nil {
+			return nil, err
+		}
+		return cloudimpl.MakeExternalStorage(ctx, conf, base.ExternalIODirConfig{}, settings,
+			nil /* blobClientFactory */, nil /* ie */, nil /* kvDB */)
+	}
+	store, err := externalStorageFromURI(ctx, srv.URL+"/backup", user)
+	require.NoError(t, err)
+	defer store.Close()
+	expected := BackupManifest{
+		Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1)},
+		Files:       []BackupManifest_File{makeTestFile("a", "b")},
+	}
+	require.NoError(t, writeBackupManifest(ctx, settings, store, backupManifestName, nil, &expected))
+
+	manifestPath := "/backup/" + backupManifestName
+	uri := srv.URL + manifestPath + "?se=2020-12-31&sig=" + sign(manifestPath)
+	manifest, err := ReadBackupManifestFromURI(ctx, uri, user, externalStorageFromURI, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected.Files, manifest.Files)
+	require.Len(t, manifest.Descriptors, 1)
+
+	// The signature only grants access to the manifest it was made for.
+	uri = srv.URL + manifestPath + "?sig=" + sign("/backup/other")
+	_, err = ReadBackupManifestFromURI(ctx, uri, user, externalStorageFromURI, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "signature mismatch")
+
+	for uri, expected := range map[string]bool{
+		"https://host/backup/" + backupManifestName + "?sig=abc":    true,
+		"http://host/backup/" + backupOldManifestName + "?sig=abc":  true,
+		"https://host/backup/" + backupManifestName:                 false,
+		"https://host/backup?sig=abc":                               false,
+		"nodelocal://1/backup/" + backupManifestName + "?sig=abc":   false,
+		"https://host/backup/" + backupManifestName + "-CHECKSUM?a": false,
+	} {
+		_, ok := signedManifestName(uri)
+		require.Equal(t, expected, ok, uri)
+	}
+}
+
+func TestInflateElidedDescriptors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	full := BackupManifest{
+		EndTime: makeTestTimestamp(10),
+		Descriptors: []descpb.Descriptor{
+			makeTestTableDesc(52, 1), makeTestTableDesc(53, 1), makeTestTableDesc(54, 1),
+		},
+	}
+	// The first incremental alters 53, drops 54 and creates 55, the second one
+	// doesn't change anything.
+	inc1 := BackupManifest{
+		StartTime: makeTestTimestamp(10),
+		EndTime:   makeTestTimestamp(20),
+		Descriptors: []descpb.Descriptor{
+			makeTestTableDesc(52, 1), makeTestTableDesc(53, 2), makeTestTableDesc(55, 1),
+		},
+	}
+	inc2 := inc1
+	inc2.StartTime, inc2.EndTime = makeTestTimestamp(20), makeTestTimestamp(30)
+	expected := []BackupManifest{full, inc1, inc2}
+
+	elideDescriptors(&inc1, &full)
+	elideDescriptors(&inc2, &expected[1])
+	require.Equal(t, []descpb.ID{53, 54, 55}, func() (ids []descpb.ID) {
+		for _, rev := range inc1.DescriptorDelta {
+			ids = append(ids, rev.ID)
+		}
+		return ids
+	}())
+	require.Nil(t, inc1.DescriptorDelta[1].Desc)
+	require.True(t, inc2.DescriptorsElided)
+	require.Empty(t, inc2.DescriptorDelta)
+
+	// Only the delta is persisted for the incremental layers.
+	inc1.Descriptors, inc2.Descriptors = nil, nil
+	chain := []BackupManifest{full, inc1, inc2}
+	inflated, err := inflateElidedDescriptors(chain)
+	require.NoError(t, err)
+	require.Len(t, inflated, 3)
+	for i := range expected {
+		require.False(t, inflated[i].DescriptorsElided)
+		require.Equal(t, expected[i].Descriptors, inflated[i].Descriptors)
+	}
+	require.Empty(t, chain[1].Descriptors)
+
+	// Resolving to the middle layer yields its reconstructed descriptors.
+	descs, manifest, err := loadSQLDescsFromBackupsAtTime(ctx, inflated, makeTestTimestamp(15), nil /* idRewrite */, nil /* validate */, false /* validateParents */)
+	require.NoError(t, err)
+	require.Equal(t, makeTestTimestamp(20), manifest.EndTime)
+	var ids []descpb.ID
+	for _, desc := range descs {
+		ids = append(ids, desc.GetID())
+	}
+	require.Equal(t, []descpb.ID{52, 53, 55}, ids)
+
+	// Chains without elided layers are returned as is.
+	unchanged, err := inflateElidedDescriptors(expected)
+	require.NoError(t, err)
+	require.Equal(t, expected, unchanged)
+
+	// An elided layer needs a prior layer to be reconstructed from.
+	_, err = inflateElidedDescriptors(chain[1:])
+	require.Error(t, err)
+}
+
+func TestFilesOverlappingSpan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	files := []BackupManifest_File{
+		makeTestFile("a", "c"), makeTestFile("c", "e"), makeTestFile("g", "i"), makeTestFile("i", "k"),
+	}
+	paths := func(files []BackupManifest_File) []string {
+		res := []string{}
+		for _, f := range files {
+			res = append(res, f.Path)
+		}
+		return res
+	}
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+
+	for _, tc := range []struct {
+		span     roachpb.Span
+		expected []string
+	}{
+		{sp("", "\xff"), []string{"a-c.sst", "c-e.sst", "g-i.sst", "i-k.sst"}},
+		{sp("b", "d"), []string{"a-c.sst", "c-e.sst"}},
+		{sp("c", "e"), []string{"c-e.sst"}},
+		{sp("e", "g"), []string{}},
+		{sp("d", "h"), []string{"c-e.sst", "g-i.sst"}},
+		{sp("k", "z"), []string{}},
+		{sp("0", "a"), []string{}},
+		{roachpb.Span{Key: roachpb.Key("h")}, []string{"g-i.sst"}},
+		{roachpb.Span{Key: roachpb.Key("e")}, []string{}},
+	} {
+		t.Run(tc.span.String(), func(t *testing.T) {
+			require.Equal(t, tc.expected, paths(FilesOverlappingSpan(files, tc.span)))
+		})
+	}
+	require.Empty(t, FilesOverlappingSpan(nil, sp("a", "z")))
+}
+
+func TestFilesByTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	codec := keys.SystemSQLCodec
+	file := func(start, end roachpb.Key) BackupManifest_File {
+		return makeTestFile(string(start), string(end))
+	}
+	tenantPrefix := keys.MakeTenantPrefix(roachpb.MakeTenantID(10))
+	m := BackupManifest{
+		Descriptors: []descpb.Descriptor{
+			{Union: &descpb.Descriptor_Database{Database: &descpb.DatabaseDescriptor{ID: 1, Name: "db"}}},
+			makeTestTableDesc(52, 1),
+			makeTestTableDesc(53, 1),
+		},
+		Files: []BackupManifest_File{
+			file(codec.IndexPrefix(52, 1), codec.IndexPrefix(52, 2)),
+			file(codec.IndexPrefix(52, 2), codec.TablePrefix(53)),
+			// Spans tables 53 and 54.
+			file(codec.IndexPrefix(53, 1), codec.IndexPrefix(54, 1)),
+			// Table 60 isn't in the backup.
+			file(codec.TablePrefix(60), codec.TablePrefix(61)),
+			file(tenantPrefix, tenantPrefix.PrefixEnd()),
+			// The database's ID doesn't make its key space a table's.
+			file(codec.TablePrefix(1), codec.TablePrefix(2)),
+		},
+	}
+	byTable := FilesByTable(m)
+	require.Len(t, byTable, 2)
+	require.Equal(t, m.Files[:2], byTable[52])
+	require.Equal(t, m.Files[2:], byTable[descpb.InvalidID])
+	require.Empty(t, FilesByTable(BackupManifest{}))
+}
+
+func TestCoveredSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	// Revision history backups may have overlapping files.
+	m := BackupManifest{Files: []BackupManifest_File{
+		makeTestFile("g", "i"), makeTestFile("a", "c"), makeTestFile("c", "e"),
+		makeTestFile("h", "k"), makeTestFile("m", "n"),
+	}}
+	covered := CoveredSpans(m)
+	require.Equal(t, []roachpb.Span{sp("a", "e"), sp("g", "k"), sp("m", "n")}, covered)
+	require.Empty(t, CoveredSpans(BackupManifest{}))
+
+	for _, tc := range []struct {
+		targets  []roachpb.Span
+		expected []roachpb.Span
+	}{
+		{[]roachpb.Span{sp("a", "e")}, nil},
+		{[]roachpb.Span{sp("b", "d"), sp("h", "j")}, nil},
+		{[]roachpb.Span{sp("0", "b")}, []roachpb.Span{sp("0", "a")}},
+		{[]roachpb.Span{sp("d", "h")}, []roachpb.Span{sp("e", "g")}},
+		{[]roachpb.Span{sp("0", "z")}, []roachpb.Span{sp("0", "a"), sp("e", "g"), sp("k", "m"), sp("n", "z")}},
+		{[]roachpb.Span{sp("e", "g"), sp("x", "z")}, []roachpb.Span{sp("e", "g"), sp("x", "z")}},
+	} {
+		require.Equal(t, tc.expected, UncoveredSpans(covered, tc.targets), "%v", tc.targets)
+	}
+	require.Equal(t, []roachpb.Span{sp("a", "z")}, UncoveredSpans(nil, []roachpb.Span{sp("a", "z")}))
+}
+
+func TestManifestDictionaryCompression(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	user := security.RootUserName()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	settings := cluster.MakeTestingClusterSettings()
+
+	const baseURI = "nodelocal://1/base"
+	const incURI = baseURI + "/20201214/120000.00"
+	dictPath, ok := manifestDictionaryPath(baseURI, incURI)
+	require.True(t, ok)
+	require.Equal(t, "../../"+backupManifestDictionaryName, dictPath)
+
+	salt, err := storageccl.GenerateSalt()
+	require.NoError(t, err)
+	for _, encryption := range []*jobspb.BackupEncryptionOptions{
+		nil,
+		{Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("abc"), salt)},
+	} {
+		t.Run(fmt.Sprintf("encrypted=%t", encryption != nil), func(t *testing.T) {
+			baseStore, err := externalStorageFromURI(ctx, baseURI, user)
+			require.NoError(t, err)
+			defer baseStore.Close()
+			incStore, err := externalStorageFromURI(ctx, incURI, user)
+			require.NoError(t, err)
+			defer incStore.Close()
+
+			var descs []descpb.Descriptor
+			for id := descpb.ID(52); id < 100; id++ {
+				descs = append(descs, makeTestTableDesc(id, 1))
+			}
+			base := BackupManifest{Descriptors: descs}
+			require.NoError(t, writeBackupManifest(ctx, settings, baseStore, backupManifestName, encryption, &base))
+			require.NoError(t, writeManifestDictionaryIfNotExists(ctx, settings, baseStore, encryption, &base))
+
+			inc := BackupManifest{
+				Descriptors:    descs,
+				Files:          []BackupManifest_File{makeTestFile("a", "b")},
+				DictionaryPath: dictPath,
+			}
+			require.NoError(t, writeBackupManifest(ctx, settings, incStore, backupManifestName, encryption, &inc))
+			plain := inc
+			plain.DictionaryPath = ""
+			require.NoError(t, writeBackupManifest(ctx, settings, incStore, "plain", encryption, &plain))
+
+			readRaw := func(store cloud.ExternalStorage, filename string) []byte {
+				r, err := store.ReadFile(ctx, filename)
+				require.NoError(t, err)
+				defer r.Close()
+				b, err := ioutil.ReadAll(r)
+				require.NoError(t, err)
+				if encryption != nil {
+					b, err = storageccl.DecryptFile(b, encryption.Key)
+					require.NoError(t, err)
+				}
+				return b
+			}
+			withDict, withoutDict := readRaw(incStore, backupManifestName), readRaw(incStore, "plain")
+			require.True(t, bytes.HasPrefix(withDict, dictionaryCompressionPrefix))
+			require.Equal(t, ZipType, http.DetectContentType(withoutDict))
+			require.Less(t, len(withDict), len(withoutDict))
+
+			// The layer can be read both on its own and through the base backup.
+			for _, read := range []func() (BackupManifest, error){
+				func() (BackupManifest, error) {
+					return ReadBackupManifestFromURI(ctx, incURI, user, externalStorageFromURI, encryption)
+				},
+				func() (BackupManifest, error) {
+					return readBackupManifest(ctx, baseStore, "20201214/120000.00/"+backupManifestName, encryption)
+				},
+				func() (BackupManifest, error) {
+					return readBackupManifest(ctx, incStore, "plain", encryption)
+				},
+			} {
+				m, err := read()
+				require.NoError(t, err)
+				require.Equal(t, inc.Files, m.Files)
+				require.Len(t, m.Descriptors, len(descs))
+			}
+
+			// A dictionary which doesn't match the one the manifest was compressed
+			// with is detected.
+			require.NoError(t, baseStore.Delete(ctx, backupManifestDictionaryName))
+			require.NoError(t, writeManifestDictionaryIfNotExists(ctx, settings, baseStore, encryption, &BackupManifest{}))
+			_, err = readBackupManifest(ctx, incStore, backupManifestName, encryption)
+			require.Error(t, err)
+			require.NoError(t, baseStore.Delete(ctx, backupManifestDictionaryName))
+			require.NoError(t, baseStore.Delete(ctx, backupManifestName))
+		})
+	}
+}
+
+func TestDescriptorSummaries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	manifest := BackupManifest{Descriptors: []descpb.Descriptor{
+		{Union: &descpb.Descriptor_Database{
+			Database: &descpb.DatabaseDescriptor{ID: 50, Name: "db"},
+		}},
+		{Union: &descpb.Descriptor_Schema{
+			Schema: &descpb.SchemaDescriptor{ID: 51, Name: "sc", ParentID: 50},
+		}},
+		makeTestTableDesc(52, 1),
+		{Union: &descpb.Descriptor_Type{
+			Type: &descpb.TypeDescriptor{ID: 53, Name: "typ", ParentID: 50, ParentSchemaID: 51},
+		}},
+	}}
+	require.Equal(t, []DescSummary{
+		{ID: 50, Name: "db", Kind: "database"},
+		{ID: 51, Name: "sc", ParentID: 50, Kind: "schema"},
+		{ID: 52, Name: "t", ParentID: 1, Kind: "relation"},
+		{ID: 53, Name: "typ", ParentID: 50, Kind: "type"},
+	}, DescriptorSummaries(manifest))
+	require.Empty(t, DescriptorSummaries(BackupManifest{}))
+}
+
+func TestLoadSQLDescsFromBackupsAtTimeValidation(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	manifests := []BackupManifest{{
+		EndTime: hlc.Timestamp{WallTime: 10},
+		Descriptors: []descpb.Descriptor{
+			makeTestTableDesc(52, 1), makeTestTableDesc(53, 1), makeTestTableDesc(54, 1),
+		},
+	}}
+
+	descs, _, err := loadSQLDescsFromBackupsAtTime(ctx, manifests, hlc.Timestamp{}, nil /* idRewrite */, nil /* validate */, false /* validateParents */)
+	require.NoError(t, err)
+	require.Len(t, descs, 3)
+
+	var validated []descpb.ID
+	validate := func(desc catalog.Descriptor) error {
+		validated = append(validated, desc.GetID())
+		if desc.GetID() != 53 {
+			return errors.New("bad descriptor")
+		}
+		return nil
+	}
+	_, _, err = loadSQLDescsFromBackupsAtTime(ctx, manifests, hlc.Timestamp{}, nil /* idRewrite */, validate, false /* validateParents */)
+	require.Equal(t, []descpb.ID{52, 53, 54}, validated)
+	require.EqualError(t, err, `backup contains 2 invalid descriptors: `+
+		`"t" (52): bad descriptor; "t" (54): bad descriptor`)
+}
+
+// flakyStorage fails reads of its files partway through for the given number of
+// reads, recording the offset at which each read starts.
+type flakyStorage struct {
+	cloud.ExternalStorage
+	failures int
+	offsets  []int64
+}
+
+type flakyReader struct {
+	io.Reader
+	io.Closer
+}
+
+var errFlakyRead = errors.New("connection reset")
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errFlakyRead }
+
+func (s *flakyStorage) open(r io.ReadCloser, offset int64) io.ReadCloser {
+	s.offsets = append(s.offsets, offset)
+	if s.failures == 0 {
+		return r
+	}
+	s.failures--
+	return flakyReader{
+		Reader: io.MultiReader(io.LimitReader(r, 4), failingReader{}),
+		Closer: r,
+	}
+}
+
+func (s *flakyStorage) ReadFile(ctx context.Context, basename string) (io.ReadCloser, error) {
+	r, err := s.ExternalStorage.ReadFile(ctx, basename)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(r, 0), nil
+}
+
+// rangeReadFlakyStorage is a flakyStorage which supports range reads. The
+// version of a file is the checksum of its contents. If beforeResume is set,
+// it's called before each read which doesn't start at the beginning of a file.
+type rangeReadFlakyStorage struct {
+	*flakyStorage
+	beforeResume func()
+}
+
+func (s rangeReadFlakyStorage) ReadFileAt(
+	ctx context.Context, basename string, offset int64, version string,
+) (io.ReadCloser, string, error) {
+	if offset > 0 && s.beforeResume != nil {
+		s.beforeResume()
+	}
+	r, err := s.ExternalStorage.ReadFile(ctx, basename)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := r.Close(); err != nil {
+		return nil, "", err
+	}
+	current := fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+	if version != "" && version != current {
+		return nil, "", errors.Wrapf(cloudimpl.ErrFileChanged, "%s changed", basename)
+	}
+	return s.open(ioutil.NopCloser(bytes.NewReader(data[offset:])), offset), current, nil
+}
+
+func TestWriteManifestProgress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/progress", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+
+	type upload struct{ written, size int64 }
+	uploads := make(map[string][]upload)
+	opts := manifestWriteOptions{progress: func(filename string, written, size int64) {
+		uploads[filename] = append(uploads[filename], upload{written, size})
+	}}
+
+	m := BackupManifest{Files: []BackupManifest_File{makeTestFile("a", "b"), makeTestFile("c", "d")}}
+	require.NoError(t, writeBackupManifestWithOptions(ctx, cluster.MakeTestingClusterSettings(),
+		store, backupManifestName, nil /* encryption */, &m, opts))
+	require.NoError(t, writeBackupPartitionDescriptorWithOptions(ctx, store, "BACKUP_PART_1_region_east",
+		nil /* encryption */, &BackupPartitionDescriptor{LocalityKV: "region=east"}, opts))
+	require.NoError(t, writeTableStatisticsWithOptions(ctx, store, backupStatisticsFileName,
+		nil /* encryption */, &StatsTable{TableVersions: map[descpb.ID]descpb.DescriptorVersion{52: 1}}, opts))
+
+	for _, filename := range []string{
+		backupManifestName, backupManifestName + backupManifestChecksumSuffix,
+		"BACKUP_PART_1_region_east", backupStatisticsFileName,
+	} {
+		size, err := store.Size(ctx, filename)
+		require.NoError(t, err)
+		reported := uploads[filename]
+		require.NotEmpty(t, reported, filename)
+		for i := range reported {
+			require.Equal(t, size, reported[i].size)
+			if i > 0 {
+				require.LessOrEqual(t, reported[i-1].written, reported[i].written)
+			}
+		}
+		require.Equal(t, size, reported[len(reported)-1].written)
+	}
+
+	// Without a callback, nothing is reported.
+	uploads = make(map[string][]upload)
+	require.NoError(t, writeBackupManifest(ctx, cluster.MakeTestingClusterSettings(),
+		store, backupManifestName, nil /* encryption */, &m))
+	require.Empty(t, uploads)
+}
+
+func TestWriteBackupPartitionDescriptorValidatesLocality(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/partitions", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, locality := range []string{"", "region", "region=", "=east", "region=east=1"} {
+		err := writeBackupPartitionDescriptor(ctx, store, "BACKUP_PART_1", nil, /* encryption */
+			&BackupPartitionDescriptor{LocalityKV: locality})
+		require.Error(t, err, locality)
+		require.Contains(t, err.Error(), "invalid locality of backup partition descriptor BACKUP_PART_1")
+	}
+	_, err = store.ReadFile(ctx, "BACKUP_PART_1")
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+
+	require.NoError(t, writeBackupPartitionDescriptor(ctx, store, "BACKUP_PART_1", nil, /* encryption */
+		&BackupPartitionDescriptor{LocalityKV: "region=east"}))
+}
+
+// randomBackupFiles returns n files with random spans, some of which share
+// their start key or their whole span.
+func randomBackupFiles(rng *rand.Rand, n int) BackupFileDescriptors {
+	files := make(BackupFileDescriptors, n)
+	for i := range files {
+		key := fmt.Sprintf("%08d", rng.Intn(n))
+		files[i] = makeTestFile(key, key+fmt.Sprintf("%02d", rng.Intn(4)))
+		files[i].Path = fmt.Sprintf("%d.sst", i)
+	}
+	return files
+}
+
+func TestSortBackupFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	rng, _ := randutil.NewPseudoRand()
+	spans := func(files BackupFileDescriptors) []roachpb.Span {
+		res := make([]roachpb.Span, len(files))
+		for i := range files {
+			res[i] = files[i].Span
+		}
+		return res
+	}
+	for _, n := range []int{0, 1, 2, 7, 100, 1000, 4099} {
+		for _, threshold := range []int{0, 1, 50, 5000} {
+			files := randomBackupFiles(rng, n)
+			expected := append(BackupFileDescriptors(nil), files...)
+			sort.Sort(expected)
+			sortBackupFiles(files, threshold)
+			require.Equal(t, spans(expected), spans(files), "n=%d threshold=%d", n, threshold)
+
+			// Every file is still there.
+			paths := make(map[string]struct{}, n)
+			for i := range files {
+				paths[files[i].Path] = struct{}{}
+			}
+			require.Len(t, paths, n)
+		}
+	}
+}
+
+func BenchmarkSortBackupFiles(b *testing.B) {
+	rng, _ := randutil.NewPseudoRand()
+	for _, n := range []int{1 << 20, 1 << 22} {
+		files := randomBackupFiles(rng, n)
+		for _, parallel := range []bool{false, true} {
+			b.Run(fmt.Sprintf("files=%d/parallel=%t", n, parallel), func(b *testing.B) {
+				threshold := 0
+				if parallel {
+					threshold = 1
+				}
+				toSort := make(BackupFileDescriptors, n)
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					copy(toSort, files)
+					b.StartTimer()
+					sortBackupFiles(toSort, threshold)
+				}
+			})
+		}
+	}
+}
+
+// recordingTB records the errors reported to it instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestCanonicalizeFileSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	files := []BackupManifest_File{
+		makeTestFile("e", "g"), makeTestFile("a", "b"), makeTestFile("f", "h"),
+		makeTestFile("b", "c"), makeTestFile("k", "m"), makeTestFile("e", "f"),
+	}
+	require.Equal(t, []roachpb.Span{sp("a", "c"), sp("e", "h"), sp("k", "m")}, CanonicalizeFileSpans(files))
+	require.Empty(t, CanonicalizeFileSpans(nil))
+
+	AssertSpansEqual(t, []roachpb.Span{sp("k", "m"), sp("a", "c"), sp("e", "h")}, CanonicalizeFileSpans(files))
+	AssertSpansEqual(t, []roachpb.Span{sp("a", "b"), sp("b", "c")}, []roachpb.Span{sp("a", "c")})
+
+	rec := &recordingTB{TB: t}
+	AssertSpansEqual(rec, []roachpb.Span{sp("a", "c"), sp("e", "h")}, []roachpb.Span{sp("a", "c"), sp("x", "z")})
+	require.Equal(t, []string{fmt.Sprintf("spans differ (-expected +actual):\n  %s\n- %s\n+ %s\n",
+		sp("a", "c"), sp("e", "h"), sp("x", "z"))}, rec.errs)
+}
+
+func TestReadFileResumable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/resumable", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	content := []byte("0123456789abcdef")
+	require.NoError(t, store.WriteFile(ctx, "file", bytes.NewReader(content)))
+
+	t.Run("range-reads", func(t *testing.T) {
+		s := &flakyStorage{ExternalStorage: store, failures: 2}
+		data, err := readFileResumable(ctx, rangeReadFlakyStorage{flakyStorage: s}, "file", nil /* peek */)
+		require.NoError(t, err)
+		require.Equal(t, content, data)
+		require.Equal(t, []int64{0, 4, 8}, s.offsets)
+	})
+
+	t.Run("full-reads", func(t *testing.T) {
+		s := &flakyStorage{ExternalStorage: store, failures: 2}
+		data, err := readFileResumable(ctx, s, "file", nil /* peek */)
+		require.NoError(t, err)
+		require.Equal(t, content, data)
+		require.Equal(t, []int64{0, 0, 0}, s.offsets)
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		// The file is overwritten after the first read fails, so the read starts
+		// over rather than resuming into the new version.
+		changed := []byte("fedcba9876543210")
+		defer func() { require.NoError(t, store.WriteFile(ctx, "file", bytes.NewReader(content))) }()
+		s := &flakyStorage{ExternalStorage: store, failures: 1}
+		data, err := readFileResumable(ctx, rangeReadFlakyStorage{flakyStorage: s, beforeResume: func() {
+			require.NoError(t, store.WriteFile(ctx, "file", bytes.NewReader(changed)))
+		}}, "file", nil /* peek */)
+		require.NoError(t, err)
+		require.Equal(t, changed, data)
+		require.Equal(t, []int64{0, 0}, s.offsets)
+	})
+
+	t.Run("too-many-failures", func(t *testing.T) {
+		s := &flakyStorage{ExternalStorage: store, failures: maxManifestReadResumes + 1}
+		_, err := readFileResumable(ctx, rangeReadFlakyStorage{flakyStorage: s}, "file", nil /* peek */)
+		require.True(t, errors.Is(err, errFlakyRead), "%+v", err)
+	})
+
+	t.Run("peek", func(t *testing.T) {
+		long := bytes.Repeat(content, 10)
+		require.NoError(t, store.WriteFile(ctx, "long", bytes.NewReader(long)))
+		var heads [][]byte
+		peek := func(head []byte) error {
+			heads = append(heads, append([]byte(nil), head...))
+			return nil
+		}
+		data, err := readFileResumable(ctx, store, "long", peek)
+		require.NoError(t, err)
+		require.Equal(t, long, data)
+		data, err = readFileResumable(ctx, store, "file", peek)
+		require.NoError(t, err)
+		require.Equal(t, content, data)
+		require.Equal(t, [][]byte{long[:manifestPeekSize], content}, heads)
+
+		errPeek := errors.New("peeked")
+		_, err = readFileResumable(ctx, store, "long", func([]byte) error { return errPeek })
+		require.True(t, errors.Is(err, errPeek), "%+v", err)
+	})
+}
+
+func TestBytewiseProgress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var files BackupFileDescriptors
+	for i, size := range []int64{10, 0, 300, 10, 680} {
+		f := makeTestFile(string(rune('a'+i)), string(rune('b'+i)))
+		f.EntryCounts.DataSize = size
+		files = append(files, f)
+	}
+	p := NewBytewiseProgress(files)
+	require.Equal(t, float32(0), p.Fraction())
+
+	// Empty files still carry some weight.
+	p.Add(files[1])
+	require.InDelta(t, 1.0/1001, p.Fraction(), 1e-6)
+	p.Add(files[2])
+	require.InDelta(t, 301.0/1001, p.Fraction(), 1e-6)
+
+	var wg sync.WaitGroup
+	for _, f := range []BackupManifest_File{files[0], files[3], files[4]} {
+		wg.Add(1)
+		go func(f BackupManifest_File) {
+			defer wg.Done()
+			p.Add(f)
+		}(f)
+	}
+	wg.Wait()
+	require.Equal(t, float32(1), p.Fraction())
+
+	require.Equal(t, float32(1), NewBytewiseProgress(nil).Fraction())
+}
+
+func TestExportFileList(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	b := makeTestFile("b", "c")
+	b.EntryCounts = RowCount{DataSize: 20, Rows: 2, IndexEntries: 1}
+	a := makeTestFile("a", "b")
+	a.EntryCounts = RowCount{DataSize: 10, Rows: 1}
+	m := BackupManifest{Files: []BackupManifest_File{b, a}}
+
+	var buf bytes.Buffer
+	require.NoError(t, ExportFileList(m, &buf, FileListCSV))
+	require.Equal(t, `path,start_key,end_key,size,entries
+a-b.sst,61,62,10,1
+b-c.sst,62,63,20,3
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, ExportFileList(m, &buf, FileListJSON))
+	require.Equal(t, `{"path":"a-b.sst","start_key":"61","end_key":"62","size":10,"entries":1}
+{"path":"b-c.sst","start_key":"62","end_key":"63","size":20,"entries":3}
+`, buf.String())
+
+	// The manifest's own files are left as they were.
+	require.Equal(t, "b-c.sst", m.Files[0].Path)
+}
+
+func TestLoadSQLDescsFromBackupsAtTimeUnsortedChanges(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	db := descpb.Descriptor{Union: &descpb.Descriptor_Database{
+		Database: &descpb.DatabaseDescriptor{ID: 1, Name: "db"},
+	}}
+	v1, v2 := makeTestTableDesc(52, 1), makeTestTableDesc(52, 2)
+	dropped := makeTestTableDesc(53, 1)
+	manifests := []BackupManifest{{
+		EndTime:     makeTestTimestamp(30),
+		MVCCFilter:  MVCCFilter_All,
+		Descriptors: []descpb.Descriptor{db, v2},
+		// The revisions are deliberately out of order.
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			{Time: makeTestTimestamp(20), ID: 52, Desc: &v2},
+			{Time: makeTestTimestamp(25), ID: 53},
+			{Time: makeTestTimestamp(5), ID: 1, Desc: &db},
+			{Time: makeTestTimestamp(10), ID: 52, Desc: &v1},
+			{Time: makeTestTimestamp(8), ID: 53, Desc: &dropped},
+		},
+	}}
+
+	versions := func(asOf hlc.Timestamp) map[descpb.ID]descpb.DescriptorVersion {
+		descs, _, err := loadSQLDescsFromBackupsAtTime(ctx, manifests, asOf, nil /* idRewrite */, nil /* validate */, false /* validateParents */)
+		require.NoError(t, err)
+		res := make(map[descpb.ID]descpb.DescriptorVersion)
+		for _, desc := range descs {
+			res[desc.GetID()] = desc.GetVersion()
+		}
+		return res
+	}
+	require.Equal(t, map[descpb.ID]descpb.DescriptorVersion{1: 0, 52: 1, 53: 1}, versions(makeTestTimestamp(15)))
+	require.Equal(t, map[descpb.ID]descpb.DescriptorVersion{1: 0, 52: 2}, versions(makeTestTimestamp(30)))
+	// The manifest itself is left as it was.
+	require.Equal(t, makeTestTimestamp(20), manifests[0].DescriptorChanges[0].Time)
+}
+
+func TestValidateManifestTimes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	files := []BackupManifest_File{makeTestFile("a", "b")}
+	for _, tc := range []struct {
+		name     string
+		manifest BackupManifest
+		err      string
+	}{
+		{name: "full", manifest: BackupManifest{EndTime: makeTestTimestamp(10), Files: files}},
+		{name: "incremental", manifest: BackupManifest{StartTime: makeTestTimestamp(10), EndTime: makeTestTimestamp(20), Files: files}},
+		{name: "empty", manifest: BackupManifest{}},
+		{name: "revisions", manifest: BackupManifest{
+			StartTime: makeTestTimestamp(10), RevisionStartTime: makeTestTimestamp(15), EndTime: makeTestTimestamp(20), Files: files,
+		}},
+		{
+			name:     "end-before-start",
+			manifest: BackupManifest{StartTime: makeTestTimestamp(20), EndTime: makeTestTimestamp(10), Files: files},
+			err:      "end time 0.000000010,0 is before start time 0.000000020,0",
+		},
+		{
+			name:     "unset-end",
+			manifest: BackupManifest{Files: files},
+			err:      "end time is unset for a backup of 1 files",
+		},
+		{
+			name: "revisions-before-start",
+			manifest: BackupManifest{
+				StartTime: makeTestTimestamp(10), RevisionStartTime: makeTestTimestamp(5), EndTime: makeTestTimestamp(20), Files: files,
+			},
+			err: "revision start time 0.000000005,0 is outside of [0.000000010,0, 0.000000020,0]",
+		},
+		{
+			name: "revisions-after-end",
+			manifest: BackupManifest{
+				StartTime: makeTestTimestamp(10), RevisionStartTime: makeTestTimestamp(25), EndTime: makeTestTimestamp(20), Files: files,
+			},
+			err: "revision start time 0.000000025,0 is outside of",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateManifestTimes(&tc.manifest)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestValidateManifestClusterVersion(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/versions", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	sv := &store.Settings().SV
+	binaryVersion := store.Settings().Version.BinaryVersion()
+
+	older := binaryVersion
+	older.Minor--
+	newer := binaryVersion
+	newer.Major++
+	for _, v := range []roachpb.Version{{}, older, binaryVersion} {
+		require.NoError(t, validateManifestClusterVersion(&BackupManifest{ClusterVersion: v}, binaryVersion))
+	}
+
+	m := BackupManifest{EndTime: hlc.Timestamp{WallTime: 10}, ClusterVersion: newer}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, nil, &m))
+	// The version is only checked when validation is enabled.
+	read, err := readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, newer, read.ClusterVersion)
+
+	manifestValidationEnabled.Override(sv, true)
+	_, err = readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.Error(t, err)
+	require.Equal(t, pgcode.FeatureNotSupported, pgerror.GetPGCode(err))
+	require.Contains(t, err.Error(), fmt.Sprintf(
+		"backup was taken by a cluster at version %s, which is newer than the version %s", newer, binaryVersion))
+}
+
+func TestReadBackupManifestRefusesNewerFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/format", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	settings := cluster.MakeTestingClusterSettings()
+
+	m := BackupManifest{EndTime: hlc.Timestamp{WallTime: 10}, FormatVersion: backupFormatMaxSupportedVersion}
+	require.NoError(t, writeBackupManifest(ctx, settings, store, backupManifestName, nil, &m))
+	_, err = readBackupManifest(ctx, store, backupManifestName, nil /* encryption */)
+	require.NoError(t, err)
+
+	m.FormatVersion = backupFormatMaxSupportedVersion + 1
+	require.NoError(t, writeBackupManifest(ctx, settings, store, backupManifestName, nil, &m))
+	_, err = readBackupManifest(ctx, store, backupManifestName, nil /* encryption */)
+	require.EqualError(t, err, fmt.Sprintf("backup format version %d is newer than supported version %d; "+
+		"the backup must be read by a newer version of CockroachDB",
+		backupFormatMaxSupportedVersion+1, backupFormatMaxSupportedVersion))
+}
+
+// TestWriteBackupManifestFormatVersion checks that writers bump the format
+// version of the manifests which use newer features.
+func TestWriteBackupManifestFormatVersion(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/format", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	settings := cluster.MakeTestingClusterSettings()
+
+	m := BackupManifest{Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1)}}
+	require.NoError(t, writeManifestDictionaryIfNotExists(ctx, settings, store, nil, &m))
+	for _, tc := range []struct {
+		name     string
+		m        BackupManifest
+		expected uint32
+	}{
+		{"plain", BackupManifest{FormatVersion: BackupFormatDescriptorTrackingVersion},
+			BackupFormatDescriptorTrackingVersion},
+		{"elided", BackupManifest{FormatVersion: BackupFormatDescriptorTrackingVersion, DescriptorsElided: true},
+			backupFormatElidedDescriptorsVersion},
+		{"dictionary", BackupManifest{DictionaryPath: backupManifestDictionaryName, DescriptorsElided: true},
+			backupFormatDictionaryCompressionVersion},
+		{"inline", BackupManifest{Files: []BackupManifest_File{{Path: "1.sst", InlineData: []byte("x")}}},
+			backupFormatInlineFilesVersion},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, writeBackupManifest(ctx, settings, store, backupManifestName, nil, &tc.m))
+			read, err := readBackupManifest(ctx, store, backupManifestName, nil /* encryption */)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, read.FormatVersion)
+		})
+	}
+}
+
+func TestRewriteFileSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	from := keys.MakeTenantPrefix(roachpb.MakeTenantID(10))
+	to := keys.MakeTenantPrefix(roachpb.MakeTenantID(20))
+	file := func(start, end string) BackupManifest_File {
+		f := makeTestFile(start, end)
+		f.Span = roachpb.Span{
+			Key:    append(from[:len(from):len(from)], start...),
+			EndKey: append(from[:len(from):len(from)], end...),
+		}
+		return f
+	}
+	rewriteKey := func(k roachpb.Key) roachpb.Key {
+		return append(to[:len(to):len(to)], bytes.TrimPrefix(k, from)...)
+	}
+	retenant := func(span roachpb.Span) roachpb.Span {
+		return roachpb.Span{Key: rewriteKey(span.Key), EndKey: rewriteKey(span.EndKey)}
+	}
+
+	files := []BackupManifest_File{file("a", "b"), file("b", "d"), file("e", "f")}
+	require.NoError(t, RewriteFileSpans(files, retenant))
+	var paths []string
+	for _, f := range files {
+		require.True(t, bytes.HasPrefix(f.Span.Key, to), "%s", f.Span)
+		require.True(t, bytes.HasPrefix(f.Span.EndKey, to), "%s", f.Span)
+		paths = append(paths, f.Path)
+	}
+	require.Equal(t, []string{"a-b.sst", "b-d.sst", "e-f.sst"}, paths)
+
+	// A rewrite which reorders the files leaves them sorted.
+	files = []BackupManifest_File{makeTestFile("a", "b"), makeTestFile("c", "d")}
+	swap := map[string]string{"a": "x", "b": "y", "c": "m", "d": "n"}
+	require.NoError(t, RewriteFileSpans(files, func(span roachpb.Span) roachpb.Span {
+		return roachpb.Span{
+			Key: roachpb.Key(swap[string(span.Key)]), EndKey: roachpb.Key(swap[string(span.EndKey)]),
+		}
+	}))
+	require.Equal(t, "c-d.sst", files[0].Path)
+	require.Equal(t, roachpb.Key("m"), files[0].Span.Key)
+
+	// Rewrites which produce invalid or overlapping spans are rejected.
+	files = []BackupManifest_File{makeTestFile("a", "b")}
+	require.Error(t, RewriteFileSpans(files, func(span roachpb.Span) roachpb.Span {
+		return roachpb.Span{Key: span.EndKey, EndKey: span.Key}
+	}))
+	files = []BackupManifest_File{makeTestFile("a", "b"), makeTestFile("c", "d")}
+	require.Error(t, RewriteFileSpans(files, func(span roachpb.Span) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key("a"), EndKey: span.EndKey}
+	}))
+}
+
+func TestMinimalCoveringChain(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	manifests := []BackupManifest{
+		{EndTime: makeTestTimestamp(10)},
+		{StartTime: makeTestTimestamp(10), EndTime: makeTestTimestamp(20)},
+		{StartTime: makeTestTimestamp(20), EndTime: makeTestTimestamp(30)},
+		{StartTime: makeTestTimestamp(30), EndTime: makeTestTimestamp(40)},
+	}
+	uris := []string{"full", "inc1", "inc2", "inc3"}
+
+	for _, tc := range []struct {
+		t1, t2   int64
+		expected []string
+	}{
+		{0, 40, []string{"full", "inc1", "inc2", "inc3"}},
+		{5, 5, []string{"full"}},
+		{10, 10, []string{"full"}},
+		{10, 15, []string{"full", "inc1"}},
+		{12, 28, []string{"inc1", "inc2"}},
+		{21, 30, []string{"inc2"}},
+		{35, 40, []string{"inc3"}},
+	} {
+		t.Run(fmt.Sprintf("%d-%d", tc.t1, tc.t2), func(t *testing.T) {
+			sub, subURIs, err := MinimalCoveringChain(manifests, uris, makeTestTimestamp(tc.t1), makeTestTimestamp(tc.t2))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, subURIs)
+			require.Len(t, sub, len(tc.expected))
+			require.True(t, makeTestTimestamp(tc.t1).LessEq(sub[0].EndTime))
+			require.True(t, makeTestTimestamp(tc.t2).LessEq(sub[len(sub)-1].EndTime))
+		})
+	}
+
+	_, _, err := MinimalCoveringChain(manifests, uris, makeTestTimestamp(20), makeTestTimestamp(10))
+	require.Error(t, err)
+	_, _, err = MinimalCoveringChain(manifests, uris, makeTestTimestamp(35), makeTestTimestamp(45))
+	require.Error(t, err)
+	_, _, err = MinimalCoveringChain(manifests[1:], uris[1:], makeTestTimestamp(5), makeTestTimestamp(15))
+	require.Error(t, err)
+
+	// A gap in the chain is only an error when it falls in the requested range.
+	gappy := append([]BackupManifest(nil), manifests...)
+	gappy[2].StartTime = makeTestTimestamp(25)
+	_, subURIs, err := MinimalCoveringChain(gappy, uris, makeTestTimestamp(32), makeTestTimestamp(38))
+	require.NoError(t, err)
+	require.Equal(t, []string{"inc3"}, subURIs)
+	_, _, err = MinimalCoveringChain(gappy, uris, makeTestTimestamp(15), makeTestTimestamp(28))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "there is a gap between")
+	// The gap just before the first layer of the range is in it too.
+	_, _, err = MinimalCoveringChain(gappy, uris, makeTestTimestamp(22), makeTestTimestamp(28))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "there is a gap between")
+	_, subURIs, err = MinimalCoveringChain(gappy, uris, makeTestTimestamp(26), makeTestTimestamp(28))
+	require.NoError(t, err)
+	require.Equal(t, []string{"inc2"}, subURIs)
+}
+
+func TestDecompressDataLimit(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	data := bytes.Repeat([]byte("a"), 1<<20)
+	compressed, err := compressData(data)
+	require.NoError(t, err)
+	require.Less(t, len(compressed), 1<<12)
+
+	decompressed, err := decompressDataLimit(compressed, int64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, data, decompressed)
+
+	_, err = decompressDataLimit(compressed, int64(len(data))-1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decompresses to more than the maximum")
+
+	// Reading a manifest is subject to the limit from the cluster setting.
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/bomb", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	m := BackupManifest{EndTime: hlc.Timestamp{WallTime: 10}, Files: []BackupManifest_File{makeTestFile("a", "b")}}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, nil, &m))
+	_, err = readBackupManifest(ctx, store, backupManifestName, nil /* encryption */)
+	require.NoError(t, err)
+	maxDecompressedManifestSize.Override(&store.Settings().SV, 1)
+	_, err = readBackupManifest(ctx, store, backupManifestName, nil /* encryption */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decompresses to more than the maximum")
+}
+
+func TestBackupDigest(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/digest", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	stores := []cloud.ExternalStorage{store}
+
+	m := BackupManifest{
+		EndTime:             hlc.Timestamp{WallTime: 10},
+		Files:               []BackupManifest_File{makeTestFile("a", "b")},
+		StatisticsFilenames: map[descpb.ID]string{52: backupStatisticsFileName + "-52"},
+	}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, nil, &m))
+	require.NoError(t, store.WriteFile(ctx, backupStatisticsFileName+"-52", bytes.NewReader([]byte("stats"))))
+
+	digest, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.Len(t, digest, sha256.Size)
+	again, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, digest, again)
+
+	// Modifying a metadata file changes the digest.
+	require.NoError(t, store.WriteFile(ctx, backupStatisticsFileName+"-52", bytes.NewReader([]byte("STATS"))))
+	modified, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.NotEqual(t, digest, modified)
+
+	// So does removing an optional one.
+	require.NoError(t, store.Delete(ctx, backupStatisticsFileName+"-52"))
+	removed, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.NotEqual(t, digest, removed)
+	require.NotEqual(t, modified, removed)
+
+	// So do the files shared by the layers of the backup.
+	require.NoError(t, store.WriteFile(ctx, backupManifestDictionaryName, bytes.NewReader([]byte("dict"))))
+	withDict, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.NotEqual(t, removed, withDict)
+
+	// And the metadata files of the appended layers.
+	const layer = "20201231/000000.00"
+	inc := BackupManifest{
+		StartTime:           m.EndTime,
+		EndTime:             hlc.Timestamp{WallTime: 20},
+		Files:               []BackupManifest_File{makeTestFile("b", "c")},
+		StatisticsFilenames: map[descpb.ID]string{52: backupStatisticsFileName + "-52"},
+	}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, layer+"/"+backupManifestName, nil, &inc))
+	withLayer, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.NotEqual(t, withDict, withLayer)
+	require.NoError(t, store.WriteFile(ctx, layer+"/"+backupStatisticsFileName+"-52", bytes.NewReader([]byte("stats"))))
+	withLayerStats, err := BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.NoError(t, err)
+	require.NotEqual(t, withLayer, withLayerStats)
+
+	_, err = BackupDigest(ctx, nil /* stores */, m, nil /* encryption */)
+	require.Error(t, err)
+
+	// A missing partition descriptor is an error.
+	m.PartitionDescriptorFilenames = []string{backupPartitionDescriptorPrefix + "_missing"}
+	_, err = BackupDigest(ctx, stores, m, nil /* encryption */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not found in backup locations")
+}
+
+func TestResolveBackupManifestsPerLayerEncryption(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+
+	const fullURI, incURI = "nodelocal://0/full", "nodelocal://0/inc"
+	layerKeys := map[string]*jobspb.BackupEncryptionOptions{
+		fullURI: {Mode: jobspb.EncryptionMode_Passphrase, Key: bytes.Repeat([]byte("a"), 32)},
+		incURI:  {Mode: jobspb.EncryptionMode_Passphrase, Key: bytes.Repeat([]byte("b"), 32)},
+	}
+	layers := map[string]BackupManifest{
+		fullURI: {EndTime: hlc.Timestamp{WallTime: 10}, Files: []BackupManifest_File{makeTestFile("a", "b")}},
+		incURI: {
+			StartTime: hlc.Timestamp{WallTime: 10}, EndTime: hlc.Timestamp{WallTime: 20},
+			Files: []BackupManifest_File{makeTestFile("b", "c")},
+		},
+	}
+	for uri, m := range layers {
+		store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+		require.NoError(t, err)
+		m := m
+		require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, layerKeys[uri], &m))
+		require.NoError(t, store.Close())
+	}
+	baseStore, err := externalStorageFromURI(ctx, fullURI, security.RootUserName())
+	require.NoError(t, err)
+	defer baseStore.Close()
+
+	from := [][]string{{fullURI}, {incURI}}
+	resolve := func(encryptionForLayer backupEncryptionResolver) ([]BackupManifest, error) {
+		_, manifests, _, _, err := resolveBackupManifests(
+			ctx, []cloud.ExternalStorage{baseStore}, externalStorageFromURI, from,
+			hlc.Timestamp{}, encryptionForLayer, security.RootUserName(),
+		)
+		return manifests, err
+	}
+
+	manifests, err := resolve(func(uri string) (*jobspb.BackupEncryptionOptions, error) {
+		return layerKeys[uri], nil
+	})
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+	require.Equal(t, layers[incURI].EndTime, manifests[1].EndTime)
+
+	// Reading every layer with the key of the full backup fails.
+	_, err = resolve(singleBackupEncryption(layerKeys[fullURI]))
+	require.Error(t, err)
+
+	// As does an error from the resolver.
+	_, err = resolve(func(uri string) (*jobspb.BackupEncryptionOptions, error) {
+		return nil, errors.Newf("no key for %s", uri)
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no key for "+fullURI)
+}
+
+func TestResolveBackupManifestsMaxLayers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	const fullURI = "nodelocal://1/full"
+	baseStore, err := externalStorageFromURI(ctx, fullURI, security.RootUserName())
+	require.NoError(t, err)
+	defer baseStore.Close()
+	st := baseStore.Settings()
+
+	require.NoError(t, writeBackupManifest(ctx, st, baseStore, backupManifestName, nil, /* encryption */
+		&BackupManifest{EndTime: makeTestTimestamp(10)}))
+	for i, layer := range []string{"20201225/060000.00", "20201225/070000.00"} {
+		require.NoError(t, writeBackupManifest(ctx, st, baseStore, layer+"/"+backupManifestName,
+			nil /* encryption */, &BackupManifest{StartTime: makeTestTimestamp(int64(i+1) * 10), EndTime: makeTestTimestamp(int64(i+2) * 10)}))
+	}
+	resolve := func(from [][]string) (int, error) {
+		_, manifests, _, _, err := resolveBackupManifests(
+			ctx, []cloud.ExternalStorage{baseStore}, externalStorageFromURI, from,
+			hlc.Timestamp{}, singleBackupEncryption(nil), security.RootUserName(),
+		)
+		return len(manifests), err
+	}
+	explicit := [][]string{{fullURI}, {fullURI + "/20201225/060000.00"}, {fullURI + "/20201225/070000.00"}}
+
+	for _, from := range [][][]string{{{fullURI}}, explicit} {
+		maxBackupLayers.Override(&st.SV, 3)
+		n, err := resolve(from)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		maxBackupLayers.Override(&st.SV, 2)
+		_, err = resolve(from)
+		require.EqualError(t, err, "backup has 3 layers, more than the maximum of 2 which can be resolved")
+		require.Contains(t, errors.FlattenHints(err), "consolidate the chain")
+
+		maxBackupLayers.Override(&st.SV, 0)
+		_, err = resolve(from)
+		require.NoError(t, err)
+	}
+}
+
+func TestValidateDescriptorParents(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	manifests := []BackupManifest{{
+		EndTime: hlc.Timestamp{WallTime: 10},
+		Descriptors: []descpb.Descriptor{
+			{Union: &descpb.Descriptor_Database{
+				Database: &descpb.DatabaseDescriptor{ID: 50, Name: "db"},
+			}},
+			{Union: &descpb.Descriptor_Schema{
+				Schema: &descpb.SchemaDescriptor{ID: 51, Name: "sc", ParentID: 50},
+			}},
+			{Union: &descpb.Descriptor_Type{
+				Type: &descpb.TypeDescriptor{ID: 53, Name: "typ", ParentID: 50, ParentSchemaID: 51},
+			}},
+		},
+	}}
+	descs, _, err := loadSQLDescsFromBackupsAtTime(ctx, manifests, hlc.Timestamp{}, nil /* idRewrite */, nil /* validate */, true /* validateParents */)
+	require.NoError(t, err)
+	require.Len(t, descs, 3)
+	require.NoError(t, ValidateDescriptorParents(descs))
+
+	// The parent of the table, database 1, is not in the backup.
+	manifests[0].Descriptors = append(manifests[0].Descriptors, makeTestTableDesc(52, 1))
+	_, _, err = loadSQLDescsFromBackupsAtTime(ctx, manifests, hlc.Timestamp{}, nil /* idRewrite */, nil /* validate */, false /* validateParents */)
+	require.NoError(t, err)
+	_, _, err = loadSQLDescsFromBackupsAtTime(ctx, manifests, hlc.Timestamp{}, nil /* idRewrite */, nil /* validate */, true /* validateParents */)
+	require.EqualError(t, err, `backup contains 1 descriptors whose parent database is missing: `+
+		`relation "t" (52) in database 1`)
+
+	// Without the database, so are its schema and type.
+	descs, _ = loadSQLDescsFromBackupsAtTimeUnvalidated(ctx, manifests, hlc.Timestamp{})
+	require.EqualError(t, ValidateDescriptorParents(descs[1:]), `backup contains 3 descriptors `+
+		`whose parent database is missing: relation "t" (52) in database 1; `+
+		`schema "sc" (51) in database 50; type "typ" (53) in database 50`)
+}
+
+func TestLoadSQLDescsWithIDRewrite(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	manifests := []BackupManifest{{
+		EndTime: hlc.Timestamp{WallTime: 10},
+		Descriptors: []descpb.Descriptor{
+			{Union: &descpb.Descriptor_Database{
+				Database: &descpb.DatabaseDescriptor{ID: 1, Name: "db"},
+			}},
+			{Union: &descpb.Descriptor_Schema{
+				Schema: &descpb.SchemaDescriptor{ID: 51, Name: "sc", ParentID: 1},
+			}},
+			makeTestTableDesc(52, 1),
+			{Union: &descpb.Descriptor_Type{
+				Type: &descpb.TypeDescriptor{ID: 53, Name: "typ", ParentID: 1, ParentSchemaID: 51},
+			}},
+		},
+	}}
+	idRewrite := map[descpb.ID]descpb.ID{1: 100, 51: 101, 52: 102}
+	descs, _, err := loadSQLDescsFromBackupsAtTime(
+		ctx, manifests, hlc.Timestamp{}, idRewrite, nil /* validate */, true, /* validateParents */
+	)
+	require.NoError(t, err)
+	type ids struct{ id, parentID, parentSchemaID descpb.ID }
+	var got []ids
+	for _, desc := range descs {
+		got = append(got, ids{desc.GetID(), desc.GetParentID(), desc.GetParentSchemaID()})
+	}
+	require.Equal(t, []ids{
+		{100, 0, 0}, {101, 100, 0}, {102, 100, keys.PublicSchemaID}, {53, 100, 101},
+	}, got)
+
+	// The descriptors in the manifest are left as they were.
+	descs, _, err = loadSQLDescsFromBackupsAtTime(
+		ctx, manifests, hlc.Timestamp{}, nil /* idRewrite */, nil /* validate */, true, /* validateParents */
+	)
+	require.NoError(t, err)
+	require.Equal(t, descpb.ID(1), descs[0].GetID())
+	require.Equal(t, descpb.ID(52), descs[2].GetID())
+	require.Equal(t, descpb.ID(1), descs[2].GetParentID())
+}
+
+func TestLoadSQLDescsFromLayer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	db := descpb.Descriptor{Union: &descpb.Descriptor_Database{
+		Database: &descpb.DatabaseDescriptor{ID: 1, Name: "db"},
+	}}
+	t52, t53 := makeTestTableDesc(52, 1), makeTestTableDesc(53, 1)
+	rev := func(wall int64, id descpb.ID, desc *descpb.Descriptor) BackupManifest_DescriptorRevision {
+		return BackupManifest_DescriptorRevision{Time: hlc.Timestamp{WallTime: wall}, ID: id, Desc: desc}
+	}
+	manifests := []BackupManifest{{
+		StartTime:   hlc.Timestamp{},
+		EndTime:     hlc.Timestamp{WallTime: 10},
+		Descriptors: []descpb.Descriptor{db, t52},
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			rev(5, 1, &db), rev(5, 52, &t52),
+		},
+	}, {
+		StartTime:   hlc.Timestamp{WallTime: 10},
+		EndTime:     hlc.Timestamp{WallTime: 20},
+		Descriptors: []descpb.Descriptor{db, t52, t53},
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			rev(10, 1, &db), rev(10, 52, &t52), rev(15, 53, &t53),
+		},
+	}, {
+		StartTime:   hlc.Timestamp{WallTime: 20},
+		EndTime:     hlc.Timestamp{WallTime: 30},
+		Descriptors: []descpb.Descriptor{db, t53},
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			rev(20, 1, &db), rev(20, 52, &t52), rev(20, 53, &t53), rev(25, 52, nil),
+		},
+	}}
+
+	for layer, expected := range [][]descpb.ID{{1, 52}, {1, 52, 53}, {1, 53}} {
+		descs, err := LoadSQLDescsFromLayer(ctx, manifests, layer)
+		require.NoError(t, err)
+		var ids []descpb.ID
+		for _, desc := range descs {
+			ids = append(ids, desc.GetID())
+		}
+		require.Equal(t, expected, ids, "layer %d", layer)
+	}
+
+	for _, layer := range []int{-1, 3} {
+		_, err := LoadSQLDescsFromLayer(ctx, manifests, layer)
+		require.EqualError(t, err, fmt.Sprintf("layer %d out of range: backup has 3 layers", layer))
+	}
+}
+
+func TestEstimateRestoreDuration(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	f := makeTestLocalityFile("a", "b", "", 100<<20)
+	// The same file listed twice is only restored once.
+	dup := f
+	dup.Path = "./" + f.Path
+	full := BackupManifest{
+		Dir: roachpb.ExternalStorage{LocalFile: roachpb.ExternalStorage_LocalFilePath{Path: "/full"}},
+		Files: []BackupManifest_File{
+			f, dup, makeTestLocalityFile("a", "b", "region=east", 50<<20),
+		},
+	}
+	inc := BackupManifest{
+		Dir: roachpb.ExternalStorage{LocalFile: roachpb.ExternalStorage_LocalFilePath{Path: "/inc"}},
+		Files: []BackupManifest_File{
+			makeTestLocalityFile("a", "b", "", 50<<20),
+		},
+	}
+	manifests := []BackupManifest{full, inc}
+	require.Equal(t, uint64(200<<20), backupChainDataSize(manifests))
+
+	require.Equal(t, 4*time.Second, EstimateRestoreDuration(manifests, 0 /* throughputBytesPerSec */))
+	require.Equal(t, 2*time.Second, EstimateRestoreDuration(manifests, 100<<20))
+	require.Equal(t, time.Duration(0), EstimateRestoreDuration(nil, 100<<20))
+}
+
+// truncatingStorage silently drops the last byte of every file written to it.
+type truncatingStorage struct {
+	cloud.ExternalStorage
+}
+
+func (s truncatingStorage) WriteFile(ctx context.Context, basename string, content io.ReadSeeker) error {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		data = data[:len(data)-1]
+	}
+	return s.ExternalStorage.WriteFile(ctx, basename, bytes.NewReader(data))
+}
+
+func TestVerifyManifestWrites(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/verify", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	st := cluster.MakeTestingClusterSettings()
+	m := BackupManifest{Files: []BackupManifest_File{makeTestFile("a", "b")}}
+
+	// Without verification, the corruption goes unnoticed until the manifest is
+	// read.
+	require.NoError(t, writeBackupManifest(ctx, st, truncatingStorage{store}, backupManifestName, nil, &m))
+	_, err = readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.Error(t, err)
+
+	verifyManifestWrites.Override(&st.SV, true)
+	err = writeBackupManifest(ctx, st, truncatingStorage{store}, backupManifestName, nil, &m)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), backupManifestName+" was corrupted when written")
+	require.NoError(t, writeBackupManifest(ctx, st, store, backupManifestName, nil, &m))
+	read, err := readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, m.Files, read.Files)
+}
+
+// unwritableStorage fails every write.
+type unwritableStorage struct {
+	cloud.ExternalStorage
+}
+
+func (s unwritableStorage) WriteFile(_ context.Context, basename string, _ io.ReadSeeker) error {
+	return errors.Newf("cannot write %s", basename)
+}
+
+func TestMultiWriteBackupManifest(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	var stores []cloud.ExternalStorage
+	for _, uri := range []string{"nodelocal://0/primary", "nodelocal://0/mirror1", "nodelocal://0/mirror2"} {
+		store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+		require.NoError(t, err)
+		defer store.Close()
+		stores = append(stores, store)
+	}
+	st := stores[0].Settings()
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: bytes.Repeat([]byte("k"), 32),
+	}
+	m := BackupManifest{EndTime: hlc.Timestamp{WallTime: 10}, Files: []BackupManifest_File{makeTestFile("a", "b")}}
+
+	require.NoError(t, multiWriteBackupManifest(ctx, st, stores, backupManifestName, encryption, &m,
+		manifestWriteOptions{}))
+	var encoded [][]byte
+	for _, store := range stores {
+		read, err := readBackupManifest(ctx, store, backupManifestName, encryption)
+		require.NoError(t, err)
+		require.Equal(t, m.EndTime, read.EndTime)
+		r, err := store.ReadFile(ctx, backupManifestName)
+		require.NoError(t, err)
+		buf, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		encoded = append(encoded, buf)
+	}
+	// The manifest is encrypted once, so every destination has the same bytes.
+	require.Equal(t, encoded[0], encoded[1])
+	require.Equal(t, encoded[0], encoded[2])
+
+	// A failed mirror doesn't prevent the others from being written, and is
+	// reported as such.
+	require.NoError(t, stores[2].Delete(ctx, backupManifestName))
+	err := multiWriteBackupManifest(ctx, st,
+		[]cloud.ExternalStorage{stores[0], unwritableStorage{stores[1]}, stores[2]},
+		backupManifestName, encryption, &m, manifestWriteOptions{})
+	require.True(t, errors.Is(err, errBackupManifestMirror), "%+v", err)
+	require.Contains(t, err.Error(), "mirror 1")
+	_, err = readBackupManifest(ctx, stores[2], backupManifestName, encryption)
+	require.NoError(t, err)
+
+	// A failed primary is not.
+	err = multiWriteBackupManifest(ctx, st,
+		[]cloud.ExternalStorage{unwritableStorage{stores[0]}, stores[1]},
+		backupManifestName, encryption, &m, manifestWriteOptions{})
+	require.Error(t, err)
+	require.False(t, errors.Is(err, errBackupManifestMirror))
+
+	// The mirrors of a manifest compressed with a dictionary get a copy of it.
+	require.NoError(t, writeManifestDictionaryIfNotExists(ctx, st, stores[0], encryption, &m))
+	inc := BackupManifest{
+		StartTime:      m.EndTime,
+		EndTime:        hlc.Timestamp{WallTime: 20},
+		Files:          []BackupManifest_File{makeTestFile("b", "c")},
+		DictionaryPath: backupManifestDictionaryName,
+	}
+	const incName = "inc-" + backupManifestName
+	require.NoError(t, multiWriteBackupManifest(ctx, st, stores, incName, encryption, &inc,
+		manifestWriteOptions{}))
+	for _, store := range stores {
+		read, err := readBackupManifest(ctx, store, incName, encryption)
+		require.NoError(t, err)
+		require.Equal(t, inc.EndTime, read.EndTime)
+	}
+
+	require.Error(t, multiWriteBackupManifest(ctx, st, nil /* stores */, backupManifestName, encryption, &m,
+		manifestWriteOptions{}))
+}
+
+func TestValidateManifestFilePaths(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/dupes", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	sv := &store.Settings().SV
+
+	dupe := makeTestFile("c", "d")
+	dupe.Path = "a-b.sst"
+	m := BackupManifest{
+		EndTime: hlc.Timestamp{WallTime: 10},
+		Files:   []BackupManifest_File{makeTestFile("a", "b"), makeTestFile("b", "c"), dupe},
+	}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, nil, &m))
+
+	// Duplicates are only detected when validation is enabled.
+	read, err := readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.NoError(t, err)
+	require.Len(t, read.Files, 3)
+
+	manifestValidationEnabled.Override(sv, true)
+	_, err = readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.EqualError(t, err, `invalid backup manifest: 1 duplicate file entries: `+
+		`a-b.sst (spans {a-b} and {c-d})`)
+
+	manifestDedupeFilesEnabled.Override(sv, true)
+	read, err = readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a-b.sst", "b-c.sst"}, []string{read.Files[0].Path, read.Files[1].Path})
+	require.Equal(t, roachpb.Key("b"), read.Files[0].Span.EndKey)
+}
+
+func TestLoadMatchingSQLDescsFromBackupsAtTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	systemDB := descpb.Descriptor{Union: &descpb.Descriptor_Database{
+		Database: &descpb.DatabaseDescriptor{ID: keys.SystemDatabaseID, Name: "system"},
+	}}
+	makeSettingsDesc := func(version descpb.DescriptorVersion) descpb.Descriptor {
+		return descpb.Descriptor{Union: &descpb.Descriptor_Table{Table: &descpb.TableDescriptor{
+			ID: keys.SettingsTableID, Name: "settings", ParentID: keys.SystemDatabaseID, Version: version,
+		}}}
+	}
+	settingsV1, settingsV2 := makeSettingsDesc(1), makeSettingsDesc(2)
+	userTable := makeTestTableDesc(52, 1)
+	manifests := []BackupManifest{{
+		EndTime:     makeTestTimestamp(30),
+		MVCCFilter:  MVCCFilter_All,
+		Descriptors: []descpb.Descriptor{systemDB, settingsV2, userTable},
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			{Time: makeTestTimestamp(5), ID: keys.SystemDatabaseID, Desc: &systemDB},
+			{Time: makeTestTimestamp(5), ID: 52, Desc: &userTable},
+			{Time: makeTestTimestamp(10), ID: keys.SettingsTableID, Desc: &settingsV1},
+			{Time: makeTestTimestamp(20), ID: keys.SettingsTableID, Desc: &settingsV2},
+		},
+	}}
+	isSystemSettings := func(d DescSummary) bool {
+		return d.Kind == "relation" && d.ParentID == keys.SystemDatabaseID && d.Name == "settings"
+	}
+
+	for _, tc := range []struct {
+		asOf    hlc.Timestamp
+		version descpb.DescriptorVersion
+	}{
+		{asOf: hlc.Timestamp{}, version: 2},
+		{asOf: makeTestTimestamp(15), version: 1},
+		{asOf: makeTestTimestamp(25), version: 2},
+	} {
+		descs, _ := LoadMatchingSQLDescsFromBackupsAtTime(ctx, manifests, tc.asOf, isSystemSettings)
+		require.Len(t, descs, 1)
+		require.Equal(t, descpb.ID(keys.SettingsTableID), descs[0].GetID())
+		require.Equal(t, tc.version, descs[0].GetVersion())
+	}
+
+	// Nothing matches before the table was created.
+	descs, _ := LoadMatchingSQLDescsFromBackupsAtTime(ctx, manifests, makeTestTimestamp(7), isSystemSettings)
+	require.Empty(t, descs)
+	// Without a predicate, everything is returned.
+	descs, _ = LoadMatchingSQLDescsFromBackupsAtTime(ctx, manifests, hlc.Timestamp{}, nil /* match */)
+	require.Len(t, descs, 3)
+}
+
+func TestForEachDescriptorAtTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	db := descpb.Descriptor{Union: &descpb.Descriptor_Database{
+		Database: &descpb.DatabaseDescriptor{ID: 1, Name: "db"},
+	}}
+	t1, t2, orphan := makeTestTableDesc(52, 1), makeTestTableDesc(53, 1), makeTestTableDesc(54, 1)
+	orphan.GetTable().ParentID = 2
+	manifests := []BackupManifest{{
+		EndTime:     makeTestTimestamp(30),
+		MVCCFilter:  MVCCFilter_All,
+		Descriptors: []descpb.Descriptor{db, t1},
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			{Time: makeTestTimestamp(5), ID: 1, Desc: &db},
+			{Time: makeTestTimestamp(5), ID: 53, Desc: &t2},
+			{Time: makeTestTimestamp(5), ID: 54, Desc: &orphan},
+			{Time: makeTestTimestamp(10), ID: 52, Desc: &t1},
+			{Time: makeTestTimestamp(20), ID: 53},
+		},
+	}}
+
+	collect := func(asOf hlc.Timestamp) []descpb.ID {
+		var ids []descpb.ID
+		require.NoError(t, ForEachDescriptorAtTime(ctx, manifests, asOf, func(desc catalog.Descriptor) error {
+			ids = append(ids, desc.GetID())
+			return nil
+		}))
+		return ids
+	}
+	require.Equal(t, []descpb.ID{1, 52}, collect(hlc.Timestamp{}))
+	require.Equal(t, []descpb.ID{1, 53}, collect(makeTestTimestamp(7)))
+	require.Equal(t, []descpb.ID{1, 52, 53}, collect(makeTestTimestamp(15)))
+	require.Equal(t, []descpb.ID{1, 52}, collect(makeTestTimestamp(25)))
+
+	// The descriptors are the same as those loaded all at once.
+	for _, asOf := range []hlc.Timestamp{{}, makeTestTimestamp(7), makeTestTimestamp(15), makeTestTimestamp(25)} {
+		descs, _ := loadSQLDescsFromBackupsAtTimeUnvalidated(ctx, manifests, asOf)
+		require.Len(t, descs, len(collect(asOf)))
+	}
+
+	// Iteration stops at the first error, which is returned unless it is
+	// iterutil.StopIteration.
+	var calls int
+	require.NoError(t, ForEachDescriptorAtTime(ctx, manifests, makeTestTimestamp(15), func(catalog.Descriptor) error {
+		calls++
+		return iterutil.StopIteration()
+	}))
+	require.Equal(t, 1, calls)
+	require.EqualError(t, ForEachDescriptorAtTime(ctx, manifests, makeTestTimestamp(15), func(catalog.Descriptor) error {
+		return errors.New("boom")
+	}), "boom")
+}
+
+func TestDecodeDictionaryCompressedHeaderMalformed(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	header := func(n uint64, rest string) []byte {
+		var scratch [binary.MaxVarintLen64]byte
+		buf := append([]byte(nil), dictionaryCompressionPrefix...)
+		buf = append(buf, scratch[:binary.PutUvarint(scratch[:], n)]...)
+		return append(buf, rest...)
+	}
+	for _, data := range [][]byte{
+		dictionaryCompressionPrefix,
+		header(4, "dict"),
+		header(5, "dict1234"),
+		// Lengths which overflow when the checksum's length is added to them.
+		header(math.MaxUint64, "dict"),
+		header(math.MaxUint64-3, "dict1234"),
+	} {
+		_, _, _, err := decodeDictionaryCompressedHeader(data)
+		require.EqualError(t, err, "malformed dictionary compression header")
+	}
+	path, _, rest, err := decodeDictionaryCompressedHeader(header(4, "dict1234data"))
+	require.NoError(t, err)
+	require.Equal(t, "dict", path)
+	require.Equal(t, []byte("data"), rest)
+
+	// Decompression with a dictionary is bounded too.
+	dict := []byte("dictionary")
+	compressed, err := compressDataWithDictionary(bytes.Repeat([]byte("a"), 1<<20), dict, "dict")
+	require.NoError(t, err)
+	_, err = decompressDataWithDictionary(compressed, dict, 1<<20)
+	require.NoError(t, err)
+	_, err = decompressDataWithDictionary(compressed, dict, 1<<20-1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decompresses to more than the maximum")
+}
+
+func TestListInProgressBackups(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/collection", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	st := store.Settings()
+
+	full := BackupManifest{
+		EndTime:     makeTestTimestamp(10),
+		Spans:       []roachpb.Span{{Key: roachpb.Key("a"), EndKey: roachpb.Key("z")}},
+		Files:       []BackupManifest_File{makeTestFile("a", "b"), makeTestFile("b", "c")},
+		EntryCounts: RowCount{DataSize: 100, Rows: 10},
+	}
+	inc := BackupManifest{StartTime: makeTestTimestamp(10), EndTime: makeTestTimestamp(20), Spans: full.Spans}
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: bytes.Repeat([]byte("k"), 32),
+	}
+	for _, c := range []struct {
+		path       string
+		manifest   BackupManifest
+		encryption *jobspb.BackupEncryptionOptions
+	}{
+		{path: backupManifestCheckpointName, manifest: full},
+		{path: "20201214/120000.00/" + backupManifestCheckpointName, manifest: inc},
+		{path: "2020/12/14-120000.00/" + tempCheckpointFileNameForJob(123), manifest: full},
+		{path: "2020/12/15-120000.00/" + backupManifestCheckpointName, manifest: full, encryption: encryption},
+		// A completed backup has no checkpoint.
+		{path: "2020/12/13-120000.00/" + backupManifestName, manifest: full},
+	} {
+		m := c.manifest
+		require.NoError(t, writeBackupManifest(ctx, st, store, c.path, c.encryption, &m))
+	}
+
+	infos, err := ListInProgressBackups(ctx, store, nil /* encryption */)
+	require.NoError(t, err)
+	require.Len(t, infos, 4)
+	require.Equal(t, CheckpointInfo{
+		Path: "2020/12/14-120000.00/" + backupManifestCheckpointName + "-123", JobID: 123,
+		EndTime: makeTestTimestamp(10), Spans: 1, Files: 2, EntryCounts: full.EntryCounts,
+	}, infos[0])
+	require.Equal(t, "2020/12/15-120000.00/"+backupManifestCheckpointName, infos[1].Path)
+	require.Error(t, infos[1].Err)
+	require.Equal(t, CheckpointInfo{
+		Path: "20201214/120000.00/" + backupManifestCheckpointName, StartTime: makeTestTimestamp(10), EndTime: makeTestTimestamp(20), Spans: 1,
+	}, infos[2])
+	require.Equal(t, CheckpointInfo{
+		Path: backupManifestCheckpointName, EndTime: makeTestTimestamp(10), Spans: 1, Files: 2, EntryCounts: full.EntryCounts,
+	}, infos[3])
+}
+
+func TestManifestReadStats(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/stats", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: bytes.Repeat([]byte("k"), 32),
+	}
+	m := BackupManifest{EndTime: hlc.Timestamp{WallTime: 10}, Files: []BackupManifest_File{makeTestFile("a", "b")}}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, "plain", nil, &m))
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, "encrypted", encryption, &m))
+	var size int64
+	for _, name := range []string{"plain", "encrypted"} {
+		n, err := store.Size(ctx, name)
+		require.NoError(t, err)
+		size += n
+	}
+
+	// Reads aren't timed without stats in their options.
+	_, err = readBackupManifest(ctx, store, "plain", nil /* encryption */)
+	require.NoError(t, err)
+
+	var stats manifestReadStats
+	opts := manifestReadOptions{stats: &stats}
+	_, err = readBackupManifestWithOptions(ctx, store, "plain", nil /* encryption */, opts)
+	require.NoError(t, err)
+	_, err = readBackupManifestWithOptions(ctx, store, "encrypted", encryption, opts)
+	require.NoError(t, err)
+	// Failed reads aren't recorded.
+	_, err = readBackupManifestWithOptions(ctx, store, "missing", nil /* encryption */, opts)
+	require.Error(t, err)
+
+	require.Equal(t, 2, stats.mu.manifests)
+	require.Equal(t, size, stats.mu.bytes)
+	require.Regexp(t, `^2 manifests \(.*\): fetch .*, decrypt .*, decompress .*, unmarshal .*$`, stats.String())
+}
+
+func init() {
+	cloud.RegisterKMSFromURIFactory(makeBlockingKMS, "blockingkms")
+}
+
+var blockingKMSState struct {
+	closed int32
+}
+
+// blockingKMS is a KMS which doesn't respond to Decrypt until its context is
+// done, standing in for an unresponsive KMS endpoint.
+type blockingKMS struct {
+	cloud.KMS
+}
+
+func makeBlockingKMS(string, cloud.KMSEnv) (cloud.KMS, error) {
+	return &blockingKMS{}, nil
+}
+
+func (k *blockingKMS) Decrypt(ctx context.Context, _ []byte) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (k *blockingKMS) Close() error {
+	atomic.AddInt32(&blockingKMSState.closed, 1)
+	return nil
+}
+
+func TestGetEncryptionKeyKMSTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	kmsTimeout.Override(&st.SV, 10*time.Millisecond)
+	getKey := func(ctx context.Context, uri string) error {
+		_, err := getEncryptionKey(ctx, &jobspb.BackupEncryptionOptions{
+			Mode:    jobspb.EncryptionMode_KMS,
+			KMSInfo: &jobspb.BackupEncryptionOptions_KMSInfo{Uri: uri, EncryptedDataKey: []byte("key")},
+		}, st, base.ExternalIODirConfig{}, nil /* dataKeys */)
+		return err
+	}
+	waitForClose := func(expected int32) {
+		testutils.SucceedsSoon(t, func() error {
+			if closed := atomic.LoadInt32(&blockingKMSState.closed); closed != expected {
+				return errors.Errorf("%d KMSes closed, expected %d", closed, expected)
+			}
+			return nil
+		})
+	}
+
+	// A KMS which doesn't decrypt the key in time is given up on, and closed.
+	err := getKey(ctx, "blockingkms://decrypt/key")
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%+v", err)
+	require.True(t, errors.HasType(err, (*contextutil.TimeoutError)(nil)), "%+v", err)
+	require.Contains(t, err.Error(), "timed out after 10ms")
+	waitForClose(1)
+
+	// Canceling the caller's context isn't reported as a timeout.
+	kmsTimeout.Override(&st.SV, time.Hour)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = getKey(cancelCtx, "blockingkms://decrypt/key")
+	require.True(t, errors.Is(err, context.Canceled), "%+v", err)
+	require.False(t, errors.HasType(err, (*contextutil.TimeoutError)(nil)), "%+v", err)
+	waitForClose(2)
+}
+
+func TestIncrementalLayerURIs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const subDir = "20201225/060000.00"
+	for _, tc := range []struct {
+		base     string
+		expected string
+	}{
+		{"nodelocal://1/backup", "nodelocal://1/backup/20201225/060000.00"},
+		{"nodelocal://1/backup/", "nodelocal://1/backup/20201225/060000.00"},
+		{"s3://bucket?AUTH=implicit", "s3://bucket/20201225/060000.00?AUTH=implicit"},
+		{
+			"s3://bucket/backup?AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=a%2Fb&AWS_SESSION_TOKEN=tok",
+			"s3://bucket/backup/20201225/060000.00?AWS_ACCESS_KEY_ID=id&AWS_SECRET_ACCESS_KEY=a%2Fb&AWS_SESSION_TOKEN=tok",
+		},
+		{
+			"https://host/signed/backup?sig=abc%3D&expires=123",
+			"https://host/signed/backup/20201225/060000.00?sig=abc%3D&expires=123",
+		},
+		// An escaped slash addresses an object whose name contains a slash, so it
+		// must not be unescaped.
+		{"gs://bucket/a%2Fb?AUTH=implicit", "gs://bucket/a%2Fb/20201225/060000.00?AUTH=implicit"},
+	} {
+		base, err := url.Parse(tc.base)
+		require.NoError(t, err)
+		require.Equal(t, []string{tc.expected}, incrementalLayerURIs([]*url.URL{base}, subDir), tc.base)
+		// The base URI is left alone.
+		require.Equal(t, tc.base, base.String())
+	}
+
+	// Each partition keeps its own location and parameters.
+	var bases []*url.URL
+	for _, uri := range []string{
+		"nodelocal://1/backup?COCKROACH_LOCALITY=default",
+		"s3://bucket/east?COCKROACH_LOCALITY=region%3Deast&AUTH=implicit",
+	} {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		bases = append(bases, u)
+	}
+	require.Equal(t, []string{
+		"nodelocal://1/backup/20201225/060000.00?COCKROACH_LOCALITY=default",
+		"s3://bucket/east/20201225/060000.00?COCKROACH_LOCALITY=region%3Deast&AUTH=implicit",
+	}, incrementalLayerURIs(bases, subDir))
+}
+
+func TestUnmarshalBackupManifestSkippingFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	m := BackupManifest{
+		StartTime:   makeTestTimestamp(1),
+		EndTime:     makeTestTimestamp(10),
+		Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1), makeTestTableDesc(53, 2)},
+		DescriptorChanges: []BackupManifest_DescriptorRevision{
+			{ID: 52, Time: makeTestTimestamp(5), Desc: &descpb.Descriptor{}},
+		},
+		// Files come before and after other fields of the encoded manifest.
+		Files:         []BackupManifest_File{makeTestFile("a", "b"), makeTestFile("b", "c")},
+		Spans:         []roachpb.Span{{Key: roachpb.Key("a"), EndKey: roachpb.Key("c")}},
+		CompleteDbs:   []descpb.ID{50},
+		ID:            uuid.MakeV4(),
+		LocalityKVs:   []string{"region=east"},
+		EntryCounts:   RowCount{DataSize: 100},
+		FormatVersion: BackupFormatDescriptorTrackingVersion,
+	}
+	data, err := protoutil.Marshal(&m)
+	require.NoError(t, err)
+
+	var full, partial BackupManifest
+	require.NoError(t, protoutil.Unmarshal(data, &full))
+	require.NoError(t, unmarshalBackupManifestSkippingFiles(data, &partial))
+	require.Len(t, full.Files, 2)
+	require.Empty(t, partial.Files)
+	require.Equal(t, full.Descriptors, partial.Descriptors)
+	require.Equal(t, full.DescriptorChanges, partial.DescriptorChanges)
+	full.Files = nil
+	require.Equal(t, full, partial)
+
+	// A manifest without files decodes the same either way.
+	data, err = protoutil.Marshal(&full)
+	require.NoError(t, err)
+	partial = BackupManifest{}
+	require.NoError(t, unmarshalBackupManifestSkippingFiles(data, &partial))
+	require.Equal(t, full, partial)
+
+	// Truncated manifests are rejected rather than partially decoded.
+	require.Error(t, unmarshalBackupManifestSkippingFiles(data[:len(data)-1], &BackupManifest{}))
+
+	// readBackupManifestWithOptions skips the files with skipFiles set.
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/skip", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, nil, &m))
+	read, err := readBackupManifestWithOptions(ctx, store, backupManifestName, nil, /* encryption */
+		manifestReadOptions{skipFiles: true})
+	require.NoError(t, err)
+	require.Empty(t, read.Files)
+	require.Equal(t, len(m.Descriptors), len(read.Descriptors))
+	read, err = readBackupManifest(ctx, store, backupManifestName, nil /* encryption */)
+	require.NoError(t, err)
+	require.Len(t, read.Files, 2)
+}
+
+func TestReadBackupManifestRaw(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	const uri = "nodelocal://0/raw"
+	store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+	// A table descriptor as written by versions prior to 19.1.
+	m := BackupManifest{
+		EndTime:     hlc.Timestamp{WallTime: 10},
+		Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1)},
+	}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, nil, &m))
+
+	raw, err := ReadBackupManifestRaw(ctx, uri, security.RootUserName(), externalStorageFromURI, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, m.Descriptors, raw.Descriptors)
+	// nolint:descriptormarshal
+	require.True(t, raw.Descriptors[0].GetTable().ModificationTime.IsEmpty())
+
+	read, err := ReadBackupManifestFromURI(ctx, uri, security.RootUserName(), externalStorageFromURI, nil /* encryption */)
+	require.NoError(t, err)
+	// nolint:descriptormarshal
+	require.Equal(t, hlc.Timestamp{WallTime: 1}, read.Descriptors[0].GetTable().ModificationTime)
+}
+
+func TestBackupLocalitySizes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	uris := []string{"nodelocal://0/sizes/default", "nodelocal://0/sizes/east", "nodelocal://0/sizes/west"}
+	var stores []cloud.ExternalStorage
+	for _, uri := range uris {
+		store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+		require.NoError(t, err)
+		defer store.Close()
+		stores = append(stores, store)
+	}
+
+	m := BackupManifest{
+		ID: uuid.MakeV4(),
+		Files: []BackupManifest_File{
+			makeTestLocalityFile("a", "b", "", 5),
+			makeTestLocalityFile("b", "c", "region=east", 10),
+			makeTestLocalityFile("c", "d", "region=east", 20),
+			makeTestLocalityFile("d", "e", "region=west", 40),
+		},
+	}
+	for i, locality := range []string{"region=east", "region=west"} {
+		desc := BackupPartitionDescriptor{LocalityKV: locality, BackupID: m.ID}
+		for _, f := range m.Files {
+			if f.LocalityKV == locality {
+				desc.Files = append(desc.Files, f)
+			}
+		}
+		filename := fmt.Sprintf("%s_%d_%s", backupPartitionDescriptorPrefix, i, sanitizeLocalityKV(locality))
+		require.NoError(t, writeBackupPartitionDescriptor(ctx, stores[i+1], filename, nil, &desc))
+		m.PartitionDescriptorFilenames = append(m.PartitionDescriptorFilenames, filename)
+	}
+
+	sizes, err := BackupLocalitySizes(ctx, stores, uris, m, nil /* encryption */, "" /* prefix */)
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint64{
+		defaultLocalityValue: 5,
+		"region=east":        30,
+		"region=west":        40,
+	}, sizes)
+
+	// The locality info is unchanged.
+	info, err := getLocalityInfo(ctx, stores, uris, m, nil /* encryption */, "" /* prefix */)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"region=east": uris[1], "region=west": uris[2]},
+		info.URIsByOriginalLocalityKV)
+}
+
+func TestGetLocalityInfoStrictPartitionDescriptors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	uris := []string{"nodelocal://0/strict/default", "nodelocal://0/strict/east", "nodelocal://0/strict/west"}
+	var stores []cloud.ExternalStorage
+	for _, uri := range uris {
+		store, err := externalStorageFromURI(ctx, uri, security.RootUserName())
+		require.NoError(t, err)
+		defer store.Close()
+		stores = append(stores, store)
+	}
+
+	m := BackupManifest{ID: uuid.MakeV4()}
+	var filenames []string
+	for i, locality := range []string{"region=east", "region=west"} {
+		desc := BackupPartitionDescriptor{LocalityKV: locality, BackupID: m.ID}
+		filename := fmt.Sprintf("%s_%d_%s", backupPartitionDescriptorPrefix, i, sanitizeLocalityKV(locality))
+		require.NoError(t, writeBackupPartitionDescriptor(ctx, stores[i+1], filename, nil, &desc))
+		filenames = append(filenames, filename)
+	}
+	// The manifest only lists the first partition.
+	m.PartitionDescriptorFilenames = filenames[:1]
+
+	// The unlisted partition goes unnoticed unless the check is enabled.
+	info, err := getLocalityInfo(ctx, stores, uris, m, nil /* encryption */, "" /* prefix */)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"region=east": uris[1]}, info.URIsByOriginalLocalityKV)
+
+	st := stores[0].Settings()
+	strictPartitionDescriptorsEnabled.Override(&st.SV, true)
+	_, err = getLocalityInfo(ctx, stores, uris, m, nil /* encryption */, "" /* prefix */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "nodelocal://0/strict/west")
+	require.Contains(t, err.Error(), filenames[1])
+
+	m.PartitionDescriptorFilenames = filenames
+	info, err = getLocalityInfo(ctx, stores, uris, m, nil /* encryption */, "" /* prefix */)
+	require.NoError(t, err)
+	require.Len(t, info.URIsByOriginalLocalityKV, 2)
+
+	// Stores which can't be listed can't be checked.
+	stores[2] = unlistableStorage{stores[2]}
+	_, err = getLocalityInfo(ctx, stores, uris, m, nil /* encryption */, "" /* prefix */)
+	require.True(t, errors.Is(err, cloudimpl.ErrListingUnsupported), "%+v", err)
+}
+
+func TestResolveTableStatistics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	var stores []cloud.ExternalStorage
+	for i := 0; i < 3; i++ {
+		store, err := externalStorageFromURI(ctx, fmt.Sprintf("nodelocal://0/stats/%d", i), security.RootUserName())
+		require.NoError(t, err)
+		defer store.Close()
+		stores = append(stores, store)
+	}
+	stat := func(name string) *stats.TableStatisticProto {
+		return &stats.TableStatisticProto{TableID: 52, Name: name}
+	}
+	withStats := BackupManifest{StatisticsFilenames: map[descpb.ID]string{52: backupStatisticsFileName}}
+
+	// No layer has statistics.
+	manifests := []BackupManifest{{}, {}, {}}
+	resolved, err := ResolveTableStatistics(ctx, stores, manifests, nil /* encryption */)
+	require.NoError(t, err)
+	require.Empty(t, resolved.Statistics)
+
+	// The full backup has statistics, the first incremental layer has none and
+	// the last one lists a statistics file that is missing.
+	require.NoError(t, writeTableStatistics(ctx, stores[0], backupStatisticsFileName, nil,
+		&StatsTable{Statistics: []*stats.TableStatisticProto{stat("full")}}))
+	manifests = []BackupManifest{withStats, {}, withStats}
+	resolved, err = ResolveTableStatistics(ctx, stores, manifests, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, []*stats.TableStatisticProto{stat("full")}, resolved.Statistics)
+
+	// Once the last layer's statistics are written, they are the freshest.
+	require.NoError(t, writeTableStatistics(ctx, stores[2], backupStatisticsFileName, nil,
+		&StatsTable{
+			Statistics:    []*stats.TableStatisticProto{stat("inc")},
+			TableVersions: map[descpb.ID]descpb.DescriptorVersion{52: 3},
+		}))
+	resolved, err = ResolveTableStatistics(ctx, stores, manifests, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, []*stats.TableStatisticProto{stat("inc")}, resolved.Statistics)
+	require.Equal(t, map[descpb.ID]descpb.DescriptorVersion{52: 3}, resolved.TableVersions)
+
+	_, err = ResolveTableStatistics(ctx, stores[:2], manifests, nil /* encryption */)
+	require.Error(t, err)
+}
+
+// encryptionInfoErrStorage fails every read of the encryption info of a backup.
+type encryptionInfoErrStorage struct {
+	cloud.ExternalStorage
+}
+
+func (s encryptionInfoErrStorage) ReadFile(
+	ctx context.Context, basename string,
+) (io.ReadCloser, error) {
+	if basename == backupEncryptionInfoFile {
+		return nil, errors.Newf("cannot read %s", basename)
+	}
+	return s.ExternalStorage.ReadFile(ctx, basename)
+}
+
+func TestReadEncryptedBackupManifestWithoutEncryption(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/encrypted", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+
+	salt, err := storageccl.GenerateSalt()
+	require.NoError(t, err)
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("abcdefg"), salt),
+	}
+	m := BackupManifest{EndTime: hlc.Timestamp{WallTime: 10}}
+	require.NoError(t, writeBackupManifest(ctx, store.Settings(), store, backupManifestName, encryption, &m))
+
+	// Without the encryption info, e.g. in an incremental layer, the encryption
+	// is only detected once the manifest has been read.
+	_, err = readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "file appears encrypted")
+
+	require.NoError(t, writeEncryptionInfoIfNotExists(ctx, &jobspb.EncryptionInfo{Salt: salt}, store))
+	_, err = readBackupManifestFromStore(ctx, store, nil /* encryption */)
+	require.EqualError(t, err,
+		`this backup is encrypted; specify one of "encryption_passphrase" or "kms" to decrypt it`)
+	_, err = readBackupManifestFromStore(ctx, store, encryption)
+	require.NoError(t, err)
+
+	// Failures to read the encryption info aren't mistaken for its absence.
+	_, err = readBackupManifestFromStore(ctx, encryptionInfoErrStorage{store}, nil /* encryption */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot read "+backupEncryptionInfoFile)
+
+	// The encryption info is only looked for when the manifest can't be read.
+	plainStore, err := externalStorageFromURI(ctx, "nodelocal://0/plain", security.RootUserName())
+	require.NoError(t, err)
+	defer plainStore.Close()
+	require.NoError(t, writeBackupManifest(ctx, plainStore.Settings(), plainStore, backupManifestName,
+		nil /* encryption */, &m))
+	_, err = readBackupManifestFromStore(ctx, encryptionInfoErrStorage{plainStore}, nil /* encryption */)
+	require.NoError(t, err)
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// RebuildManifestFromPartitions reconstructs, on a best-effort basis, the main
+// manifest of a partitioned backup which was lost, from the partition
+// descriptors of the backup found in stores, the first of which must be the
+// backup's default locality. This is a recovery tool: the rebuilt manifest
+// should be inspected before it is used, and it can't stand in for the lost
+// one entirely.
+//
+// What can be recovered is the ID of the backup, its localities and their
+// partition descriptors, the files written to each of them and the spans and
+// data size they cover. The end time of the backup is taken to be the newest
+// timestamp of the keys in its files, which requires reading all of them; a
+// restore as of that time restores the same data as one as of the original end
+// time.
+//
+// What can't be recovered is everything that was only recorded in the main
+// manifest: the descriptors of the backed up objects and their revisions
+// (Descriptors, DescriptorChanges, CompleteDbs), the spans which were backed up
+// but had no data, the statistics, tenants and cluster version, the start time
+// of an incremental backup, which is left unset, and the files of the default
+// locality, which were only listed in the main manifest. The number of data
+// files in the default locality which aren't listed by any partition is logged.
+func RebuildManifestFromPartitions(
+	ctx context.Context, stores []cloud.ExternalStorage, encryption *jobspb.BackupEncryptionOptions,
+) (BackupManifest, error) {
+	if len(stores) == 0 {
+		return BackupManifest{}, errors.New("no backup locations to rebuild the manifest from")
+	}
+
+	var m BackupManifest
+	seenLocalities := make(map[string]struct{})
+	// storeForFile is the index of the store holding each of m.Files.
+	var storeForFile []int
+	for i, store := range stores {
+		filenames, err := store.ListFiles(ctx, backupPartitionDescriptorPrefix+"_*")
+		if err != nil {
+			return BackupManifest{}, errors.Wrap(err, "listing backup partition descriptors")
+		}
+		sort.Strings(filenames)
+		for _, filename := range filenames {
+			desc, err := readBackupPartitionDescriptor(ctx, store, filename, encryption)
+			if err != nil {
+				return BackupManifest{}, errors.Wrapf(err, "reading backup partition descriptor %s", filename)
+			}
+			if len(m.PartitionDescriptorFilenames) == 0 {
+				m.ID = desc.BackupID
+			} else if desc.BackupID != m.ID {
+				return BackupManifest{}, errors.Errorf(
+					"backup partition descriptor %s belongs to backup %s, expected %s",
+					filename, desc.BackupID, m.ID)
+			}
+			if _, ok := seenLocalities[desc.LocalityKV]; ok {
+				return BackupManifest{}, errors.Errorf("duplicate locality %s found in backup", desc.LocalityKV)
+			}
+			seenLocalities[desc.LocalityKV] = struct{}{}
+			m.LocalityKVs = append(m.LocalityKVs, desc.LocalityKV)
+			m.PartitionDescriptorFilenames = append(m.PartitionDescriptorFilenames, filename)
+			for _, f := range desc.Files {
+				m.Files = append(m.Files, f)
+				storeForFile = append(storeForFile, i)
+			}
+		}
+	}
+	if len(m.PartitionDescriptorFilenames) == 0 {
+		return BackupManifest{}, errors.New("no backup partition descriptors found")
+	}
+
+	listed := make(map[string]struct{}, len(m.Files))
+	spans := make([]roachpb.Span, 0, len(m.Files))
+	for i := range m.Files {
+		f := &m.Files[i]
+		if storeForFile[i] == 0 {
+			listed[f.Path] = struct{}{}
+		}
+		spans = append(spans, f.Span)
+		m.EntryCounts.DataSize += f.EntryCounts.DataSize
+		end, err := newestKeyInBackupFile(ctx, stores[storeForFile[i]], f, encryption)
+		if err != nil {
+			return BackupManifest{}, errors.Wrapf(err, "reading backup file %s", f.Path)
+		}
+		m.EndTime.Forward(end)
+	}
+	m.Spans, _ = roachpb.MergeSpans(spans)
+	sort.Sort(BackupFileDescriptors(m.Files))
+	m.FormatVersion = BackupFormatDescriptorTrackingVersion
+	m.Dir = stores[0].Conf()
+
+	if dataFiles, err := stores[0].ListFiles(ctx, "*.sst"); err != nil {
+		log.Warningf(ctx, "unable to list the data files of the default locality: %v", err)
+	} else {
+		var unlisted int
+		for _, path := range dataFiles {
+			if _, ok := listed[path]; !ok {
+				unlisted++
+			}
+		}
+		if unlisted > 0 {
+			log.Warningf(ctx, "%d data files of the default locality aren't listed by any partition "+
+				"and are missing from the rebuilt manifest", unlisted)
+		}
+	}
+	return m, nil
+}
+
+// newestKeyInBackupFile returns the newest timestamp of the keys in the backup
+// file f, which is in store unless it's inline.
+func newestKeyInBackupFile(
+	ctx context.Context,
+	store cloud.ExternalStorage,
+	f *BackupManifest_File,
+	encryption *jobspb.BackupEncryptionOptions,
+) (hlc.Timestamp, error) {
+	data := append([]byte(nil), f.InlineData...)
+	if len(data) == 0 {
+		r, err := store.ReadFile(ctx, f.Path)
+		if err != nil {
+			return hlc.Timestamp{}, err
+		}
+		defer r.Close()
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return hlc.Timestamp{}, err
+		}
+	}
+	var fileEncryption *roachpb.FileEncryptionOptions
+	if encryption != nil {
+		key, err := getEncryptionKey(ctx, encryption, store.Settings(), store.ExternalIOConf(),
+			nil /* dataKeys */)
+		if err != nil {
+			return hlc.Timestamp{}, err
+		}
+		fileEncryption = &roachpb.FileEncryptionOptions{Key: key}
+	}
+	data, err := decodeBackupFile(data, roachpb.ImportRequest_File{
+		Path:        f.Path,
+		Sha512:      f.Sha512,
+		Compression: f.Compression,
+	}, fileEncryption)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+
+	// The sstables only contain MVCC data and no intents, so using an MVCC
+	// iterator is sufficient.
+	iter, err := storage.NewMemSSTIterator(data, false)
+	if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	defer iter.Close()
+	var newest hlc.Timestamp
+	for iter.SeekGE(storage.MVCCKey{Key: roachpb.KeyMin}); ; iter.Next() {
+		if ok, err := iter.Valid(); err != nil {
+			return hlc.Timestamp{}, err
+		} else if !ok {
+			break
+		}
+		newest.Forward(iter.UnsafeKey().Timestamp)
+	}
+	return newest, nil
+}
+
+// FindOrphanedBackupFiles returns the data files in store which none of the
+// manifests of the backup in it reference, such as those left behind by failed
+// backups, for a cleanup tool to remove. manifests must be the layers of the
+// backup as resolved when restoring it from store: the full backup, whose files
+// are in store, followed by each of the layers appended to it, whose files are
+// in their subdirectories. Only store is checked, so the files of the backup's
+// other localities aren't.
+//
+// The files written by a backup which is still running aren't referenced by
+// any manifest until it completes, so they are reported too.
+func FindOrphanedBackupFiles(
+	ctx context.Context, store cloud.ExternalStorage, manifests []BackupManifest,
+) ([]string, error) {
+	if len(manifests) == 0 {
+		return nil, errors.New("no manifests to check the backup files against")
+	}
+	layers, err := findPriorBackupLocations(ctx, store)
+	if err != nil {
+		if errors.Is(err, cloudimpl.ErrListingUnsupported) {
+			return nil, errors.Wrapf(err, "cannot find orphaned files in storage sink %T", store)
+		}
+		return nil, err
+	}
+	if len(layers)+1 != len(manifests) {
+		return nil, errors.Newf("backup has %d layers, but %d manifests were given",
+			len(layers)+1, len(manifests))
+	}
+
+	referenced := make(map[string]struct{})
+	for i := range manifests {
+		var dir string
+		if i > 0 {
+			dir = layers[i-1]
+		}
+		for j := range manifests[i].Files {
+			referenced[path.Join(dir, manifests[i].Files[j].Path)] = struct{}{}
+		}
+	}
+	var orphans []string
+	for _, pattern := range []string{"*.sst", incBackupSubdirGlob + "*.sst"} {
+		files, err := store.ListFiles(ctx, pattern)
+		if err != nil {
+			if errors.Is(err, cloudimpl.ErrListingUnsupported) {
+				return nil, errors.Wrapf(err, "cannot find orphaned files in storage sink %T", store)
+			}
+			return nil, errors.Wrap(err, "listing backup data files")
+		}
+		for _, f := range files {
+			if _, ok := referenced[path.Clean(f)]; !ok {
+				orphans = append(orphans, f)
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
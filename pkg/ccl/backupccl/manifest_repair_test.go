@@ -0,0 +1,187 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildManifestFromPartitions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+
+	salt, err := storageccl.GenerateSalt()
+	require.NoError(t, err)
+	for _, encryption := range []*jobspb.BackupEncryptionOptions{
+		nil,
+		{Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("abcdefg"), salt)},
+	} {
+		t.Run(fmt.Sprintf("encrypted=%t", encryption != nil), func(t *testing.T) {
+			var stores []cloud.ExternalStorage
+			for _, dir := range []string{"default", "east", "west"} {
+				store, err := externalStorageFromURI(ctx,
+					fmt.Sprintf("nodelocal://0/%s/%t", dir, encryption != nil), security.RootUserName())
+				require.NoError(t, err)
+				defer store.Close()
+				stores = append(stores, store)
+			}
+
+			writeSST := func(
+				store cloud.ExternalStorage, path string, compressed bool, keys ...storage.MVCCKey,
+			) {
+				var f storage.MemFile
+				w := storage.MakeBackupSSTWriter(&f)
+				defer w.Close()
+				for _, k := range keys {
+					require.NoError(t, w.Put(k, []byte("value")))
+				}
+				require.NoError(t, w.Finish())
+				data := f.Data()
+				if compressed {
+					data, err = zstd.Compress(nil, data)
+					require.NoError(t, err)
+				}
+				if encryption != nil {
+					data, err = storageccl.EncryptFile(data, encryption.Key)
+					require.NoError(t, err)
+				}
+				require.NoError(t, store.WriteFile(ctx, path, bytes.NewReader(data)))
+			}
+			key := func(k string, wall int64) storage.MVCCKey {
+				return storage.MVCCKey{Key: roachpb.Key(k), Timestamp: makeTestTimestamp(wall)}
+			}
+
+			// The default locality's files were only listed in the lost manifest.
+			writeSST(stores[0], "default.sst", false /* compressed */, key("a", 3))
+			writeSST(stores[1], "c-d.sst", false /* compressed */, key("c", 5), key("c", 2))
+			writeSST(stores[2], "d-e.sst", false /* compressed */, key("d", 9))
+			writeSST(stores[2], "f-g.sst", true /* compressed */, key("f", 7))
+			compressedFile := makeTestLocalityFile("f", "g", "region=west", 20)
+			compressedFile.Compression = roachpb.ImportRequest_File_Zstd
+			backupID := uuid.MakeV4()
+			for i, desc := range []BackupPartitionDescriptor{
+				{LocalityKV: "region=east", Files: []BackupManifest_File{
+					makeTestLocalityFile("c", "d", "region=east", 10),
+				}},
+				{LocalityKV: "region=west", Files: []BackupManifest_File{
+					compressedFile,
+					makeTestLocalityFile("d", "e", "region=west", 30),
+				}},
+			} {
+				desc.BackupID = backupID
+				require.NoError(t, writeBackupPartitionDescriptor(ctx, stores[i+1],
+					fmt.Sprintf("%s_%d_%s", backupPartitionDescriptorPrefix, i+1, sanitizeLocalityKV(desc.LocalityKV)),
+					encryption, &desc))
+			}
+
+			m, err := RebuildManifestFromPartitions(ctx, stores, encryption)
+			require.NoError(t, err)
+			require.Equal(t, backupID, m.ID)
+			require.Equal(t, []string{"region=east", "region=west"}, m.LocalityKVs)
+			require.Equal(t, []string{"BACKUP_PART_1_region_east", "BACKUP_PART_2_region_west"},
+				m.PartitionDescriptorFilenames)
+			var paths []string
+			for _, f := range m.Files {
+				paths = append(paths, f.Path)
+			}
+			require.Equal(t, []string{"c-d.sst", "d-e.sst", "f-g.sst"}, paths)
+			require.Equal(t, []roachpb.Span{
+				{Key: roachpb.Key("c"), EndKey: roachpb.Key("e")},
+				{Key: roachpb.Key("f"), EndKey: roachpb.Key("g")},
+			}, m.Spans)
+			require.Equal(t, int64(60), m.EntryCounts.DataSize)
+			require.Equal(t, makeTestTimestamp(9), m.EndTime)
+			require.True(t, m.StartTime.IsEmpty())
+
+			// Partitions of another backup in the same locations aren't mixed in.
+			other := BackupPartitionDescriptor{LocalityKV: "region=north", BackupID: uuid.MakeV4()}
+			require.NoError(t, writeBackupPartitionDescriptor(ctx, stores[0],
+				backupPartitionDescriptorPrefix+"_3_region_north", encryption, &other))
+			_, err = RebuildManifestFromPartitions(ctx, stores, encryption)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "belongs to backup")
+		})
+	}
+
+	empty, err := externalStorageFromURI(ctx, "nodelocal://0/empty", security.RootUserName())
+	require.NoError(t, err)
+	defer empty.Close()
+	_, err = RebuildManifestFromPartitions(ctx, []cloud.ExternalStorage{empty}, nil /* encryption */)
+	require.EqualError(t, err, "no backup partition descriptors found")
+}
+
+// unlistableStorage doesn't support listing its files.
+type unlistableStorage struct {
+	cloud.ExternalStorage
+}
+
+func (unlistableStorage) ListFiles(context.Context, string) ([]string, error) {
+	return nil, cloudimpl.ErrListingUnsupported
+}
+
+func TestFindOrphanedBackupFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://0/orphans", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+
+	const layer = "20201225/060000.00"
+	for _, name := range []string{
+		backupManifestName, "1.sst", "2.sst", "3.sst",
+		layer + "/" + backupManifestName, layer + "/4.sst", layer + "/5.sst",
+	} {
+		require.NoError(t, store.WriteFile(ctx, name, bytes.NewReader([]byte("data"))))
+	}
+	manifests := []BackupManifest{
+		{Files: []BackupManifest_File{{Path: "1.sst"}, {Path: "2.sst"}}},
+		{Files: []BackupManifest_File{{Path: "4.sst"}}},
+	}
+
+	orphans, err := FindOrphanedBackupFiles(ctx, store, manifests)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3.sst", layer + "/5.sst"}, orphans)
+
+	// The files of a layer are only those in its own subdirectory.
+	manifests[1].Files = append(manifests[1].Files, BackupManifest_File{Path: "3.sst"})
+	orphans, err = FindOrphanedBackupFiles(ctx, store, manifests)
+	require.NoError(t, err)
+	require.Equal(t, []string{"3.sst", layer + "/5.sst"}, orphans)
+
+	_, err = FindOrphanedBackupFiles(ctx, store, manifests[:1])
+	require.EqualError(t, err, "backup has 2 layers, but 1 manifests were given")
+
+	_, err = FindOrphanedBackupFiles(ctx, unlistableStorage{store}, manifests)
+	require.True(t, errors.Is(err, cloudimpl.ErrListingUnsupported), "%+v", err)
+}
@@ -12,11 +12,13 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"runtime"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
 	"github.com/cockroachdb/cockroach/pkg/kv/bulk"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
@@ -36,6 +38,12 @@ import (
 // Progress is streamed to the coordinator through metadata.
 var restoreDataOutputTypes = []*types.T{}
 
+var restoreParallelDecryption = settings.RegisterBoolSetting(
+	"bulkio.restore.parallel_decryption.enabled",
+	"fetch and decrypt the files of each span restored from an encrypted backup concurrently, using up to one worker per CPU",
+	false,
+)
+
 type restoreDataProcessor struct {
 	execinfra.ProcessorBase
 
@@ -46,6 +54,9 @@ type restoreDataProcessor struct {
 
 	alloc rowenc.DatumAlloc
 	kr    *storageccl.KeyRewriter
+
+	// pending is the metadata of the last entry which is still to be returned.
+	pending []*execinfrapb.ProducerMetadata
 }
 
 var _ execinfra.Processor = &restoreDataProcessor{}
@@ -94,6 +105,11 @@ func (rd *restoreDataProcessor) Next() (rowenc.EncDatumRow, *execinfrapb.Produce
 	if rd.State != execinfra.StateRunning {
 		return nil, rd.DrainHelper()
 	}
+	if len(rd.pending) > 0 {
+		meta := rd.pending[0]
+		rd.pending = rd.pending[1:]
+		return nil, meta
+	}
 	// We read rows from the SplitAndScatter processor. We expect each row to
 	// contain 2 columns. The first is used to route the row to this processor,
 	// and the second contains the RestoreSpanEntry that we're interested in.
@@ -137,11 +153,25 @@ func (rd *restoreDataProcessor) Next() (rowenc.EncDatumRow, *execinfrapb.Produce
 	}
 
 	log.VEventf(rd.Ctx, 1 /* level */, "importing span %v", entry.Span)
-	summary, err := rd.processRestoreSpanEntry(entry, newSpanKey)
+	summary, skipped, err := rd.processRestoreSpanEntry(entry, newSpanKey)
 	if err != nil {
 		rd.MoveToDraining(err)
 		return nil, rd.DrainHelper()
 	}
+	// Each skipped file is reported to the coordinator ahead of the progress of
+	// the entry.
+	for i := range skipped {
+		details, err := gogotypes.MarshalAny(&skipped[i])
+		if err != nil {
+			rd.MoveToDraining(err)
+			return nil, rd.DrainHelper()
+		}
+		rd.pending = append(rd.pending, &execinfrapb.ProducerMetadata{
+			BulkProcessorProgress: &execinfrapb.RemoteProducerMetadata_BulkProcessorProgress{
+				ProgressDetails: *details,
+			},
+		})
+	}
 
 	var prog execinfrapb.RemoteProducerMetadata_BulkProcessorProgress
 	progDetails := RestoreProgress{}
@@ -154,7 +184,10 @@ func (rd *restoreDataProcessor) Next() (rowenc.EncDatumRow, *execinfrapb.Produce
 		return nil, rd.DrainHelper()
 	}
 	prog.ProgressDetails = *details
-	return nil, &execinfrapb.ProducerMetadata{BulkProcessorProgress: &prog}
+	rd.pending = append(rd.pending, &execinfrapb.ProducerMetadata{BulkProcessorProgress: &prog})
+	meta = rd.pending[0]
+	rd.pending = rd.pending[1:]
+	return nil, meta
 }
 
 // ConsumerClosed is part of the RowSource interface.
@@ -166,23 +199,22 @@ func init() {
 	rowexec.NewRestoreDataProcessor = newRestoreDataProcessor
 }
 
-func (rd *restoreDataProcessor) processRestoreSpanEntry(
-	entry execinfrapb.RestoreSpanEntry, newSpanKey roachpb.Key,
-) (roachpb.BulkOpSummary, error) {
-	db := rd.flowCtx.Cfg.DB
-	ctx := rd.Ctx
-	evalCtx := rd.EvalCtx
-	var summary roachpb.BulkOpSummary
-
-	// The sstables only contain MVCC data and no intents, so using an MVCC
-	// iterator is sufficient.
-	var iters []storage.SimpleMVCCIterator
-	for _, file := range entry.Files {
-		log.VEventf(ctx, 2, "import file %s %s", file.Path, newSpanKey)
-
+// fetchRestoreFile returns the decrypted contents of the file, after checking
+// them against the file's checksum.
+func (rd *restoreDataProcessor) fetchRestoreFile(
+	ctx context.Context, file roachpb.ImportRequest_File, newSpanKey roachpb.Key,
+) ([]byte, error) {
+	log.VEventf(ctx, 2, "import file %s %s", file.Path, newSpanKey)
+
+	var fileContents []byte
+	if len(file.InlineData) > 0 {
+		// The file was stored in the manifest. It's decrypted in place below, so
+		// it's copied to leave the spec untouched.
+		fileContents = append([]byte(nil), file.InlineData...)
+	} else {
 		dir, err := rd.flowCtx.Cfg.ExternalStorage(ctx, file.Dir)
 		if err != nil {
-			return summary, err
+			return nil, err
 		}
 		defer func() {
 			if err := dir.Close(); err != nil {
@@ -191,7 +223,6 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 		}()
 
 		const maxAttempts = 3
-		var fileContents []byte
 		if err := retry.WithMaxAttempts(ctx, base.DefaultRetryOptions(), maxAttempts, func() error {
 			f, err := dir.ReadFile(ctx, file.Path)
 			if err != nil {
@@ -201,31 +232,124 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 			fileContents, err = ioutil.ReadAll(f)
 			return err
 		}); err != nil {
-			return summary, errors.Wrapf(err, "fetching %q", file.Path)
+			return nil, errors.Wrapf(err, "fetching %q", file.Path)
 		}
 		dataSize := int64(len(fileContents))
 		log.Eventf(ctx, "fetched file (%s)", humanizeutil.IBytes(dataSize))
+	}
+	return decodeBackupFile(fileContents, file, rd.spec.Encryption)
+}
 
-		if rd.spec.Encryption != nil {
-			fileContents, err = storageccl.DecryptFile(fileContents, rd.spec.Encryption.Key)
-			if err != nil {
-				return summary, err
-			}
+// decodeBackupFile returns the contents of file, as read from the backup,
+// decrypted with encryption, if set, and decompressed, after checking them
+// against the file's checksum. fileContents may be modified.
+func decodeBackupFile(
+	fileContents []byte, file roachpb.ImportRequest_File, encryption *roachpb.FileEncryptionOptions,
+) ([]byte, error) {
+	var err error
+	if encryption != nil {
+		fileContents, err = storageccl.DecryptFile(fileContents, encryption.Key)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if len(file.Sha512) > 0 {
-			checksum, err := storageccl.SHA512ChecksumData(fileContents)
-			if err != nil {
-				return summary, err
-			}
-			if !bytes.Equal(checksum, file.Sha512) {
-				return summary, errors.Errorf("checksum mismatch for %s", file.Path)
+	fileContents, err = storageccl.DecompressFile(fileContents, file.Compression)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %q", file.Path)
+	}
+
+	if len(file.Sha512) > 0 {
+		checksum, err := storageccl.SHA512ChecksumData(fileContents)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(checksum, file.Sha512) {
+			return nil, errors.Errorf("checksum mismatch for %s", file.Path)
+		}
+	}
+	return fileContents, nil
+}
+
+// fetchRestoreFiles returns the contents of each of the files of entry, as
+// returned by fetch, using the given number of workers. If skipUnreadable is
+// set, a file which fails to be fetched skips the whole entry rather than
+// failing it: no contents are returned, and the files which failed are logged
+// and returned, with the span of the entry. The files of the entry are those of
+// every layer of the backup covering its span, so restoring the others would
+// restore older versions of the rows in the unreadable files, such as rows
+// they deleted, rather than leave them missing.
+func fetchRestoreFiles(
+	ctx context.Context,
+	entry execinfrapb.RestoreSpanEntry,
+	workers int,
+	skipUnreadable bool,
+	fetch func(context.Context, roachpb.ImportRequest_File) ([]byte, error),
+) ([][]byte, []BackupManifest_File, error) {
+	fileContents := make([][]byte, len(entry.Files))
+	fetchErrs := make([]error, len(entry.Files))
+	if err := forEachBackupFile(ctx, len(entry.Files), workers, func(ctx context.Context, i int) error {
+		var err error
+		if fileContents[i], err = fetch(ctx, entry.Files[i]); err != nil {
+			// A cancelled restore mustn't be mistaken for unreadable files.
+			if !skipUnreadable || ctx.Err() != nil {
+				return err
 			}
+			fetchErrs[i] = err
 		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
 
-		iter, err := storage.NewMemSSTIterator(fileContents, false)
+	var skipped []BackupManifest_File
+	for i, err := range fetchErrs {
+		if err == nil {
+			continue
+		}
+		log.Errorf(ctx, "SKIPPING span %s because backup file %s can't be read; "+
+			"none of the data in the span will be restored, from any of its %d files: %v",
+			entry.Span, entry.Files[i].Path, len(entry.Files), err)
+		skipped = append(skipped, BackupManifest_File{Span: entry.Span, Path: entry.Files[i].Path})
+	}
+	if len(skipped) > 0 {
+		return nil, skipped, nil
+	}
+	return fileContents, nil, nil
+}
+
+func (rd *restoreDataProcessor) processRestoreSpanEntry(
+	entry execinfrapb.RestoreSpanEntry, newSpanKey roachpb.Key,
+) (roachpb.BulkOpSummary, []BackupManifest_File, error) {
+	db := rd.flowCtx.Cfg.DB
+	ctx := rd.Ctx
+	evalCtx := rd.EvalCtx
+	var summary roachpb.BulkOpSummary
+
+	// The files of the entry are fetched concurrently. Decryption is CPU bound,
+	// so the files of an encrypted backup can be fetched and decrypted by a
+	// worker per CPU. Each worker fills in the contents of the files it picks up,
+	// so the files are still iterated over in the order of the entry below.
+	workers := int(backupFileConcurrency.Get(&evalCtx.Settings.SV))
+	if rd.spec.Encryption != nil && restoreParallelDecryption.Get(&evalCtx.Settings.SV) &&
+		workers < runtime.GOMAXPROCS(0) {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	fileContents, skipped, err := fetchRestoreFiles(ctx, entry, workers, rd.spec.SkipUnreadableFiles,
+		func(ctx context.Context, file roachpb.ImportRequest_File) ([]byte, error) {
+			return rd.fetchRestoreFile(ctx, file, newSpanKey)
+		})
+	if err != nil || len(skipped) > 0 {
+		return summary, skipped, err
+	}
+
+	// The sstables only contain MVCC data and no intents, so using an MVCC
+	// iterator is sufficient.
+	var iters []storage.SimpleMVCCIterator
+	for i := range fileContents {
+		iter, err := storage.NewMemSSTIterator(fileContents[i], false)
 		if err != nil {
-			return summary, err
+			return summary, nil, err
 		}
 
 		defer iter.Close()
@@ -235,7 +359,7 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 	batcher, err := bulk.MakeSSTBatcher(ctx, db, evalCtx.Settings,
 		func() int64 { return storageccl.MaxImportBatchSize(evalCtx.Settings) })
 	if err != nil {
-		return summary, err
+		return summary, nil, err
 	}
 	defer batcher.Close()
 
@@ -248,7 +372,7 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 	for iter.SeekGE(startKeyMVCC); ; {
 		ok, err := iter.Valid()
 		if err != nil {
-			return summary, err
+			return summary, nil, err
 		}
 		if !ok {
 			break
@@ -280,7 +404,7 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 
 		key.Key, ok, err = rd.kr.RewriteKey(key.Key, false /* isFromSpan */)
 		if err != nil {
-			return summary, err
+			return summary, nil, err
 		}
 		if !ok {
 			// If the key rewriter didn't match this key, it's not data for the
@@ -299,12 +423,12 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 			log.Infof(ctx, "Put %s -> %s", key.Key, value.PrettyPrint())
 		}
 		if err := batcher.AddMVCCKey(ctx, key, value.RawBytes); err != nil {
-			return summary, errors.Wrapf(err, "adding to batch: %s -> %s", key, value.PrettyPrint())
+			return summary, nil, errors.Wrapf(err, "adding to batch: %s -> %s", key, value.PrettyPrint())
 		}
 	}
 	// Flush out the last batch.
 	if err := batcher.Flush(ctx); err != nil {
-		return summary, err
+		return summary, nil, err
 	}
 	log.Event(ctx, "done")
 
@@ -314,5 +438,5 @@ func (rd *restoreDataProcessor) processRestoreSpanEntry(
 		}
 	}
 
-	return batcher.GetSummary(), nil
+	return batcher.GetSummary(), nil, nil
 }
@@ -0,0 +1,95 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchRestoreFilesSkipUnreadable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	entry := execinfrapb.RestoreSpanEntry{
+		Span: roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("c")},
+		Files: []roachpb.ImportRequest_File{
+			{Path: "1.sst"}, {Path: "2.sst"}, {Path: "3.sst"},
+		},
+	}
+	fetch := func(_ context.Context, file roachpb.ImportRequest_File) ([]byte, error) {
+		if file.Path == "2.sst" {
+			return nil, errors.New("corrupt file")
+		}
+		return []byte(file.Path), nil
+	}
+
+	for _, workers := range []int{1, 4} {
+		_, _, err := fetchRestoreFiles(ctx, entry, workers, false /* skipUnreadable */, fetch)
+		require.EqualError(t, err, "corrupt file")
+
+		// The whole entry is skipped, rather than restored from its other files.
+		contents, skipped, err := fetchRestoreFiles(ctx, entry, workers, true /* skipUnreadable */, fetch)
+		require.NoError(t, err)
+		require.Nil(t, contents)
+		require.Equal(t, []BackupManifest_File{{Span: entry.Span, Path: "2.sst"}}, skipped)
+	}
+
+	contents, skipped, err := fetchRestoreFiles(ctx, execinfrapb.RestoreSpanEntry{
+		Span: entry.Span, Files: []roachpb.ImportRequest_File{{Path: "1.sst"}, {Path: "3.sst"}},
+	}, 1 /* workers */, true /* skipUnreadable */, fetch)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("1.sst"), []byte("3.sst")}, contents)
+	require.Empty(t, skipped)
+
+	// Files aren't skipped when the restore is cancelled.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = fetchRestoreFiles(cancelled, entry, 1 /* workers */, true, /* skipUnreadable */
+		func(ctx context.Context, _ roachpb.ImportRequest_File) ([]byte, error) {
+			return nil, ctx.Err()
+		})
+	require.True(t, errors.Is(err, context.Canceled), "%+v", err)
+}
+
+func TestSummarizeSkippedRestoreSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	// A file which covers several entries is skipped for each of them, and the
+	// spans and files skipped again on a retry are only recorded once.
+	var p jobspb.RestoreProgress
+	for _, f := range []BackupManifest_File{
+		{Span: span("c", "d"), Path: "2.sst"},
+		{Span: span("a", "b"), Path: "1.sst"},
+		{Span: span("b", "c"), Path: "1.sst"},
+		{Span: span("c", "d"), Path: "2.sst"},
+		{Span: span("c", "d"), Path: "3.sst"},
+	} {
+		addSkippedRestoreFile(&p, f)
+	}
+	require.Equal(t, []roachpb.Span{span("c", "d"), span("a", "b"), span("b", "c")}, p.SkippedSpans)
+	require.Equal(t, []string{"2.sst", "1.sst", "3.sst"}, p.SkippedFiles)
+	require.Equal(t, fmt.Sprintf("restore skipped spans %v of the backup because 3 of their backup "+
+		"files couldn't be read, and is MISSING all the data in those spans, from every backup layer: "+
+		"1.sst, 2.sst, 3.sst", []roachpb.Span{span("a", "d")}), summarizeSkippedRestoreSpans(p))
+}
@@ -13,6 +13,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"sort"
+	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/build"
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
@@ -249,9 +251,11 @@ rangeLoop:
 			case backupFile:
 				if len(ie.file.Path) > 0 {
 					files = append(files, roachpb.ImportRequest_File{
-						Dir:    ie.dir,
-						Path:   ie.file.Path,
-						Sha512: ie.file.Sha512,
+						Dir:         ie.dir,
+						Path:        ie.file.Path,
+						Sha512:      ie.file.Sha512,
+						Compression: ie.file.Compression,
+						InlineData:  ie.file.InlineData,
 					})
 				}
 			}
@@ -493,6 +497,7 @@ func restore(
 	spans []roachpb.Span,
 	job *jobs.Job,
 	encryption *jobspb.BackupEncryptionOptions,
+	skipUnreadableFiles bool,
 ) (RowCount, error) {
 	user := execCtx.User()
 	// A note about contexts and spans in this method: the top-level context
@@ -510,9 +515,16 @@ func restore(
 		highWaterMark     int
 		res               RowCount
 		requestsCompleted []bool
+		// skipped are the spans skipped by the processors because of unreadable
+		// files, if skipUnreadableFiles is set, including those persisted in the
+		// progress of the job by earlier attempts.
+		skipped jobspb.RestoreProgress
 	}{
 		highWaterMark: -1,
 	}
+	restoreProgress := job.Progress().Details.(*jobspb.Progress_Restore).Restore
+	mu.skipped.SkippedSpans = append([]roachpb.Span(nil), restoreProgress.SkippedSpans...)
+	mu.skipped.SkippedFiles = append([]string(nil), restoreProgress.SkippedFiles...)
 
 	// Get TableRekeys to use when importing raw data.
 	var rekeys []roachpb.ImportRequest_TableRekey
@@ -531,9 +543,8 @@ func restore(
 
 	// Pivot the backups, which are grouped by time, into requests for import,
 	// which are grouped by keyrange.
-	highWaterMark := job.Progress().Details.(*jobspb.Progress_Restore).Restore.HighWater
 	importSpans, _, err := makeImportSpans(spans, backupManifests, backupLocalityInfo,
-		highWaterMark, user, errOnMissingRange)
+		restoreProgress.HighWater, user, errOnMissingRange)
 	if err != nil {
 		return emptyRowCount, errors.Wrapf(err, "making import requests for %d backups", len(backupManifests))
 	}
@@ -551,6 +562,8 @@ func restore(
 				if mu.highWaterMark >= 0 {
 					d.Restore.HighWater = importSpans[mu.highWaterMark].Span.Key
 				}
+				d.Restore.SkippedSpans = append([]roachpb.Span(nil), mu.skipped.SkippedSpans...)
+				d.Restore.SkippedFiles = append([]string(nil), mu.skipped.SkippedFiles...)
 				mu.Unlock()
 			default:
 				log.Errorf(progressedCtx, "job payload had unexpected type %T", d)
@@ -593,6 +606,18 @@ func restore(
 		// to progCh.
 		for progress := range progCh {
 			mu.Lock()
+			// The processors report the files they skipped ahead of the progress
+			// of the span they belong to.
+			if types.Is(&progress.ProgressDetails, &BackupManifest_File{}) {
+				var skipped BackupManifest_File
+				if err := types.UnmarshalAny(&progress.ProgressDetails, &skipped); err != nil {
+					log.Errorf(ctx, "unable to unmarshal skipped restore file: %+v", err)
+				} else {
+					addSkippedRestoreFile(&mu.skipped, skipped)
+				}
+				mu.Unlock()
+				continue
+			}
 			var progDetails RestoreProgress
 			if err := types.UnmarshalAny(&progress.ProgressDetails, &progDetails); err != nil {
 				log.Errorf(ctx, "unable to unmarshal restore progress details: %+v", err)
@@ -630,6 +655,7 @@ func restore(
 		encryption,
 		rekeys,
 		endTime,
+		skipUnreadableFiles,
 		progCh,
 	); err != nil {
 		return emptyRowCount, err
@@ -642,9 +668,59 @@ func restore(
 		return emptyRowCount, errors.Wrapf(err, "importing %d ranges", len(importSpans))
 	}
 
+	// The progress logger may not have persisted the last of the skipped spans.
+	if len(mu.skipped.SkippedSpans) > len(restoreProgress.SkippedSpans) ||
+		len(mu.skipped.SkippedFiles) > len(restoreProgress.SkippedFiles) {
+		if err := job.Update(restoreCtx, func(_ *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater) error {
+			if err := md.CheckRunningOrReverting(); err != nil {
+				return err
+			}
+			d := md.Progress.GetRestore()
+			d.SkippedSpans = mu.skipped.SkippedSpans
+			d.SkippedFiles = mu.skipped.SkippedFiles
+			ju.UpdateProgress(md.Progress)
+			return nil
+		}); err != nil {
+			return emptyRowCount, errors.Wrap(err, "persisting skipped spans")
+		}
+	}
+
 	return mu.res, nil
 }
 
+// addSkippedRestoreFile records in p that the span of f, in the key space of
+// the backup, was skipped because f, one of its files, couldn't be read. Spans
+// and files already recorded, e.g. by an earlier attempt, aren't added again.
+func addSkippedRestoreFile(p *jobspb.RestoreProgress, f BackupManifest_File) {
+	found := false
+	for _, sp := range p.SkippedSpans {
+		if sp.Equal(f.Span) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		p.SkippedSpans = append(p.SkippedSpans, f.Span)
+	}
+	for _, path := range p.SkippedFiles {
+		if path == f.Path {
+			return
+		}
+	}
+	p.SkippedFiles = append(p.SkippedFiles, f.Path)
+}
+
+// summarizeSkippedRestoreSpans describes the data lost by a restore which
+// skipped the spans recorded in p because of unreadable files.
+func summarizeSkippedRestoreSpans(p jobspb.RestoreProgress) string {
+	spans, _ := roachpb.MergeSpans(append([]roachpb.Span(nil), p.SkippedSpans...))
+	paths := append([]string(nil), p.SkippedFiles...)
+	sort.Strings(paths)
+	return fmt.Sprintf("restore skipped spans %v of the backup because %d of their backup files "+
+		"couldn't be read, and is MISSING all the data in those spans, from every backup layer: %s",
+		spans, len(paths), strings.Join(paths, ", "))
+}
+
 // loadBackupSQLDescs extracts the backup descriptors, the latest backup
 // descriptor, and all the Descriptors for a backup to be restored. It upgrades
 // the table descriptors to the new FK representation if necessary. FKs that
@@ -659,9 +735,11 @@ func loadBackupSQLDescs(
 	p sql.JobExecContext,
 	details jobspb.RestoreDetails,
 	encryption *jobspb.BackupEncryptionOptions,
+	dataKeys *dataKeyCache,
 ) ([]BackupManifest, BackupManifest, []catalog.Descriptor, error) {
 	backupManifests, err := loadBackupManifests(ctx, details.URIs,
-		p.User(), p.ExecCfg().DistSQLSrv.ExternalStorageFromURI, encryption)
+		p.User(), p.ExecCfg().DistSQLSrv.ExternalStorageFromURI, encryption,
+		manifestReadOptions{dataKeys: dataKeys})
 	if err != nil {
 		return nil, BackupManifest{}, nil, err
 	}
@@ -674,7 +752,13 @@ func loadBackupSQLDescs(
 		return nil, BackupManifest{}, nil, err
 	}
 
-	allDescs, latestBackupManifest := loadSQLDescsFromBackupsAtTime(backupManifests, details.EndTime)
+	allDescs, latestBackupManifest, err := loadSQLDescsFromBackupsAtTime(
+		ctx, backupManifests, details.EndTime, nil /* idRewrite */, nil, /* validate */
+		manifestValidationEnabled.Get(&p.ExecCfg().Settings.SV),
+	)
+	if err != nil {
+		return nil, BackupManifest{}, nil, err
+	}
 
 	var sqlDescs []catalog.Descriptor
 	for _, desc := range allDescs {
@@ -712,34 +796,214 @@ type restoreResumer struct {
 	}
 }
 
-// getStatisticsFromBackup retrieves Statistics from backup manifest,
-// either through the Statistics field or from the files.
-func getStatisticsFromBackup(
-	ctx context.Context,
-	exportStore cloud.ExternalStorage,
+// statsSource is where the statistics of a table are stored in a layer of a
+// chain of backups: in the statistics file name of the layer's store or, for
+// backups taken prior to 20.2, in the Statistics field of its manifest if name
+// is empty.
+type statsSource struct {
+	layer int
+	name  string
+}
+
+// statisticsSourcesByTable returns, for each table with statistics in the chain
+// of backups, where they are stored in each layer which has any for it, from
+// the latest layer to the earliest.
+func statisticsSourcesByTable(backups []BackupManifest) map[descpb.ID][]statsSource {
+	res := make(map[descpb.ID][]statsSource)
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].DeprecatedStatistics != nil {
+			seen := make(map[descpb.ID]struct{})
+			for _, stat := range backups[i].DeprecatedStatistics {
+				if _, ok := seen[stat.TableID]; !ok {
+					seen[stat.TableID] = struct{}{}
+					res[stat.TableID] = append(res[stat.TableID], statsSource{layer: i})
+				}
+			}
+			continue
+		}
+		for id, name := range backups[i].StatisticsFilenames {
+			res[id] = append(res[id], statsSource{layer: i, name: name})
+		}
+	}
+	return res
+}
+
+// lazyStatisticsFromBackup returns, for each table with statistics in the
+// chain of backups, a function which retrieves that table's statistics either
+// from the Statistics field of a manifest or from the statistics files, the
+// files of backups[i] being in stores[i]. The statistics of a table are the
+// freshest available: those of the latest layer which has any for it, unless
+// its statistics file is missing, in which case those of the earlier layers
+// are used instead. Nothing is read from the files until one of the functions
+// is invoked, and each file is read at most once and released once all the
+// tables it may hold statistics for have been read. Statistics on any of the
+// given tables which no longer match the columns of that table are dropped, see
+// filterStaleStatistics. The KMS data key of the files, if any, is cached in
+// dataKeys, if set.
+func lazyStatisticsFromBackup(
+	stores []cloud.ExternalStorage,
 	encryption *jobspb.BackupEncryptionOptions,
-	backup BackupManifest,
-) ([]*stats.TableStatisticProto, error) {
-	// This part deals with pre-20.2 stats format where backup statistics
-	// are stored as a field in backup manifests instead of in their
-	// individual files.
-	if backup.DeprecatedStatistics != nil {
-		return backup.DeprecatedStatistics, nil
-	}
-	tableStatistics := make([]*stats.TableStatisticProto, 0, len(backup.StatisticsFilenames))
-	uniqueFileNames := make(map[string]struct{})
-	for _, fname := range backup.StatisticsFilenames {
-		if _, exists := uniqueFileNames[fname]; !exists {
-			uniqueFileNames[fname] = struct{}{}
-			myStatsTable, err := readTableStatistics(ctx, exportStore, fname, encryption)
-			if err != nil {
-				return tableStatistics, err
+	dataKeys *dataKeyCache,
+	backups []BackupManifest,
+	tables map[descpb.ID]catalog.TableDescriptor,
+) map[descpb.ID]func(context.Context) ([]*stats.TableStatisticProto, error) {
+	res := make(map[descpb.ID]func(context.Context) ([]*stats.TableStatisticProto, error))
+	forTable := func(statistics []*stats.TableStatisticProto, id descpb.ID) []*stats.TableStatisticProto {
+		var tableStatistics []*stats.TableStatisticProto
+		for _, stat := range statistics {
+			if stat.TableID == id {
+				tableStatistics = append(tableStatistics, stat)
 			}
-			tableStatistics = append(tableStatistics, myStatsTable.Statistics...)
 		}
+		return tableStatistics
 	}
 
-	return tableStatistics, nil
+	var mu struct {
+		syncutil.Mutex
+		// files holds the statistics files that have been read, and refs the
+		// number of tables whose statistics may still have to be returned from
+		// them.
+		files map[statsSource]*StatsTable
+		refs  map[statsSource]int
+	}
+	mu.files = make(map[statsSource]*StatsTable)
+	mu.refs = make(map[statsSource]int)
+	for id, sources := range statisticsSourcesByTable(backups) {
+		id, sources := id, sources
+		for _, src := range sources {
+			mu.refs[src]++
+		}
+		res[id] = func(ctx context.Context) ([]*stats.TableStatisticProto, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			defer func() {
+				for _, src := range sources {
+					if mu.refs[src]--; mu.refs[src] == 0 {
+						delete(mu.files, src)
+					}
+				}
+			}()
+			var err error
+			for _, src := range sources {
+				if src.name == "" {
+					// This part deals with pre-20.2 stats format where backup
+					// statistics are stored as a field in backup manifests instead
+					// of in their individual files.
+					return filterStaleStatistics(
+						ctx, forTable(backups[src.layer].DeprecatedStatistics, id), nil /* versions */, tables), nil
+				}
+				statsTable, ok := mu.files[src]
+				if !ok {
+					statsTable, err = readTableStatistics(ctx, stores[src.layer], src.name, encryption, dataKeys)
+					if errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+						log.Warningf(ctx, "statistics file %s of backup layer %d is missing, "+
+							"falling back to the statistics of earlier layers", src.name, src.layer)
+						continue
+					} else if err != nil {
+						return nil, err
+					}
+					mu.files[src] = statsTable
+				}
+				return filterStaleStatistics(
+					ctx, forTable(statsTable.Statistics, id), statsTable.TableVersions, tables), nil
+			}
+			return nil, err
+		}
+	}
+	return res
+}
+
+// checkStatisticsFilesExist checks that the statistics of the tables of the
+// chain of backups which are restored, i.e. which have a rewrite in
+// descriptorRewrites, can be found in a statistics file which exists, the files
+// of backups[i] being in stores[i]. As in lazyStatisticsFromBackup, a missing
+// file of a layer is only reported if no earlier layer has statistics for the
+// table. The statistics are only read once the data has been restored, so
+// this is checked when the restore is planned, so that a missing file fails the
+// restore before any of its data is restored.
+func checkStatisticsFilesExist(
+	ctx context.Context,
+	stores []cloud.ExternalStorage,
+	backups []BackupManifest,
+	descriptorRewrites DescRewriteMap,
+) error {
+	checked := make(map[statsSource]error)
+	for id, sources := range statisticsSourcesByTable(backups) {
+		if _, ok := descriptorRewrites[id]; !ok {
+			continue
+		}
+		var missing error
+		for _, src := range sources {
+			if src.name == "" {
+				missing = nil
+				break
+			}
+			err, ok := checked[src]
+			if !ok {
+				_, err = stores[src.layer].Size(ctx, src.name)
+				if err != nil {
+					err = errors.WithHint(
+						errors.Wrapf(err, "checking table statistics file %s of backup", src.name),
+						"use the skip_statistics option to restore without the table statistics")
+				}
+				checked[src] = err
+			}
+			if err == nil {
+				missing = nil
+				break
+			}
+			if !errors.Is(err, cloudimpl.ErrFileDoesNotExist) {
+				return err
+			}
+			if missing == nil {
+				missing = err
+			}
+		}
+		if missing != nil {
+			return missing
+		}
+	}
+	return nil
+}
+
+// filterStaleStatistics returns the statistics which are still applicable to
+// the given tables, keyed by their ID in the backup. Statistics that were
+// collected against the same descriptor version as the one being restored are
+// always kept. Otherwise, e.g. when restoring to an earlier time or reading
+// files that predate version tagging, a statistic is dropped with a warning if
+// it references a column which does not exist in the table being restored.
+// Statistics on tables which are not being restored are returned untouched.
+func filterStaleStatistics(
+	ctx context.Context,
+	statistics []*stats.TableStatisticProto,
+	versions map[descpb.ID]descpb.DescriptorVersion,
+	tables map[descpb.ID]catalog.TableDescriptor,
+) []*stats.TableStatisticProto {
+	filtered := make([]*stats.TableStatisticProto, 0, len(statistics))
+	for _, stat := range statistics {
+		table, ok := tables[stat.TableID]
+		if !ok {
+			filtered = append(filtered, stat)
+			continue
+		}
+		if version, ok := versions[stat.TableID]; ok && version == table.GetVersion() {
+			filtered = append(filtered, stat)
+			continue
+		}
+		stale := false
+		for _, colID := range stat.ColumnIDs {
+			if _, err := table.FindActiveColumnByID(colID); err != nil {
+				log.Warningf(ctx, "dropping statistic %q on table %q (%d): column %d does not exist "+
+					"in the restored table", stat.Name, table.GetName(), stat.TableID, colID)
+				stale = true
+				break
+			}
+		}
+		if !stale {
+			filtered = append(filtered, stat)
+		}
+	}
+	return filtered
 }
 
 // remapRelevantStatistics changes the table ID references in the stats
@@ -1141,8 +1405,13 @@ func (r *restoreResumer) Resume(
 	details := r.job.Details().(jobspb.RestoreDetails)
 	p := execCtx.(sql.JobExecContext)
 
-	backupManifests, latestBackupManifest, sqlDescs, err := loadBackupSQLDescs(
-		ctx, p, details, details.Encryption,
+	// Decrypt each of the KMS data keys used by the backup only once for the
+	// job, and don't keep them around once it is done.
+	dataKeys := newDataKeyCache(restoreDataKeyCacheSize)
+	defer dataKeys.Clear()
+
+	backupManifests, _, sqlDescs, err := loadBackupSQLDescs(
+		ctx, p, details, details.Encryption, dataKeys,
 	)
 	if err != nil {
 		return err
@@ -1151,13 +1420,19 @@ func (r *restoreResumer) Resume(
 	if err != nil {
 		return err
 	}
-	defaultConf, err := cloudimpl.ExternalStorageConfFromURI(details.URIs[lastBackupIndex], p.User())
-	if err != nil {
-		return errors.Wrapf(err, "creating external store configuration")
-	}
-	defaultStore, err := p.ExecCfg().DistSQLSrv.ExternalStorage(ctx, defaultConf)
-	if err != nil {
-		return err
+	// The statistics of each layer are in its default store.
+	defaultStores := make([]cloud.ExternalStorage, lastBackupIndex+1)
+	for i := range defaultStores {
+		defaultConf, err := cloudimpl.ExternalStorageConfFromURI(details.URIs[i], p.User())
+		if err != nil {
+			return errors.Wrapf(err, "creating external store configuration")
+		}
+		defaultStore, err := p.ExecCfg().DistSQLSrv.ExternalStorage(ctx, defaultConf)
+		if err != nil {
+			return err
+		}
+		defer defaultStore.Close()
+		defaultStores[i] = defaultStore
 	}
 
 	tables, oldTableIDs, spans, err := createImportingDescriptors(ctx, p, sqlDescs, r)
@@ -1174,11 +1449,20 @@ func (r *restoreResumer) Resume(
 		}
 	}
 	r.execCfg = p.ExecCfg()
-	backupStats, err := getStatisticsFromBackup(ctx, defaultStore, details.Encryption, latestBackupManifest)
-	if err != nil {
-		return err
+	tablesByID := make(map[descpb.ID]catalog.TableDescriptor)
+	for _, desc := range sqlDescs {
+		if table, ok := desc.(catalog.TableDescriptor); ok {
+			tablesByID[table.GetID()] = table
+		}
+	}
+	// The statistics are only read once the data has been restored, so that
+	// they aren't held in memory for the duration of the restore.
+	var lazyStats map[descpb.ID]func(context.Context) ([]*stats.TableStatisticProto, error)
+	if !details.SkipStatistics {
+		lazyStats = lazyStatisticsFromBackup(
+			defaultStores, details.Encryption, dataKeys, backupManifests[:lastBackupIndex+1], tablesByID,
+		)
 	}
-	latestStats := remapRelevantStatistics(backupStats, details.DescriptorRewrites)
 
 	if len(details.TableDescs) == 0 && len(details.Tenants) == 0 && len(details.TypeDescs) == 0 {
 		// We have no tables to restore (we are restoring an empty DB).
@@ -1240,12 +1524,16 @@ func (r *restoreResumer) Resume(
 		spans,
 		r.job,
 		details.Encryption,
+		details.SkipUnreadableFiles,
 	)
 	if err != nil {
 		return err
 	}
+	if p := r.job.Progress().Details.(*jobspb.Progress_Restore).Restore; len(p.SkippedSpans) > 0 {
+		log.Errorf(ctx, "%s", summarizeSkippedRestoreSpans(*p))
+	}
 
-	if err := insertStats(ctx, r.job, p.ExecCfg(), latestStats); err != nil {
+	if err := insertStats(ctx, r.job, p.ExecCfg(), lazyStats); err != nil {
 		return errors.Wrap(err, "inserting table statistics")
 	}
 	var newDescriptorChangeJobs []*jobs.StartableJob
@@ -1327,18 +1615,36 @@ func (r *restoreResumer) notifyStatsRefresherOfNewTables() {
 	}
 }
 
-// Insert stats re-inserts the table statistics stored in the backup manifest.
+// Insert stats re-inserts the table statistics stored in the backup manifest,
+// only reading the statistics of the restored tables.
 func insertStats(
 	ctx context.Context,
 	job *jobs.Job,
 	execCfg *sql.ExecutorConfig,
-	latestStats []*stats.TableStatisticProto,
+	lazyStats map[descpb.ID]func(context.Context) ([]*stats.TableStatisticProto, error),
 ) error {
 	details := job.Details().(jobspb.RestoreDetails)
 	if details.StatsInserted {
 		return nil
 	}
 
+	ids := make([]descpb.ID, 0, len(lazyStats))
+	for id := range lazyStats {
+		if _, ok := details.DescriptorRewrites[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var backupStats []*stats.TableStatisticProto
+	for _, id := range ids {
+		tableStats, err := lazyStats[id](ctx)
+		if err != nil {
+			return errors.Wrap(err, "reading table statistics from backup")
+		}
+		backupStats = append(backupStats, tableStats...)
+	}
+	latestStats := remapRelevantStatistics(backupStats, details.DescriptorRewrites)
+
 	err := execCfg.DB.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
 		if err := stats.InsertNewStats(ctx, execCfg.InternalExecutor, txn, latestStats); err != nil {
 			return errors.Wrapf(err, "inserting stats from backup")
@@ -1209,6 +1209,8 @@ func resolveOptionsForRestoreJobDescription(
 		SkipMissingSequences:      opts.SkipMissingSequences,
 		SkipMissingSequenceOwners: opts.SkipMissingSequenceOwners,
 		SkipMissingViews:          opts.SkipMissingViews,
+		SkipStatistics:            opts.SkipStatistics,
+		SkipUnreadableFiles:       opts.SkipUnreadableFiles,
 		Detached:                  opts.Detached,
 	}
 
@@ -1522,13 +1524,17 @@ func doRestorePlan(
 			KMSInfo: defaultKMSInfo}
 	}
 
-	defaultURIs, mainBackupManifests, localityInfo, err := resolveBackupManifests(
-		ctx, baseStores, p.ExecCfg().DistSQLSrv.ExternalStorageFromURI, from, endTime, encryption,
-		p.User(),
+	defaultURIs, mainBackupManifests, localityInfo, resolved, err := resolveBackupManifests(
+		ctx, baseStores, p.ExecCfg().DistSQLSrv.ExternalStorageFromURI, from, endTime,
+		singleBackupEncryption(encryption), p.User(),
 	)
 	if err != nil {
 		return err
 	}
+	if !endTime.IsEmpty() {
+		log.Infof(ctx, "restoring to %s using layer %d of %d ([%s, %s])",
+			endTime, resolved.index+1, resolved.numLayers, resolved.startTime, resolved.endTime)
+	}
 
 	// Validate that the table coverage of the backup matches that of the restore.
 	// This prevents FULL CLUSTER backups to be restored as anything but full
@@ -1624,6 +1630,30 @@ func doRestorePlan(
 	if err != nil {
 		return err
 	}
+	if !restoreStmt.Options.SkipStatistics {
+		if err := func() error {
+			// The statistics are restored from the layers up to the one at
+			// endTime, as the restore job does, each of which has them in its
+			// default store.
+			lastBackupIndex, err := getBackupIndexAtTime(mainBackupManifests, endTime)
+			if err != nil {
+				return err
+			}
+			defaultStores := make([]cloud.ExternalStorage, lastBackupIndex+1)
+			for i := range defaultStores {
+				store, err := p.ExecCfg().DistSQLSrv.ExternalStorageFromURI(ctx, defaultURIs[i], p.User())
+				if err != nil {
+					return errors.Wrapf(err, "failed to open backup storage location")
+				}
+				defer store.Close()
+				defaultStores[i] = store
+			}
+			return checkStatisticsFilesExist(
+				ctx, defaultStores, mainBackupManifests[:lastBackupIndex+1], descriptorRewrites)
+		}(); err != nil {
+			return err
+		}
+	}
 	description, err := restoreJobDescription(p, restoreStmt, from, restoreStmt.Options, intoDB, kms)
 	if err != nil {
 		return err
@@ -1685,15 +1715,17 @@ func doRestorePlan(
 			return sqlDescIDs
 		}(),
 		Details: jobspb.RestoreDetails{
-			EndTime:            endTime,
-			DescriptorRewrites: descriptorRewrites,
-			URIs:               defaultURIs,
-			BackupLocalityInfo: localityInfo,
-			TableDescs:         encodedTables,
-			Tenants:            tenants,
-			OverrideDB:         intoDB,
-			DescriptorCoverage: restoreStmt.DescriptorCoverage,
-			Encryption:         encryption,
+			EndTime:             endTime,
+			DescriptorRewrites:  descriptorRewrites,
+			URIs:                defaultURIs,
+			BackupLocalityInfo:  localityInfo,
+			TableDescs:          encodedTables,
+			Tenants:             tenants,
+			OverrideDB:          intoDB,
+			DescriptorCoverage:  restoreStmt.DescriptorCoverage,
+			Encryption:          encryption,
+			SkipStatistics:      restoreStmt.Options.SkipStatistics,
+			SkipUnreadableFiles: restoreStmt.Options.SkipUnreadableFiles,
 		},
 		Progress: jobspb.RestoreProgress{},
 	}
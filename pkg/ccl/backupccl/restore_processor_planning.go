@@ -45,6 +45,7 @@ func distRestore(
 	encryption *jobspb.BackupEncryptionOptions,
 	rekeys []roachpb.ImportRequest_TableRekey,
 	restoreTime hlc.Timestamp,
+	skipUnreadableFiles bool,
 	progCh chan *execinfrapb.RemoteProducerMetadata_BulkProcessorProgress,
 ) error {
 	ctx = logtags.AddTag(ctx, "restore-distsql", nil)
@@ -87,10 +88,11 @@ func distRestore(
 	}
 
 	restoreDataSpec := execinfrapb.RestoreDataSpec{
-		RestoreTime: restoreTime,
-		Encryption:  fileEncryption,
-		Rekeys:      rekeys,
-		PKIDs:       pkIDs,
+		RestoreTime:         restoreTime,
+		Encryption:          fileEncryption,
+		Rekeys:              rekeys,
+		PKIDs:               pkIDs,
+		SkipUnreadableFiles: skipUnreadableFiles,
 	}
 
 	if len(splitAndScatterSpecs) == 0 {
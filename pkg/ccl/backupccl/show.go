@@ -185,14 +185,18 @@ func showBackupPlanHook(
 			}
 		}
 
+		// Break down the time spent reading the manifests in the trace, to tell
+		// whether a slow SHOW BACKUP is waiting on the store or on the CPU.
+		var readStats manifestReadStats
+		readOpts := manifestReadOptions{stats: &readStats}
 		manifests := make([]BackupManifest, len(incPaths)+1)
-		manifests[0], err = readBackupManifestFromStore(ctx, store, encryption)
+		manifests[0], err = readBackupManifestFromStoreWithOptions(ctx, store, encryption, readOpts)
 		if err != nil {
 			return err
 		}
 
 		for i := range incPaths {
-			m, err := readBackupManifest(ctx, store, incPaths[i], encryption)
+			m, err := readBackupManifestWithOptions(ctx, store, incPaths[i], encryption, readOpts)
 			if err != nil {
 				return err
 			}
@@ -200,6 +204,11 @@ func showBackupPlanHook(
 			m.DeprecatedStatistics = nil
 			manifests[i+1] = m
 		}
+		log.VEventf(ctx, 1, "read %s", &readStats)
+		manifests, err = inflateElidedDescriptors(manifests)
+		if err != nil {
+			return err
+		}
 
 		// If we are restoring a backup with old-style foreign keys, skip over the
 		// FKs for which we can't resolve the cross-table references. We can't
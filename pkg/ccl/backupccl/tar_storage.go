@@ -0,0 +1,205 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/errors"
+)
+
+// tarGzStorage is a read-only ExternalStorage which serves the entries of a
+// gzip-compressed tarball of a backup directory, so that the backup can be read
+// without first expanding the archive. Entries are streamed out of the archive
+// as they are read, which requires scanning the archive up to the requested
+// entry on every read, so it's only suited to reading the metadata of the
+// backup, not to restoring it. The names of the files exclude the root of the
+// backup in the archive.
+type tarGzStorage struct {
+	archiveStorage
+	// root is the directory within the archive which holds the backup.
+	root string
+}
+
+var _ cloud.ExternalStorage = &tarGzStorage{}
+
+// IsTarGzArchiveURI returns whether uri points at a gzip-compressed tarball,
+// going by the extension of its path.
+func IsTarGzArchiveURI(uri string) bool {
+	_, _, ok := splitTarGzArchiveURI(uri)
+	return ok
+}
+
+// splitTarGzArchiveURI returns the URI of the directory which holds the
+// gzip-compressed tarball that uri points at and the name of the tarball, if
+// its path has a .tar.gz or .tgz extension.
+func splitTarGzArchiveURI(uri string) (string, string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", false
+	}
+	archive := path.Base(u.Path)
+	if !strings.HasSuffix(archive, ".tar.gz") && !strings.HasSuffix(archive, ".tgz") {
+		return "", "", false
+	}
+	u.Path = path.Dir(u.Path)
+	return u.String(), archive, true
+}
+
+// ReadBackupManifestFromTarGzURI reads the manifest of the backup archived in
+// the gzip-compressed tarball at uri, as ReadBackupManifestFromURI reads it
+// from a backup directory, for tools which inspect archived backups. A backup
+// can't be restored from its tarball, which must be expanded first.
+func ReadBackupManifestFromTarGzURI(
+	ctx context.Context,
+	uri string,
+	user security.SQLUsername,
+	makeExternalStorageFromURI cloud.ExternalStorageFromURIFactory,
+	encryption *jobspb.BackupEncryptionOptions,
+) (BackupManifest, error) {
+	dir, archive, ok := splitTarGzArchiveURI(uri)
+	if !ok {
+		return BackupManifest{}, errors.Newf("%s is not a .tar.gz archive", uri)
+	}
+	store, err := makeExternalStorageFromURI(ctx, dir, user)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	tarStore, err := makeTarGzStorage(ctx, store, archive)
+	if err != nil {
+		_ = store.Close()
+		return BackupManifest{}, err
+	}
+	defer tarStore.Close()
+	return readBackupManifestFromStore(ctx, tarStore, encryption)
+}
+
+// makeTarGzStorage returns a read-only ExternalStorage for the backup stored in
+// the tarball named archive in store. If all of the files in the archive are
+// in a single top-level directory, that directory is treated as the root of
+// the backup. On success, the returned storage takes ownership of store.
+func makeTarGzStorage(
+	ctx context.Context, store cloud.ExternalStorage, archive string,
+) (cloud.ExternalStorage, error) {
+	s := &tarGzStorage{archiveStorage: archiveStorage{ExternalStorage: store, archive: archive}}
+	tr, closeFn, err := s.openArchive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	var names []string
+	var sizes []int64
+	for {
+		name, hdr, err := nextTarFile(tr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading archive %s", archive)
+		}
+		names = append(names, name)
+		sizes = append(sizes, hdr.Size)
+	}
+
+	if len(names) > 0 {
+		if i := strings.IndexByte(names[0], '/'); i > 0 {
+			s.root = names[0][:i+1]
+			for _, name := range names {
+				if !strings.HasPrefix(name, s.root) {
+					s.root = ""
+					break
+				}
+			}
+		}
+	}
+	s.sizes = make(map[string]int64, len(names))
+	for i, name := range names {
+		s.sizes[strings.TrimPrefix(name, s.root)] = sizes[i]
+	}
+	return s, nil
+}
+
+// openArchive returns a reader over the entries of the archive, along with a
+// function which closes it.
+func (s *tarGzStorage) openArchive(ctx context.Context) (*tar.Reader, func() error, error) {
+	r, err := s.ExternalStorage.ReadFile(ctx, s.archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		_ = r.Close()
+		return nil, nil, errors.Wrapf(err, "reading archive %s", s.archive)
+	}
+	return tar.NewReader(gz), func() error {
+		_ = gz.Close()
+		return r.Close()
+	}, nil
+}
+
+// nextTarFile advances tr to the next regular file in the archive and returns
+// its name, cleaned of any leading "./", or io.EOF at the end of the archive.
+func nextTarFile(tr *tar.Reader) (string, *tar.Header, error) {
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return path.Clean(hdr.Name), hdr, nil
+		}
+	}
+}
+
+// tarEntryReader streams an entry out of the archive until it is closed.
+type tarEntryReader struct {
+	io.Reader
+	closeFn func() error
+}
+
+func (r *tarEntryReader) Close() error {
+	return r.closeFn()
+}
+
+// ReadFile is part of the cloud.ExternalStorage interface.
+func (s *tarGzStorage) ReadFile(ctx context.Context, basename string) (io.ReadCloser, error) {
+	name := path.Clean(basename)
+	if _, ok := s.sizes[name]; !ok {
+		return nil, s.errNotInArchive(basename)
+	}
+	tr, closeFn, err := s.openArchive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		entry, _, err := nextTarFile(tr)
+		if err == io.EOF {
+			_ = closeFn()
+			return nil, errors.Wrapf(cloudimpl.ErrFileDoesNotExist,
+				"archive %s no longer contains %s", s.archive, basename)
+		}
+		if err != nil {
+			_ = closeFn()
+			return nil, errors.Wrapf(err, "reading archive %s", s.archive)
+		}
+		if entry == s.root+name {
+			return &tarEntryReader{Reader: tr, closeFn: closeFn}, nil
+		}
+	}
+}
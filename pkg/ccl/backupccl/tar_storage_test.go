@@ -0,0 +1,112 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarGzStorage(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://1/", security.RootUserName())
+	require.NoError(t, err)
+
+	// Write a base backup and an appended incremental layer, then archive them
+	// in a single top-level directory, as tar would for `tar czf backup.tar.gz
+	// backup/`.
+	const incDir = "20201214/120000.00"
+	base := BackupManifest{Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1)}}
+	inc := BackupManifest{Files: []BackupManifest_File{makeTestFile("a", "b")}}
+	settings := cluster.MakeTestingClusterSettings()
+	require.NoError(t, writeBackupManifest(ctx, settings, store, "src/"+backupManifestName, nil, &base))
+	require.NoError(t, writeBackupManifest(ctx, settings, store, "src/"+incDir+"/"+backupManifestName, nil, &inc))
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./backup/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for _, name := range []string{
+		backupManifestName, backupManifestName + backupManifestChecksumSuffix,
+		incDir + "/" + backupManifestName, incDir + "/" + backupManifestName + backupManifestChecksumSuffix,
+	} {
+		r, err := store.ReadFile(ctx, "src/"+name)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: "./backup/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)),
+		}))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, store.WriteFile(ctx, "backup.tar.gz", bytes.NewReader(buf.Bytes())))
+
+	tarStore, err := makeTarGzStorage(ctx, store, "backup.tar.gz")
+	require.NoError(t, err)
+	defer tarStore.Close()
+
+	m, err := readBackupManifestFromStore(ctx, tarStore, nil)
+	require.NoError(t, err)
+	require.Equal(t, base.Descriptors, m.Descriptors)
+	// The storage can't be reconstructed from the manifest's Dir, which isn't
+	// that of the store holding the archive.
+	require.Equal(t, roachpb.ExternalStorageProvider_Unknown, m.Dir.Provider)
+
+	prev, err := findPriorBackupNames(ctx, tarStore)
+	require.NoError(t, err)
+	require.Equal(t, []string{incDir + "/" + backupManifestName}, prev)
+	m, err = readBackupManifest(ctx, tarStore, prev[0], nil)
+	require.NoError(t, err)
+	require.Equal(t, inc.Files, m.Files)
+
+	size, err := tarStore.Size(ctx, backupManifestName)
+	require.NoError(t, err)
+	require.Greater(t, size, int64(0))
+
+	_, err = tarStore.ReadFile(ctx, "missing")
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist))
+	_, err = tarStore.Size(ctx, "missing")
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist))
+	require.Error(t, tarStore.WriteFile(ctx, "new", bytes.NewReader(nil)))
+	require.Error(t, tarStore.Delete(ctx, backupManifestName))
+
+	const uri = "nodelocal://1/backup.tar.gz"
+	require.True(t, IsTarGzArchiveURI(uri))
+	require.True(t, IsTarGzArchiveURI("s3://bucket/backup.tgz?AUTH=implicit"))
+	require.False(t, IsTarGzArchiveURI("nodelocal://1/backup"))
+	m, err = ReadBackupManifestFromTarGzURI(ctx, uri, security.RootUserName(), externalStorageFromURI, nil)
+	require.NoError(t, err)
+	require.Equal(t, base.Descriptors, m.Descriptors)
+	_, err = ReadBackupManifestFromTarGzURI(ctx, "nodelocal://1/missing.tar.gz", security.RootUserName(),
+		externalStorageFromURI, nil)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+}
@@ -866,7 +866,13 @@ func selectTargets(
 	descriptorCoverage tree.DescriptorCoverage,
 	asOf hlc.Timestamp,
 ) ([]catalog.Descriptor, []catalog.DatabaseDescriptor, []descpb.TenantInfo, error) {
-	allDescs, lastBackupManifest := loadSQLDescsFromBackupsAtTime(backupManifests, asOf)
+	allDescs, lastBackupManifest, err := loadSQLDescsFromBackupsAtTime(
+		ctx, backupManifests, asOf, nil /* idRewrite */, nil, /* validate */
+		manifestValidationEnabled.Get(&p.ExecCfg().Settings.SV),
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	if descriptorCoverage == tree.AllDescriptors {
 		return fullClusterTargetsRestore(allDescs, lastBackupManifest)
@@ -0,0 +1,213 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// maxBufferedZipArchiveSize is the size of the largest zip archive which is
+// read into memory to be opened, from a store which can't read it at an offset.
+const maxBufferedZipArchiveSize = 128 << 20
+
+// zipStorage is a read-only ExternalStorage which serves the entries of a zip
+// archive, such as a support bundle produced by `cockroach debug zip`, so that
+// the backup files in it can be read without extracting them. Reading a zip
+// archive requires random access to it, so it's read at the offsets of the
+// entries read if the store holding it supports range reads, and otherwise
+// read into memory, unless it's larger than maxBufferedZipArchiveSize.
+type zipStorage struct {
+	archiveStorage
+	// files maps the cleaned name of each file in the archive to its entry.
+	files map[string]*zip.File
+	// closeArchive releases the reader of the archive.
+	closeArchive func() error
+}
+
+var _ cloud.ExternalStorage = &zipStorage{}
+
+// makeZipStorage returns a read-only ExternalStorage for the entries of the zip
+// archive named archive in store, whose reads use ctx. On success, the returned
+// storage takes ownership of store.
+func makeZipStorage(
+	ctx context.Context, store cloud.ExternalStorage, archive string,
+) (*zipStorage, error) {
+	ra, size, closeArchive, err := openZipArchive(ctx, store, archive, maxBufferedZipArchiveSize)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		_ = closeArchive()
+		return nil, errors.Wrapf(err, "reading archive %s", archive)
+	}
+	s := &zipStorage{
+		archiveStorage: archiveStorage{ExternalStorage: store, archive: archive, sizes: make(map[string]int64)},
+		files:          make(map[string]*zip.File),
+		closeArchive:   closeArchive,
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Clean(f.Name)
+		s.files[name] = f
+		s.sizes[name] = int64(f.UncompressedSize64)
+	}
+	return s, nil
+}
+
+// openZipArchive returns an io.ReaderAt over the archive named archive in
+// store, along with its size and a function which releases the reader. The
+// archive is read at the requested offsets if store is a RangeReadStorage, and
+// is otherwise read into memory, unless it's larger than maxBuffered.
+func openZipArchive(
+	ctx context.Context, store cloud.ExternalStorage, archive string, maxBuffered int64,
+) (io.ReaderAt, int64, func() error, error) {
+	size, err := store.Size(ctx, archive)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if rr, ok := store.(cloud.RangeReadStorage); ok {
+		ra := &rangeReaderAt{ctx: ctx, store: rr, name: archive}
+		return ra, size, ra.Close, nil
+	}
+	if size > maxBuffered {
+		return nil, 0, nil, errors.Newf(
+			"archive %s is too large (%s) to be read into memory; at most %s can be read from %T",
+			archive, humanizeutil.IBytes(size), humanizeutil.IBytes(maxBuffered), store)
+	}
+	r, err := store.ReadFile(ctx, archive)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer r.Close()
+	// The archive may have grown since its size was read.
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxBuffered+1))
+	if err != nil {
+		return nil, 0, nil, errors.Wrapf(err, "reading archive %s", archive)
+	}
+	if int64(len(data)) > maxBuffered {
+		return nil, 0, nil, errors.Newf("archive %s is too large to be read into memory; at most %s can be",
+			archive, humanizeutil.IBytes(maxBuffered))
+	}
+	return bytes.NewReader(data), int64(len(data)), func() error { return nil }, nil
+}
+
+// rangeReaderAt is an io.ReaderAt over the file name in store, which reads it
+// at the requested offsets with ctx. A read which starts where the previous one
+// ended continues with its reader, so that the sequential reads of an entry are
+// served by a single request. The reads are pinned to the version of the file
+// first read, if the store reports one, so that they fail rather than mix two
+// versions of it.
+type rangeReaderAt struct {
+	ctx   context.Context
+	store cloud.RangeReadStorage
+	name  string
+
+	mu struct {
+		syncutil.Mutex
+		r       io.ReadCloser
+		pos     int64
+		version string
+	}
+}
+
+var _ io.ReaderAt = &rangeReaderAt{}
+
+// ReadAt is part of the io.ReaderAt interface.
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mu.r != nil && r.mu.pos != off {
+		_ = r.mu.r.Close()
+		r.mu.r = nil
+	}
+	if r.mu.r == nil {
+		rc, version, err := r.store.ReadFileAt(r.ctx, r.name, off, r.mu.version)
+		if err != nil {
+			return 0, err
+		}
+		if r.mu.version == "" {
+			r.mu.version = version
+		}
+		r.mu.r, r.mu.pos = rc, off
+	}
+	n, err := io.ReadFull(r.mu.r, p)
+	r.mu.pos += int64(n)
+	if err != nil {
+		_ = r.mu.r.Close()
+		r.mu.r = nil
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// Close releases the reader of the last read, if any.
+func (r *rangeReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mu.r == nil {
+		return nil
+	}
+	err := r.mu.r.Close()
+	r.mu.r = nil
+	return err
+}
+
+// ReadBackupManifestFromZip reads the backup manifest at entry in the zip
+// archive named archive in store, e.g. one included in a support bundle, as
+// readBackupManifest would read it from a backup directory. The manifest's
+// checksum and compression dictionary, if it has them, are read from the
+// archive too, relative to entry.
+func ReadBackupManifestFromZip(
+	ctx context.Context,
+	store cloud.ExternalStorage,
+	archive, entry string,
+	encryption *jobspb.BackupEncryptionOptions,
+) (BackupManifest, error) {
+	zipStore, err := makeZipStorage(ctx, store, archive)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	// The caller keeps ownership of store.
+	defer zipStore.closeArchive()
+	return readBackupManifest(ctx, zipStore, path.Clean(entry), encryption)
+}
+
+// ReadFile is part of the cloud.ExternalStorage interface.
+func (s *zipStorage) ReadFile(_ context.Context, basename string) (io.ReadCloser, error) {
+	f, ok := s.files[path.Clean(basename)]
+	if !ok {
+		return nil, s.errNotInArchive(basename)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s from archive %s", basename, s.archive)
+	}
+	return r, nil
+}
+
+// Close is part of the cloud.ExternalStorage interface.
+func (s *zipStorage) Close() error {
+	return errors.CombineErrors(s.closeArchive(), s.ExternalStorage.Close())
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package backupccl
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBackupManifestFromZip(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	externalStorageFromURI, cleanup := newTestStorageFactory(t)
+	defer cleanup()
+	store, err := externalStorageFromURI(ctx, "nodelocal://1/", security.RootUserName())
+	require.NoError(t, err)
+	defer store.Close()
+
+	salt, err := storageccl.GenerateSalt()
+	require.NoError(t, err)
+	encryption := &jobspb.BackupEncryptionOptions{
+		Mode: jobspb.EncryptionMode_Passphrase, Key: storageccl.GenerateKey([]byte("abcdefg"), salt),
+	}
+	m := BackupManifest{
+		Descriptors: []descpb.Descriptor{makeTestTableDesc(52, 1)},
+		Files:       []BackupManifest_File{makeTestFile("a", "b")},
+	}
+	settings := cluster.MakeTestingClusterSettings()
+	require.NoError(t, writeBackupManifest(ctx, settings, store, "src/plain/"+backupManifestName, nil, &m))
+	require.NoError(t, writeBackupManifest(ctx, settings, store, "src/encrypted/"+backupManifestName,
+		encryption, &m))
+
+	// Bundle the manifests and their checksums, as a support bundle would.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{
+		"plain/" + backupManifestName, "plain/" + backupManifestName + backupManifestChecksumSuffix,
+		"encrypted/" + backupManifestName, "encrypted/" + backupManifestName + backupManifestChecksumSuffix,
+	} {
+		r, err := store.ReadFile(ctx, "src/"+name)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		w, err := zw.Create("debug/backups/" + name)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	// The rest of the bundle is stored uncompressed, so that the manifests
+	// aren't at the end of the archive.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "debug/nodes/1/logs.txt", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("log line\n"), 1<<10))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, store.WriteFile(ctx, "debug.zip", bytes.NewReader(buf.Bytes())))
+
+	read, err := ReadBackupManifestFromZip(ctx, store, "debug.zip",
+		"debug/backups/plain/"+backupManifestName, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, m.Descriptors, read.Descriptors)
+	require.Equal(t, m.Files, read.Files)
+
+	read, err = ReadBackupManifestFromZip(ctx, store, "debug.zip",
+		"./debug/backups/encrypted/"+backupManifestName, encryption)
+	require.NoError(t, err)
+	require.Equal(t, m.Descriptors, read.Descriptors)
+	_, err = ReadBackupManifestFromZip(ctx, store, "debug.zip",
+		"debug/backups/encrypted/"+backupManifestName, nil /* encryption */)
+	require.Error(t, err)
+
+	_, err = ReadBackupManifestFromZip(ctx, store, "debug.zip", "debug/missing", nil /* encryption */)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileDoesNotExist), "%+v", err)
+
+	zipStore, err := makeZipStorage(ctx, store, "debug.zip")
+	require.NoError(t, err)
+	files, err := zipStore.ListFiles(ctx, "debug/backups/*/"+backupManifestName)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"debug/backups/encrypted/" + backupManifestName, "debug/backups/plain/" + backupManifestName,
+	}, files)
+	size, err := zipStore.Size(ctx, files[0])
+	require.NoError(t, err)
+	require.Greater(t, size, int64(0))
+	require.Error(t, zipStore.WriteFile(ctx, "new", bytes.NewReader(nil)))
+	require.Error(t, zipStore.Delete(ctx, files[0]))
+	require.NoError(t, zipStore.closeArchive())
+
+	// Only archives up to the given size are read into memory.
+	_, _, _, err = openZipArchive(ctx, store, "debug.zip", int64(buf.Len()-1))
+	require.Error(t, err)
+
+	// An archive in a store which supports range reads is read at the offsets
+	// of its directory and entries instead, starting with the end of its
+	// directory.
+	rangeStore := rangeReadFlakyStorage{flakyStorage: &flakyStorage{ExternalStorage: store}}
+	read, err = ReadBackupManifestFromZip(ctx, rangeStore, "debug.zip",
+		"debug/backups/plain/"+backupManifestName, nil /* encryption */)
+	require.NoError(t, err)
+	require.Equal(t, m.Files, read.Files)
+	require.Greater(t, len(rangeStore.offsets), 1)
+	require.Greater(t, rangeStore.offsets[0], int64(0))
+
+	// The reads are pinned to the version of the archive first read.
+	rereads := 0
+	rangeStore = rangeReadFlakyStorage{flakyStorage: &flakyStorage{ExternalStorage: store}, beforeResume: func() {
+		if rereads++; rereads == 2 {
+			require.NoError(t, store.WriteFile(ctx, "debug.zip", bytes.NewReader(append(buf.Bytes(), 0))))
+		}
+	}}
+	_, err = ReadBackupManifestFromZip(ctx, rangeStore, "debug.zip",
+		"debug/backups/plain/"+backupManifestName, nil /* encryption */)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileChanged), "%+v", err)
+}
@@ -34,7 +34,7 @@ func init() {
 	loadShowCmd := &cobra.Command{
 		Use:   "show <basepath>",
 		Short: "show backups",
-		Long:  "Shows information about a SQL backup.",
+		Long:  "Shows information about a SQL backup, which may be archived in a .tar.gz file.",
 		RunE:  cli.MaybeDecorateGRPCError(runLoadShow),
 	}
 
@@ -70,8 +70,11 @@ func runLoadShow(cmd *cobra.Command, args []string) error {
 	// upgraded from the old FK representation, or even older formats). If more
 	// fields are added to the output, the table descriptors may need to be
 	// upgraded.
-	desc, err := backupccl.ReadBackupManifestFromURI(ctx, basepath, security.RootUserName(),
-		externalStorageFromURI, nil)
+	readManifest := backupccl.ReadBackupManifestFromURI
+	if backupccl.IsTarGzArchiveURI(basepath) {
+		readManifest = backupccl.ReadBackupManifestFromTarGzURI
+	}
+	desc, err := readManifest(ctx, basepath, security.RootUserName(), externalStorageFromURI, nil)
 	if err != nil {
 		return err
 	}
@@ -101,17 +104,36 @@ func runLoadShow(cmd *cobra.Command, args []string) error {
 	}
 	// Note that these descriptors could be from any past version of the cluster,
 	// in case more fields need to be added to the output.
+	if desc.DescriptorsElided {
+		// The full descriptor set can only be rebuilt from the backups preceding
+		// this one, which aren't read, so only the changed descriptors are shown
+		// rather than passing them off as all of them.
+		fmt.Printf("Descriptors: not stored; this incremental backup only stores " +
+			"the descriptors that changed since the previous backup\n")
+		fmt.Printf("ChangedDescriptors:\n")
+		for _, rev := range desc.DescriptorDelta {
+			if rev.Desc == nil {
+				fmt.Printf("	%d: (dropped)\n", rev.ID)
+				continue
+			}
+			printDescriptor(rev.Desc)
+		}
+		return nil
+	}
 	fmt.Printf("Descriptors:\n")
 	for i := range desc.Descriptors {
-		d := &desc.Descriptors[i]
-		if desc := descpb.TableFromDescriptor(d, hlc.Timestamp{}); desc != nil {
-			fmt.Printf("	%d: %s (table)\n",
-				descpb.GetDescriptorID(d), descpb.GetDescriptorName(d))
-		}
-		if desc := d.GetDatabase(); desc != nil {
-			fmt.Printf("	%d: %s (database)\n",
-				descpb.GetDescriptorID(d), descpb.GetDescriptorName(d))
-		}
+		printDescriptor(&desc.Descriptors[i])
 	}
 	return nil
 }
+
+func printDescriptor(d *descpb.Descriptor) {
+	if desc := descpb.TableFromDescriptor(d, hlc.Timestamp{}); desc != nil {
+		fmt.Printf("	%d: %s (table)\n",
+			descpb.GetDescriptorID(d), descpb.GetDescriptorName(d))
+	}
+	if desc := d.GetDatabase(); desc != nil {
+		fmt.Printf("	%d: %s (database)\n",
+			descpb.GetDescriptorID(d), descpb.GetDescriptorName(d))
+	}
+}
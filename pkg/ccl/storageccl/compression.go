@@ -0,0 +1,54 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package storageccl
+
+import (
+	"bytes"
+
+	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/errors"
+)
+
+// zstdMagic is the magic number with which zstd frames begin. An sstable
+// always begins with a block entry sharing no prefix with a previous key, i.e.
+// with a 0 byte, so it can't be mistaken for a zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// AppearsZstdCompressed checks if the given data appears to be compressed with
+// zstd.
+func AppearsZstdCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, zstdMagic)
+}
+
+// DecompressFile decompresses the contents of a file which were compressed,
+// after the file was written, with the given codec. Files which appear to be
+// compressed with zstd are decompressed even if no codec is specified, so that
+// files compressed by pipelines which didn't record it can still be read.
+func DecompressFile(
+	data []byte, compression roachpb.ImportRequest_File_Compression,
+) ([]byte, error) {
+	switch compression {
+	case roachpb.ImportRequest_File_None:
+		if !AppearsZstdCompressed(data) {
+			return data, nil
+		}
+	case roachpb.ImportRequest_File_Zstd:
+		if !AppearsZstdCompressed(data) {
+			return nil, errors.New("file does not appear to be compressed with zstd")
+		}
+	default:
+		return nil, errors.Errorf("unknown file compression %s", compression)
+	}
+	decompressed, err := zstd.Decompress(nil, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing zstd file")
+	}
+	return decompressed, nil
+}
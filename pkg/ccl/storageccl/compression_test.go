@@ -0,0 +1,69 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package storageccl
+
+import (
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecompressFile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var f storage.MemFile
+	w := storage.MakeBackupSSTWriter(&f)
+	defer w.Close()
+	key := storage.MVCCKey{Key: roachpb.Key("a"), Timestamp: hlc.Timestamp{WallTime: 1}}
+	require.NoError(t, w.Put(key, []byte("value")))
+	require.NoError(t, w.Finish())
+	sst := f.Data()
+	require.False(t, AppearsZstdCompressed(sst))
+
+	compressed, err := zstd.Compress(nil, sst)
+	require.NoError(t, err)
+	require.True(t, AppearsZstdCompressed(compressed))
+
+	t.Run("uncompressed", func(t *testing.T) {
+		data, err := DecompressFile(sst, roachpb.ImportRequest_File_None)
+		require.NoError(t, err)
+		require.Equal(t, sst, data)
+
+		_, err = DecompressFile(sst, roachpb.ImportRequest_File_Zstd)
+		require.EqualError(t, err, "file does not appear to be compressed with zstd")
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		for _, compression := range []roachpb.ImportRequest_File_Compression{
+			roachpb.ImportRequest_File_Zstd,
+			// The codec is detected if it wasn't recorded.
+			roachpb.ImportRequest_File_None,
+		} {
+			data, err := DecompressFile(compressed, compression)
+			require.NoError(t, err)
+			require.Equal(t, sst, data)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		_, err := DecompressFile(compressed[:len(compressed)/2], roachpb.ImportRequest_File_Zstd)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "decompressing zstd file")
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := DecompressFile(sst, roachpb.ImportRequest_File_Compression(7))
+		require.EqualError(t, err, "unknown file compression 7")
+	})
+}
@@ -214,7 +214,11 @@ func evalExport(
 			// Create a unique int differently.
 			nodeID := cArgs.EvalCtx.NodeID()
 			exported.Path = fmt.Sprintf("%d.sst", builtins.GenerateUniqueInt(base.SQLInstanceID(nodeID)))
-			if err := retry.WithMaxAttempts(ctx, base.DefaultRetryOptions(), maxUploadRetries, func() error {
+			if int64(len(data)) < args.InlineFileSizeThreshold {
+				// The caller stores small files itself, saving an object.
+				exported.Inline = true
+				exported.SST = data
+			} else if err := retry.WithMaxAttempts(ctx, base.DefaultRetryOptions(), maxUploadRetries, func() error {
 				// We blindly retry any error here because we expect the caller to have
 				// verified the target is writable before sending ExportRequests for it.
 				if err := exportStore.WriteFile(ctx, exported.Path, bytes.NewReader(data)); err != nil {
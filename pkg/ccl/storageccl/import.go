@@ -147,31 +147,38 @@ func evalImport(ctx context.Context, cArgs batcheval.CommandArgs) (*roachpb.Impo
 	for _, file := range args.Files {
 		log.VEventf(ctx, 2, "import file %s %s", file.Path, args.Key)
 
-		dir, err := cArgs.EvalCtx.GetExternalStorage(ctx, file.Dir)
-		if err != nil {
-			return nil, err
-		}
-		defer func() {
-			if err := dir.Close(); err != nil {
-				log.Warningf(ctx, "close export storage failed %v", err)
-			}
-		}()
-
-		const maxAttempts = 3
 		var fileContents []byte
-		if err := retry.WithMaxAttempts(ctx, base.DefaultRetryOptions(), maxAttempts, func() error {
-			f, err := dir.ReadFile(ctx, file.Path)
+		if len(file.InlineData) > 0 {
+			// The file was stored inline. It's decrypted in place below, so it's
+			// copied to leave the request untouched.
+			fileContents = append([]byte(nil), file.InlineData...)
+		} else {
+			dir, err := cArgs.EvalCtx.GetExternalStorage(ctx, file.Dir)
 			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				if err := dir.Close(); err != nil {
+					log.Warningf(ctx, "close export storage failed %v", err)
+				}
+			}()
+
+			const maxAttempts = 3
+			if err := retry.WithMaxAttempts(ctx, base.DefaultRetryOptions(), maxAttempts, func() error {
+				f, err := dir.ReadFile(ctx, file.Path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				fileContents, err = ioutil.ReadAll(f)
 				return err
+			}); err != nil {
+				return nil, errors.Wrapf(err, "fetching %q", file.Path)
 			}
-			defer f.Close()
-			fileContents, err = ioutil.ReadAll(f)
-			return err
-		}); err != nil {
-			return nil, errors.Wrapf(err, "fetching %q", file.Path)
+			dataSize := int64(len(fileContents))
+			log.Eventf(ctx, "fetched file (%s)", humanizeutil.IBytes(dataSize))
 		}
-		dataSize := int64(len(fileContents))
-		log.Eventf(ctx, "fetched file (%s)", humanizeutil.IBytes(dataSize))
+		var err error
 
 		if args.Encryption != nil {
 			fileContents, err = DecryptFile(fileContents, args.Encryption.Key)
@@ -180,6 +187,11 @@ func evalImport(ctx context.Context, cArgs batcheval.CommandArgs) (*roachpb.Impo
 			}
 		}
 
+		fileContents, err = DecompressFile(fileContents, file.Compression)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %q", file.Path)
+		}
+
 		if len(file.Sha512) > 0 {
 			checksum, err := SHA512ChecksumData(fileContents)
 			if err != nil {
@@ -206,6 +206,19 @@ const (
 	VirtualComputedColumns
 	// CPutInline is conditional put support for inline values.
 	CPutInline
+	// BackupInlineFiles is when backups may inline small files in their
+	// manifests, which restores of older nodes would ignore.
+	BackupInlineFiles
+	// BackupLayersIndex is when the incremental layers appended to a backup may
+	// be recorded in an index, which older nodes wouldn't maintain.
+	BackupLayersIndex
+	// BackupElidedDescriptors is when incremental backups may only store the
+	// descriptors that changed since the previous backup, which older nodes
+	// would read as an empty descriptor set.
+	BackupElidedDescriptors
+	// BackupManifestDictionary is when the manifests of incremental backups may
+	// be compressed with a dictionary, which older nodes can't decompress.
+	BackupManifestDictionary
 
 	// Step (1): Add new versions here.
 )
@@ -340,6 +353,22 @@ var versionsSingleton = keyedVersions([]keyedVersion{
 		Key:     CPutInline,
 		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 10},
 	},
+	{
+		Key:     BackupInlineFiles,
+		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 12},
+	},
+	{
+		Key:     BackupLayersIndex,
+		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 14},
+	},
+	{
+		Key:     BackupElidedDescriptors,
+		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 16},
+	},
+	{
+		Key:     BackupManifestDictionary,
+		Version: roachpb.Version{Major: 20, Minor: 2, Internal: 18},
+	},
 
 	// Step (2): Add new versions here.
 })
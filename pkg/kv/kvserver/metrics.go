@@ -391,6 +391,18 @@ var (
 		Measurement: "SSTables",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaRdbNumObsoleteSSTables = metric.Metadata{
+		Name:        "rocksdb.num-obsolete-sstables",
+		Help:        "Number of obsolete SSTables which are pending deletion",
+		Measurement: "SSTables",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRdbObsoleteSSTablesBytes = metric.Metadata{
+		Name:        "rocksdb.obsolete-sstables-bytes",
+		Help:        "Bytes in obsolete SSTables which are pending deletion",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+	}
 	metaRdbPendingCompaction = metric.Metadata{
 		Name:        "rocksdb.estimated-pending-compaction",
 		Help:        "Estimated pending compaction bytes",
@@ -1101,6 +1113,8 @@ type StoreMetrics struct {
 	RdbTableReadersMemEstimate  *metric.Gauge
 	RdbReadAmplification        *metric.Gauge
 	RdbNumSSTables              *metric.Gauge
+	RdbNumObsoleteSSTables      *metric.Gauge
+	RdbObsoleteSSTablesBytes    *metric.Gauge
 	RdbPendingCompaction        *metric.Gauge
 
 	// Disk health metrics.
@@ -1472,6 +1486,8 @@ func newStoreMetrics(histogramWindow time.Duration) *StoreMetrics {
 		RdbTableReadersMemEstimate:  metric.NewGauge(metaRdbTableReadersMemEstimate),
 		RdbReadAmplification:        metric.NewGauge(metaRdbReadAmplification),
 		RdbNumSSTables:              metric.NewGauge(metaRdbNumSSTables),
+		RdbNumObsoleteSSTables:      metric.NewGauge(metaRdbNumObsoleteSSTables),
+		RdbObsoleteSSTablesBytes:    metric.NewGauge(metaRdbObsoleteSSTablesBytes),
 		RdbPendingCompaction:        metric.NewGauge(metaRdbPendingCompaction),
 
 		// Disk health metrics.
@@ -1670,6 +1686,8 @@ func (sm *StoreMetrics) updateEngineMetrics(m storage.Metrics) {
 	sm.RdbReadAmplification.Update(m.ReadAmplification)
 	sm.RdbPendingCompaction.Update(m.PendingCompactionBytesEstimate)
 	sm.RdbNumSSTables.Update(m.NumSSTables)
+	sm.RdbNumObsoleteSSTables.Update(m.ObsoleteSSTables)
+	sm.RdbObsoleteSSTablesBytes.Update(m.ObsoleteSSTablesBytes)
 	sm.DiskSlow.Update(m.DiskSlowCount)
 	sm.DiskStalled.Update(m.DiskStallCount)
 }
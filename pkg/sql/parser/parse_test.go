@@ -1716,6 +1716,8 @@ func TestParse(t *testing.T) {
 
 		{`BACKUP TABLE foo TO 'bar' WITH revision_history, detached`},
 		{`RESTORE TABLE foo FROM 'bar' WITH skip_missing_foreign_keys, skip_missing_sequences, detached`},
+		{`RESTORE TABLE foo FROM 'bar' WITH skip_missing_views, skip_statistics, detached`},
+		{`RESTORE TABLE foo FROM 'bar' WITH skip_unreadable_files`},
 
 		{`IMPORT TABLE foo CREATE USING 'nodelocal://0/some/file' CSV DATA ('path/to/some/file', $1) WITH temp = 'path/to/temp'`},
 		{`EXPLAIN IMPORT TABLE foo CREATE USING 'nodelocal://0/some/file' CSV DATA ('path/to/some/file', $1) WITH temp = 'path/to/temp'`},
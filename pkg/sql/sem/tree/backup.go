@@ -113,6 +113,8 @@ type RestoreOptions struct {
 	SkipMissingSequences      bool
 	SkipMissingSequenceOwners bool
 	SkipMissingViews          bool
+	SkipStatistics            bool
+	SkipUnreadableFiles       bool
 	Detached                  bool
 }
 
@@ -317,6 +319,16 @@ func (o *RestoreOptions) Format(ctx *FmtCtx) {
 		ctx.WriteString("skip_missing_views")
 	}
 
+	if o.SkipStatistics {
+		maybeAddSep()
+		ctx.WriteString("skip_statistics")
+	}
+
+	if o.SkipUnreadableFiles {
+		maybeAddSep()
+		ctx.WriteString("skip_unreadable_files")
+	}
+
 	if o.Detached {
 		maybeAddSep()
 		ctx.WriteString("detached")
@@ -376,6 +388,22 @@ func (o *RestoreOptions) CombineWith(other *RestoreOptions) error {
 		o.SkipMissingViews = other.SkipMissingViews
 	}
 
+	if o.SkipStatistics {
+		if other.SkipStatistics {
+			return errors.New("skip_statistics specified multiple times")
+		}
+	} else {
+		o.SkipStatistics = other.SkipStatistics
+	}
+
+	if o.SkipUnreadableFiles {
+		if other.SkipUnreadableFiles {
+			return errors.New("skip_unreadable_files specified multiple times")
+		}
+	} else {
+		o.SkipUnreadableFiles = other.SkipUnreadableFiles
+	}
+
 	if o.Detached {
 		if other.Detached {
 			return errors.New("detached option specified multiple times")
@@ -394,6 +422,8 @@ func (o RestoreOptions) IsDefault() bool {
 		o.SkipMissingSequences == options.SkipMissingSequences &&
 		o.SkipMissingSequenceOwners == options.SkipMissingSequenceOwners &&
 		o.SkipMissingViews == options.SkipMissingViews &&
+		o.SkipStatistics == options.SkipStatistics &&
+		o.SkipUnreadableFiles == options.SkipUnreadableFiles &&
 		cmp.Equal(o.DecryptionKMSURI, options.DecryptionKMSURI) &&
 		o.EncryptionPassphrase == options.EncryptionPassphrase &&
 		o.IntoDB == options.IntoDB &&
@@ -78,6 +78,33 @@ type ExternalStorage interface {
 	Size(ctx context.Context, basename string) (int64, error)
 }
 
+// RangeReadStorage is implemented by ExternalStorage implementations which can
+// read a given version of a file starting at an offset, which allows callers to
+// resume an interrupted read without reading the file again from the start.
+type RangeReadStorage interface {
+	// ReadFileAt is like ExternalStorage.ReadFile, but returns a reader which
+	// starts at the given offset into the file, along with the version of the
+	// file it reads, e.g. its ETag, or "" if the version can't be determined. If
+	// version is not empty, the read fails with an error marked with
+	// cloudimpl.ErrFileChanged unless the file is still at that version, so
+	// that a resumed read doesn't splice together two versions of a file which
+	// was overwritten in between.
+	ReadFileAt(
+		ctx context.Context, basename string, offset int64, version string,
+	) (io.ReadCloser, string, error)
+}
+
+// PaginatedListStorage is implemented by ExternalStorage implementations which
+// list files in pages, which allows callers to process the results of a large
+// listing without holding all of them at once.
+type PaginatedListStorage interface {
+	// ListFilesPaginated is like ExternalStorage.ListFiles, but calls fn with
+	// each page of results, in the order in which they are listed, instead of
+	// returning them. If fn returns an error, the listing stops and the error is
+	// returned. The page may be reused once fn returns, so fn must not retain it.
+	ListFilesPaginated(ctx context.Context, patternSuffix string, fn func(page []string) error) error
+}
+
 // ExternalStorageFactory describes a factory function for ExternalStorage.
 type ExternalStorageFactory func(ctx context.Context, dest roachpb.ExternalStorage) (ExternalStorage, error)
 
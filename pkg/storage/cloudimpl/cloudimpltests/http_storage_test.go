@@ -32,11 +32,13 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/blobs"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/storage/cloud"
 	"github.com/cockroachdb/cockroach/pkg/storage/cloudimpl"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -276,6 +278,67 @@ func TestHttpGet(t *testing.T) {
 	}
 }
 
+func TestHttpGetFileChanged(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	data := []byte("to serve, or not to serve.  c'est la question")
+
+	// The file is served in two versions. The first request is for the first
+	// one, which is cut short; the file is then replaced by the second one.
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		start, err := rangeStart(r.Header.Get("Range"))
+		if err != nil {
+			t.Errorf("invalid range header %s", r.Header.Get("Range"))
+		}
+		etag := `"v2"`
+		if requests == 1 {
+			etag = `"v1"`
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.Header().Add("Accept-Ranges", "bytes")
+		w.Header().Add("ETag", etag)
+		w.Header().Add("Content-Length", strconv.Itoa(len(data)-start))
+		if start > 0 {
+			w.Header().Add("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+		}
+		if requests == 1 {
+			_, _ = w.Write(data[:4])
+			return
+		}
+		_, _ = w.Write(data[start:])
+	}))
+	defer s.Close()
+
+	ctx := context.Background()
+	store, err := cloudimpl.MakeHTTPStorage(s.URL, testSettings, base.ExternalIODirConfig{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	// A read interrupted by the change isn't resumed into the new version.
+	file, err := store.ReadFile(ctx, "/something")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(file)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileChanged), "%+v", err)
+	require.NoError(t, file.Close())
+
+	// Neither is a range read of the old version.
+	rr := store.(cloud.RangeReadStorage)
+	_, _, err = rr.ReadFileAt(ctx, "/something", 4, `"v1"`)
+	require.True(t, errors.Is(err, cloudimpl.ErrFileChanged), "%+v", err)
+
+	file, version, err := rr.ReadFileAt(ctx, "/something", 4, "" /* version */)
+	require.NoError(t, err)
+	require.Equal(t, `"v2"`, version)
+	b, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, data[4:], b)
+	require.NoError(t, file.Close())
+}
+
 func TestHttpGetWithCancelledContext(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -122,6 +122,11 @@ var ErrListingUnsupported = errors.New("listing is not supported")
 // This error is raised by the ReadFile method.
 var ErrFileDoesNotExist = errors.New("external_storage: file doesn't exist")
 
+// ErrFileChanged is a sentinel error for indicating that a file was overwritten
+// since the version of it which was requested. This error is raised by the
+// ReadFileAt method of cloud.RangeReadStorage implementations.
+var ErrFileChanged = errors.New("external_storage: file changed")
+
 func init() {
 	cloud.AccessIsWithExplicitAuth = AccessIsWithExplicitAuth
 }
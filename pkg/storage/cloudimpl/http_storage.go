@@ -44,6 +44,7 @@ type httpStorage struct {
 }
 
 var _ cloud.ExternalStorage = &httpStorage{}
+var _ cloud.RangeReadStorage = &httpStorage{}
 
 type retryableHTTPError struct {
 	cause error
@@ -136,8 +137,9 @@ func (h *httpStorage) Settings() *cluster.Settings {
 
 type resumingHTTPReader struct {
 	body      io.ReadCloser
-	canResume bool  // Can we resume if download aborts prematurely?
-	pos       int64 // How much data was received so far.
+	canResume bool   // Can we resume if download aborts prematurely?
+	pos       int64  // How much data was received so far.
+	version   string // The version of the file being read, see httpFileVersion.
 	ctx       context.Context
 	url       string
 	client    *httpStorage
@@ -160,10 +162,44 @@ func newResumingHTTPReader(
 	}
 
 	r.canResume = resp.Header.Get("Accept-Ranges") == "bytes"
+	r.version = httpFileVersion(resp)
 	r.body = resp.Body
 	return r, nil
 }
 
+// httpFileVersion returns the version of the file served in resp, which is its
+// ETag unless it is weak, or else its modification time, or "" if the response
+// has neither.
+func httpFileVersion(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// rangeRequestHeaders returns the headers of a request for the given version
+// of a file, as returned by httpFileVersion, starting at offset pos. If version
+// is empty, any version of the file may be served.
+func rangeRequestHeaders(pos int64, version string) map[string]string {
+	headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-", pos)}
+	if strings.HasPrefix(version, `"`) {
+		headers["If-Match"] = version
+	} else if version != "" {
+		headers["If-Unmodified-Since"] = version
+	}
+	return headers
+}
+
+// checkHTTPFileVersion returns an error marked with ErrFileChanged if resp
+// serves another version of the file than the given one, for servers which
+// don't honor the preconditions of rangeRequestHeaders.
+func checkHTTPFileVersion(resp *http.Response, version string) error {
+	if got := httpFileVersion(resp); version != "" && got != "" && got != version {
+		return errors.Wrapf(ErrFileChanged, "http storage file changed from version %s to %s", version, got)
+	}
+	return nil
+}
+
 func (r *resumingHTTPReader) Close() error {
 	if r.body != nil {
 		return r.body.Close()
@@ -229,10 +265,16 @@ func (r *resumingHTTPReader) requestNextRange() (err error) {
 
 	r.body = nil
 	var resp *http.Response
-	resp, err = r.sendRequest(map[string]string{"Range": fmt.Sprintf("bytes=%d-", r.pos)})
+	resp, err = r.sendRequest(rangeRequestHeaders(r.pos, r.version))
 
 	if err == nil {
 		err = checkHTTPContentRangeHeader(resp.Header.Get("Content-Range"), r.pos)
+		if err == nil {
+			err = checkHTTPFileVersion(resp, r.version)
+		}
+		if err != nil {
+			_ = resp.Body.Close()
+		}
 	}
 
 	if err == nil {
@@ -271,6 +313,37 @@ func (h *httpStorage) ReadFile(ctx context.Context, basename string) (io.ReadClo
 	return newResumingHTTPReader(ctx, h, basename)
 }
 
+// ReadFileAt is part of the cloud.RangeReadStorage interface. The version of a
+// file is its ETag or, if it has none, its modification time.
+func (h *httpStorage) ReadFileAt(
+	ctx context.Context, basename string, offset int64, version string,
+) (io.ReadCloser, string, error) {
+	r := &resumingHTTPReader{
+		ctx:       ctx,
+		client:    h,
+		url:       basename,
+		pos:       offset,
+		canResume: true,
+	}
+	resp, err := r.sendRequest(rangeRequestHeaders(offset, version))
+	if err != nil {
+		return nil, "", err
+	}
+	if offset > 0 {
+		err = checkHTTPContentRangeHeader(resp.Header.Get("Content-Range"), offset)
+	}
+	if err == nil {
+		err = checkHTTPFileVersion(resp, version)
+	}
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, "", err
+	}
+	r.version = httpFileVersion(resp)
+	r.body = resp.Body
+	return r, r.version, nil
+}
+
 func (h *httpStorage) WriteFile(ctx context.Context, basename string, content io.ReadSeeker) error {
 	return contextutil.RunWithTimeout(ctx, fmt.Sprintf("PUT %s", basename),
 		timeoutSetting.Get(&h.settings.SV), func(ctx context.Context) error {
@@ -367,6 +440,8 @@ func (h *httpStorage) req(
 		err := errors.Errorf("error response from server: %s %q", resp.Status, body)
 		if err != nil && resp.StatusCode == 404 {
 			err = errors.Wrapf(ErrFileDoesNotExist, "http storage file does not exist: %s", err.Error())
+		} else if err != nil && resp.StatusCode == 412 {
+			err = errors.Wrapf(ErrFileChanged, "http storage file changed: %s", err.Error())
 		}
 		return nil, err
 	}
@@ -12,6 +12,7 @@ package cloudimpl
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/url"
 	"path"
@@ -41,6 +42,8 @@ type s3Storage struct {
 }
 
 var _ cloud.ExternalStorage = &s3Storage{}
+var _ cloud.RangeReadStorage = &s3Storage{}
+var _ cloud.PaginatedListStorage = &s3Storage{}
 
 type serverSideEncMode string
 
@@ -237,44 +240,89 @@ func (s *s3Storage) WriteFile(ctx context.Context, basename string, content io.R
 
 func (s *s3Storage) ReadFile(ctx context.Context, basename string) (io.ReadCloser, error) {
 	// https://github.com/cockroachdb/cockroach/issues/23859
+	out, err := s.getObject(ctx, basename, nil /* byteRange */, "" /* version */)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// ReadFileAt is part of the cloud.RangeReadStorage interface. The version of an
+// object is its ETag.
+func (s *s3Storage) ReadFileAt(
+	ctx context.Context, basename string, offset int64, version string,
+) (io.ReadCloser, string, error) {
+	out, err := s.getObject(ctx, basename, aws.String(fmt.Sprintf("bytes=%d-", offset)), version)
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Body, aws.StringValue(out.ETag), nil
+}
+
+// getObject gets the object named basename, restricted to byteRange if it is
+// non-nil and to the given version, i.e. ETag, if it is not empty.
+func (s *s3Storage) getObject(
+	ctx context.Context, basename string, byteRange *string, version string,
+) (*s3.GetObjectOutput, error) {
 	client, err := s.newS3Client(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: s.bucket,
 		Key:    aws.String(path.Join(s.prefix, basename)),
-	})
+		Range:  byteRange,
+	}
+	if version != "" {
+		input.IfMatch = aws.String(version)
+	}
+	out, err := client.GetObjectWithContext(ctx, input)
 	if err != nil {
 		if aerr := (awserr.Error)(nil); errors.As(err, &aerr) {
 			switch aerr.Code() {
 			// Relevant 404 errors reported by AWS.
 			case s3.ErrCodeNoSuchBucket, s3.ErrCodeNoSuchKey:
 				return nil, errors.Wrapf(ErrFileDoesNotExist, "s3 object does not exist: %s", err.Error())
+			// Reported when the object no longer matches IfMatch.
+			case "PreconditionFailed":
+				return nil, errors.Wrapf(ErrFileChanged, "s3 object changed: %s", err.Error())
 			}
 		}
 		return nil, errors.Wrap(err, "failed to get s3 object")
 	}
-	return out.Body, nil
+	return out, nil
 }
 
 func (s *s3Storage) ListFiles(ctx context.Context, patternSuffix string) ([]string, error) {
 	var fileList []string
+	if err := s.ListFilesPaginated(ctx, patternSuffix, func(page []string) error {
+		fileList = append(fileList, page...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return fileList, nil
+}
 
+// ListFilesPaginated is part of the cloud.PaginatedListStorage interface.
+func (s *s3Storage) ListFilesPaginated(
+	ctx context.Context, patternSuffix string, fn func(page []string) error,
+) error {
 	pattern := s.prefix
 	if patternSuffix != "" {
 		if containsGlob(s.prefix) {
-			return nil, errors.New("prefix cannot contain globs pattern when passing an explicit pattern")
+			return errors.New("prefix cannot contain globs pattern when passing an explicit pattern")
 		}
 		pattern = path.Join(pattern, patternSuffix)
 	}
 	client, err := s.newS3Client(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var matchErr error
+	var matchErr, fnErr error
+	var fileList []string
 	err = client.ListObjectsPagesWithContext(
 		ctx,
 		&s3.ListObjectsInput{
@@ -282,6 +330,7 @@ func (s *s3Storage) ListFiles(ctx context.Context, patternSuffix string) ([]stri
 			Prefix: aws.String(getPrefixBeforeWildcard(s.prefix)),
 		},
 		func(page *s3.ListObjectsOutput, lastPage bool) bool {
+			fileList = fileList[:0]
 			for _, fileObject := range page.Contents {
 				matches, err := path.Match(pattern, *fileObject.Key)
 				if err != nil {
@@ -307,17 +356,21 @@ func (s *s3Storage) ListFiles(ctx context.Context, patternSuffix string) ([]stri
 					}
 				}
 			}
+			if len(fileList) > 0 {
+				if fnErr = fn(fileList); fnErr != nil {
+					return false
+				}
+			}
 			return !lastPage
 		},
 	)
 	if err != nil {
-		return nil, errors.Wrap(err, `failed to list s3 bucket`)
+		return errors.Wrap(err, `failed to list s3 bucket`)
 	}
 	if matchErr != nil {
-		return nil, errors.Wrap(matchErr, `failed to list s3 bucket`)
+		return errors.Wrap(matchErr, `failed to list s3 bucket`)
 	}
-
-	return fileList, nil
+	return fnErr
 }
 
 func (s *s3Storage) Delete(ctx context.Context, basename string) error {
@@ -728,6 +728,12 @@ type Metrics struct {
 	L0SublevelCount                int64
 	ReadAmplification              int64
 	NumSSTables                    int64
+	// ObsoleteSSTables and ObsoleteSSTablesBytes are the count and size of the
+	// sstables which are no longer referenced by the engine or any open
+	// iterators but have not yet been deleted. A growing backlog indicates that
+	// the deletion of obsolete files is falling behind.
+	ObsoleteSSTables      int64
+	ObsoleteSSTablesBytes int64
 }
 
 // EnvStats is a set of RocksDB env stats, including encryption status.
@@ -66,6 +66,23 @@ var MaxSyncDurationFatalOnExceeded = settings.RegisterBoolSetting(
 	maxSyncDurationFatalOnExceededDefault,
 )
 
+// CompactionLogMinDuration is the duration below which successful compactions
+// are only logged when verbose logging is enabled.
+var CompactionLogMinDuration = settings.RegisterDurationSetting(
+	"storage.pebble.compaction_log_min_duration",
+	"successful compactions that take less time than this are only logged when"+
+		" verbose logging is enabled",
+	compactionLogMinDurationDefault,
+	settings.NonNegativeDuration,
+)
+
+const (
+	compactionLogMinDurationDefault = 100 * time.Millisecond
+	// minCompactionRateDuration is the shortest compaction duration for which
+	// the output rate pebble logs for a compaction is meaningful.
+	minCompactionRateDuration = time.Millisecond
+)
+
 // EngineKeyCompare compares cockroach keys, including the version (which
 // could be MVCC timestamps).
 func EngineKeyCompare(a, b []byte) int {
@@ -534,6 +551,10 @@ func NewPebble(ctx context.Context, cfg PebbleConfig) (*Pebble, error) {
 		ctx:   logCtx,
 		depth: 2, // skip over the EventListener stack frame
 	})
+	cfg.Opts.EventListener.CompactionEnd = pebble.MakeLoggingEventListener(pebbleLogger{
+		ctx:   logCtx,
+		depth: 3, // also skip over the filterShortCompactionLogs stack frame
+	}).CompactionEnd
 	p := &Pebble{
 		path:         cfg.Dir,
 		auxDir:       auxDir,
@@ -545,6 +566,7 @@ func NewPebble(ctx context.Context, cfg PebbleConfig) (*Pebble, error) {
 		fs:           cfg.Opts.FS,
 		logger:       cfg.Opts.Logger,
 	}
+	p.filterShortCompactionLogs(logCtx, &cfg.Opts.EventListener)
 	p.connectEventMetrics(ctx, &cfg.Opts.EventListener)
 	p.eventListener = &cfg.Opts.EventListener
 	p.wrappedIntentWriter, p.useWrappedIntentWriter = tryWrapIntentWriter(p)
@@ -584,6 +606,8 @@ func newPebbleInMem(
 	return db
 }
 
+// connectEventMetrics hooks the engine's metrics up to the pebble event
+// listener.
 func (p *Pebble) connectEventMetrics(ctx context.Context, eventListener *pebble.EventListener) {
 	oldDiskSlow := eventListener.DiskSlow
 
@@ -612,6 +636,37 @@ func (p *Pebble) connectEventMetrics(ctx context.Context, eventListener *pebble.
 	}
 }
 
+// filterShortCompactionLogs wraps the CompactionEnd callback of the logging
+// event listener so that successful compactions which complete faster than
+// CompactionLogMinDuration are only logged in verbose mode. Pebble logs the
+// output rate of a compaction, which is meaningless for the trivial
+// compactions that complete in next to no time, so those are logged without
+// it. The wrapper adds a stack frame to those of the logging event listener,
+// which its logger must skip.
+func (p *Pebble) filterShortCompactionLogs(
+	ctx context.Context, eventListener *pebble.EventListener,
+) {
+	oldCompactionEnd := eventListener.CompactionEnd
+
+	eventListener.CompactionEnd = func(info pebble.CompactionInfo) {
+		minDuration := compactionLogMinDurationDefault
+		if p.settings != nil {
+			minDuration = CompactionLogMinDuration.Get(&p.settings.SV)
+		}
+		if minDuration < minCompactionRateDuration {
+			minDuration = minCompactionRateDuration
+		}
+		if info.Err != nil || info.Duration >= minDuration {
+			oldCompactionEnd(info)
+			return
+		}
+		if log.V(2) {
+			log.Storage.InfofDepth(ctx, 1, "[JOB %d] compacted(%s) to L%d in %.6fs",
+				info.JobID, redact.Safe(info.Reason), info.Output.Level, redact.Safe(info.Duration.Seconds()))
+		}
+	}
+}
+
 func (p *Pebble) String() string {
 	dir := p.path
 	if dir == "" {
@@ -935,6 +990,8 @@ func (p *Pebble) GetMetrics() (*Metrics, error) {
 		L0SublevelCount:                int64(m.Levels[0].Sublevels),
 		ReadAmplification:              int64(m.ReadAmp()),
 		NumSSTables:                    numSSTables,
+		ObsoleteSSTables:               m.Table.ObsoleteCount,
+		ObsoleteSSTablesBytes:          int64(m.Table.ObsoleteSize),
 	}, nil
 }
 
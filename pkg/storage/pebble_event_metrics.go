@@ -0,0 +1,799 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/pebble"
+)
+
+var (
+	metaEventCompactions = metric.Metadata{
+		Name:        "storage.events.compactions",
+		Help:        "Number of compactions completed by the storage engine",
+		Measurement: "Compactions",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEventIntraL0Compactions = metric.Metadata{
+		Name:        "storage.events.intra-l0-compactions",
+		Help:        "Number of intra-L0 compactions completed by the storage engine",
+		Measurement: "Compactions",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEventCompactedBytesIn = metric.Metadata{
+		Name:        "storage.events.compacted-bytes-in",
+		Help:        "Number of bytes read by completed compactions",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaEventCompactedBytesOut = metric.Metadata{
+		Name:        "storage.events.compacted-bytes-out",
+		Help:        "Number of bytes written by completed compactions",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaEventFlushes = metric.Metadata{
+		Name:        "storage.events.flushes",
+		Help:        "Number of memtable flushes completed by the storage engine",
+		Measurement: "Flushes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEventWALsCreated = metric.Metadata{
+		Name:        "storage.events.wals-created",
+		Help:        "Number of write-ahead logs created by the storage engine",
+		Measurement: "WALs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEventWALsDeleted = metric.Metadata{
+		Name:        "storage.events.wals-deleted",
+		Help:        "Number of write-ahead logs deleted by the storage engine",
+		Measurement: "WALs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEventWriteStalls = metric.Metadata{
+		Name:        "storage.events.write-stalls",
+		Help:        "Number of write stalls in the storage engine",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEventWriteStallDuration = metric.Metadata{
+		Name:        "storage.events.write-stall-duration",
+		Help:        "Duration of write stalls in the storage engine",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+)
+
+// EventMetrics are the metrics maintained by the pebble.EventListener
+// returned from MakeMetricsEventListener.
+type EventMetrics struct {
+	Compactions        *metric.Counter
+	IntraL0Compactions *metric.Counter
+	CompactedBytesIn   *metric.Counter
+	CompactedBytesOut  *metric.Counter
+	Flushes            *metric.Counter
+	WALsCreated        *metric.Counter
+	WALsDeleted        *metric.Counter
+	WriteStalls        *metric.Counter
+	WriteStallDuration *metric.Histogram
+}
+
+// MetricStruct implements the metric.Struct interface.
+func (*EventMetrics) MetricStruct() {}
+
+func makeEventMetrics() *EventMetrics {
+	return &EventMetrics{
+		Compactions:        metric.NewCounter(metaEventCompactions),
+		IntraL0Compactions: metric.NewCounter(metaEventIntraL0Compactions),
+		CompactedBytesIn:   metric.NewCounter(metaEventCompactedBytesIn),
+		CompactedBytesOut:  metric.NewCounter(metaEventCompactedBytesOut),
+		Flushes:            metric.NewCounter(metaEventFlushes),
+		WALsCreated:        metric.NewCounter(metaEventWALsCreated),
+		WALsDeleted:        metric.NewCounter(metaEventWALsDeleted),
+		WriteStalls:        metric.NewCounter(metaEventWriteStalls),
+		WriteStallDuration: metric.NewLatency(metaEventWriteStallDuration, base.DefaultHistogramWindowInterval()),
+	}
+}
+
+// MakeMetricsEventListener returns a pebble.EventListener which maintains
+// metrics for the compactions, flushes, WAL lifecycle and write stalls of an
+// engine, registering them with the given registry. The registry should be
+// specific to the engine, as each call registers a new set of metrics under
+// the same names. Failed compactions and flushes are not counted. Intra-L0
+// compactions, which rewrite tables of L0 into L0, are only counted in
+// IntraL0Compactions and their bytes aren't counted as compacted, so that they
+// don't inflate the write amplification derived from the metrics. The
+// listener only sets the callbacks it needs and can be combined with others,
+// such as the logging listener, using TeeEventListener.
+func MakeMetricsEventListener(registry *metric.Registry) (pebble.EventListener, *EventMetrics) {
+	m := makeEventMetrics()
+	registry.AddMetricStruct(m)
+
+	// stallStart holds the time at which the current write stall began, in
+	// nanoseconds, or zero if there is no stall in progress.
+	var stallStart int64
+	return pebble.EventListener{
+		CompactionEnd: func(info pebble.CompactionInfo) {
+			if info.Err != nil {
+				return
+			}
+			if isIntraL0Compaction(info) {
+				m.IntraL0Compactions.Inc(1)
+				return
+			}
+			m.Compactions.Inc(1)
+			var in uint64
+			for _, level := range info.Input {
+				for _, t := range level.Tables {
+					in += t.Size
+				}
+			}
+			var out uint64
+			for _, t := range info.Output.Tables {
+				out += t.Size
+			}
+			m.CompactedBytesIn.Inc(int64(in))
+			m.CompactedBytesOut.Inc(int64(out))
+		},
+		FlushEnd: func(info pebble.FlushInfo) {
+			if info.Err != nil {
+				return
+			}
+			m.Flushes.Inc(1)
+		},
+		WALCreated: func(info pebble.WALCreateInfo) {
+			if info.Err != nil {
+				return
+			}
+			m.WALsCreated.Inc(1)
+		},
+		WALDeleted: func(info pebble.WALDeleteInfo) {
+			if info.Err != nil {
+				return
+			}
+			m.WALsDeleted.Inc(1)
+		},
+		WriteStallBegin: func(pebble.WriteStallBeginInfo) {
+			m.WriteStalls.Inc(1)
+			atomic.StoreInt64(&stallStart, timeutil.Now().UnixNano())
+		},
+		WriteStallEnd: func() {
+			if start := atomic.SwapInt64(&stallStart, 0); start != 0 {
+				m.WriteStallDuration.RecordValue(timeutil.Now().UnixNano() - start)
+			}
+		},
+	}, m
+}
+
+// isIntraL0Compaction returns whether the compaction described by info read
+// from and wrote to L0 only. Pebble doesn't flag these compactions in
+// CompactionInfo, so they are told apart by their levels.
+func isIntraL0Compaction(info pebble.CompactionInfo) bool {
+	if info.Output.Level != 0 || len(info.Input) == 0 {
+		return false
+	}
+	for _, level := range info.Input {
+		if level.Level != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TeeEventListener returns a pebble.EventListener which invokes the callbacks
+// of both a and b, in that order. Callbacks which are nil in one of the
+// listeners are skipped for that listener.
+func TeeEventListener(a, b pebble.EventListener) pebble.EventListener {
+	// EnsureDefaults would log background errors to a nil logger, so those are
+	// given a no-op default here.
+	for _, l := range []*pebble.EventListener{&a, &b} {
+		if l.BackgroundError == nil {
+			l.BackgroundError = func(error) {}
+		}
+		l.EnsureDefaults(nil)
+	}
+	return pebble.EventListener{
+		BackgroundError: func(err error) {
+			a.BackgroundError(err)
+			b.BackgroundError(err)
+		},
+		CompactionBegin: func(info pebble.CompactionInfo) {
+			a.CompactionBegin(info)
+			b.CompactionBegin(info)
+		},
+		CompactionEnd: func(info pebble.CompactionInfo) {
+			a.CompactionEnd(info)
+			b.CompactionEnd(info)
+		},
+		DiskSlow: func(info pebble.DiskSlowInfo) {
+			a.DiskSlow(info)
+			b.DiskSlow(info)
+		},
+		FlushBegin: func(info pebble.FlushInfo) {
+			a.FlushBegin(info)
+			b.FlushBegin(info)
+		},
+		FlushEnd: func(info pebble.FlushInfo) {
+			a.FlushEnd(info)
+			b.FlushEnd(info)
+		},
+		ManifestCreated: func(info pebble.ManifestCreateInfo) {
+			a.ManifestCreated(info)
+			b.ManifestCreated(info)
+		},
+		ManifestDeleted: func(info pebble.ManifestDeleteInfo) {
+			a.ManifestDeleted(info)
+			b.ManifestDeleted(info)
+		},
+		TableCreated: func(info pebble.TableCreateInfo) {
+			a.TableCreated(info)
+			b.TableCreated(info)
+		},
+		TableDeleted: func(info pebble.TableDeleteInfo) {
+			a.TableDeleted(info)
+			b.TableDeleted(info)
+		},
+		TableIngested: func(info pebble.TableIngestInfo) {
+			a.TableIngested(info)
+			b.TableIngested(info)
+		},
+		TableStatsLoaded: func(info pebble.TableStatsInfo) {
+			a.TableStatsLoaded(info)
+			b.TableStatsLoaded(info)
+		},
+		WALCreated: func(info pebble.WALCreateInfo) {
+			a.WALCreated(info)
+			b.WALCreated(info)
+		},
+		WALDeleted: func(info pebble.WALDeleteInfo) {
+			a.WALDeleted(info)
+			b.WALDeleted(info)
+		},
+		WriteStallBegin: func(info pebble.WriteStallBeginInfo) {
+			a.WriteStallBegin(info)
+			b.WriteStallBegin(info)
+		},
+		WriteStallEnd: func() {
+			a.WriteStallEnd()
+			b.WriteStallEnd()
+		},
+	}
+}
+
+// numPebbleLevels is the number of levels in a pebble LSM.
+const numPebbleLevels = len(pebble.Metrics{}.Levels)
+
+// LevelCompactionBytes are the bytes compacted out of and into a level of the
+// LSM.
+type LevelCompactionBytes struct {
+	// In is the size of the tables of the level which were read by compactions.
+	In uint64
+	// Out is the size of the tables written to the level by compactions.
+	Out uint64
+}
+
+// LevelStats accumulates the bytes compacted per level of the LSM, as reported
+// by the pebble.EventListener returned from MakeLevelStatsEventListener.
+type LevelStats struct {
+	mu struct {
+		syncutil.Mutex
+		levels [numPebbleLevels]LevelCompactionBytes
+		// intraL0 are the bytes compacted by intra-L0 compactions, which aren't
+		// counted in levels.
+		intraL0 LevelCompactionBytes
+	}
+}
+
+// Snapshot returns the bytes compacted per level since the listener was made,
+// indexed by level.
+func (s *LevelStats) Snapshot() []LevelCompactionBytes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]LevelCompactionBytes, numPebbleLevels)
+	copy(res, s.mu.levels[:])
+	return res
+}
+
+// IntraL0 returns the bytes read from and written to L0 by intra-L0
+// compactions since the listener was made.
+func (s *LevelStats) IntraL0() LevelCompactionBytes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.intraL0
+}
+
+// MakeLevelStatsEventListener returns a pebble.EventListener which accumulates
+// the bytes read from and written to each level by completed compactions into
+// the returned LevelStats. The bytes of intra-L0 compactions are accumulated
+// separately, as they aren't compactions from one level to the next. Like
+// MakeMetricsEventListener, it can be combined with other listeners using
+// TeeEventListener.
+func MakeLevelStatsEventListener() (pebble.EventListener, *LevelStats) {
+	s := &LevelStats{}
+	return pebble.EventListener{
+		CompactionEnd: func(info pebble.CompactionInfo) {
+			if info.Err != nil {
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if isIntraL0Compaction(info) {
+				for _, level := range info.Input {
+					for _, t := range level.Tables {
+						s.mu.intraL0.In += t.Size
+					}
+				}
+				for _, t := range info.Output.Tables {
+					s.mu.intraL0.Out += t.Size
+				}
+				return
+			}
+			for _, level := range info.Input {
+				if level.Level < 0 || level.Level >= numPebbleLevels {
+					continue
+				}
+				for _, t := range level.Tables {
+					s.mu.levels[level.Level].In += t.Size
+				}
+			}
+			if l := info.Output.Level; l >= 0 && l < numPebbleLevels {
+				for _, t := range info.Output.Tables {
+					s.mu.levels[l].Out += t.Size
+				}
+			}
+		},
+	}, s
+}
+
+// compactionSample is the output of a compaction which ended at a given time.
+type compactionSample struct {
+	end      time.Time
+	bytes    uint64
+	duration time.Duration
+}
+
+// throughputWatch maintains the compaction output rate over a sliding window.
+type throughputWatch struct {
+	threshold float64
+	window    time.Duration
+	onLow     func(bytesPerSec float64)
+	now       func() time.Time
+
+	mu struct {
+		syncutil.Mutex
+		// samples are the compactions which ended within the window, ordered by
+		// the time at which they ended.
+		samples  []compactionSample
+		bytes    uint64
+		duration time.Duration
+		// low is set while the rate is below the threshold, so that onLow is
+		// only invoked when the rate drops below it.
+		low bool
+	}
+}
+
+// MakeThroughputWatchEventListener returns a pebble.EventListener which tracks
+// the output rate of the compactions completed in the trailing window, computed
+// as their total output bytes over their total duration. When that rate drops
+// below bytesPerSec, onLow is invoked with it; it is invoked again only once
+// the rate has recovered and dropped again. Compactions end on several of
+// pebble's background goroutines, so onLow is invoked with the listener's lock
+// held to serialize it, and must not block. Compactions too short for their
+// rate to be meaningful are ignored.
+func MakeThroughputWatchEventListener(
+	bytesPerSec float64, window time.Duration, onLow func(bytesPerSec float64),
+) pebble.EventListener {
+	w := &throughputWatch{threshold: bytesPerSec, window: window, onLow: onLow, now: timeutil.Now}
+	return pebble.EventListener{CompactionEnd: w.compactionEnd}
+}
+
+func (w *throughputWatch) compactionEnd(info pebble.CompactionInfo) {
+	if info.Err != nil || info.Duration < minCompactionRateDuration {
+		return
+	}
+	var out uint64
+	for _, t := range info.Output.Tables {
+		out += t.Size
+	}
+	now := w.now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.mu.samples = append(w.mu.samples, compactionSample{end: now, bytes: out, duration: info.Duration})
+	w.mu.bytes += out
+	w.mu.duration += info.Duration
+	var expired int
+	for ; expired < len(w.mu.samples) && now.Sub(w.mu.samples[expired].end) > w.window; expired++ {
+		w.mu.bytes -= w.mu.samples[expired].bytes
+		w.mu.duration -= w.mu.samples[expired].duration
+	}
+	w.mu.samples = append(w.mu.samples[:0], w.mu.samples[expired:]...)
+
+	rate := float64(w.mu.bytes) / w.mu.duration.Seconds()
+	if rate >= w.threshold {
+		w.mu.low = false
+		return
+	}
+	if !w.mu.low {
+		w.mu.low = true
+		w.onLow(rate)
+	}
+}
+
+// ReadAmplificationInfo describes the read amplification of the LSM: the
+// number of tables or sublevels which a read may have to consult.
+type ReadAmplificationInfo struct {
+	// Levels is the read amplification contributed by each level, indexed by
+	// level: the number of sublevels in L0, and one for each other non-empty
+	// level.
+	Levels [numPebbleLevels]int
+	// Total is the read amplification of the LSM as a whole.
+	Total int
+}
+
+// readAmpWatch reports the read amplification of the LSM at a bounded cadence.
+type readAmpWatch struct {
+	metrics   func() *pebble.Metrics
+	interval  time.Duration
+	onReadAmp func(ReadAmplificationInfo)
+	now       func() time.Time
+
+	// lastReport is the time at which the read amplification was last sampled,
+	// in nanoseconds.
+	lastReport int64
+	// inFlight is set while a sample is being taken.
+	inFlight int32
+}
+
+// MakeReadAmplificationEventListener returns a pebble.EventListener which
+// reports the read amplification of the LSM to onReadAmp after flushes,
+// compactions and ingestions, which are what change it, at most once per
+// interval. The read amplification is sampled from the metrics returned by
+// the metrics function, typically (*pebble.DB).Metrics, so the listener can be
+// made before the DB is opened. pebble invokes these events with its mutex
+// held, which the metrics require, so each sample is taken on a separate
+// goroutine, with at most one in flight at a time.
+func MakeReadAmplificationEventListener(
+	metrics func() *pebble.Metrics, interval time.Duration, onReadAmp func(ReadAmplificationInfo),
+) pebble.EventListener {
+	w := &readAmpWatch{metrics: metrics, interval: interval, onReadAmp: onReadAmp, now: timeutil.Now}
+	return pebble.EventListener{
+		CompactionEnd: func(pebble.CompactionInfo) { w.maybeReport() },
+		FlushEnd:      func(pebble.FlushInfo) { w.maybeReport() },
+		TableIngested: func(pebble.TableIngestInfo) { w.maybeReport() },
+	}
+}
+
+func (w *readAmpWatch) maybeReport() {
+	now := w.now().UnixNano()
+	last := atomic.LoadInt64(&w.lastReport)
+	if last != 0 && time.Duration(now-last) < w.interval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&w.inFlight, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&w.lastReport, now)
+	go func() {
+		defer atomic.StoreInt32(&w.inFlight, 0)
+		w.onReadAmp(makeReadAmplificationInfo(w.metrics()))
+	}()
+}
+
+func makeReadAmplificationInfo(m *pebble.Metrics) ReadAmplificationInfo {
+	var info ReadAmplificationInfo
+	for i := range m.Levels {
+		info.Levels[i] = int(m.Levels[i].Sublevels)
+	}
+	info.Total = m.ReadAmp()
+	return info
+}
+
+// compactionDebtLevelMultiplier is the ratio between the target sizes of
+// adjacent levels assumed by CompactionDebtEstimator, pebble's default
+// LevelMultiplier.
+const compactionDebtLevelMultiplier = 10
+
+// LevelSizeTracker maintains an approximation of the size of each level of an
+// LSM from the events of the pebble.EventListener returned by
+// MakeLevelSizeTracker, without polling the engine's metrics.
+type LevelSizeTracker struct {
+	mu struct {
+		syncutil.Mutex
+		// levels is the size of each level of the LSM, as changed by the events.
+		levels [numPebbleLevels]uint64
+	}
+}
+
+// MakeLevelSizeTracker returns a pebble.EventListener which tracks the size of
+// each level of the LSM from the tables added by flushes and ingestions and
+// moved between levels by compactions, and the LevelSizeTracker holding the
+// sizes. Only the events reporting the end of flushes and compactions are
+// used, as the begin events describe the same tables. Ingested tables are
+// added to the level each of them was ingested into. TableDeleted events are
+// ignored: tables are deleted once they are obsolete, after the compaction
+// which removed them from their level has ended.
+//
+// The sizes start out at zero when the listener is made, so tables which were
+// in the LSM before then are only ever taken into account once a compaction
+// writes them out again, and the sizes are never decreased below zero. The
+// events fire on pebble's background goroutines, so the tracker is safe for
+// concurrent use. Like MakeMetricsEventListener, the listener can be combined
+// with others using TeeEventListener.
+func MakeLevelSizeTracker() (pebble.EventListener, *LevelSizeTracker) {
+	t := &LevelSizeTracker{}
+	return t.eventListener(), t
+}
+
+func (t *LevelSizeTracker) eventListener() pebble.EventListener {
+	return pebble.EventListener{
+		CompactionEnd: t.compactionEnd,
+		FlushEnd:      t.flushEnd,
+		TableIngested: t.tableIngested,
+	}
+}
+
+// LevelSizes returns the approximate size of each level, in bytes, indexed by
+// level.
+func (t *LevelSizeTracker) LevelSizes() [numPebbleLevels]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mu.levels
+}
+
+func (t *LevelSizeTracker) compactionEnd(info pebble.CompactionInfo) {
+	if info.Err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, level := range info.Input {
+		for _, table := range level.Tables {
+			t.removeLocked(level.Level, table.Size)
+		}
+	}
+	for _, table := range info.Output.Tables {
+		t.addLocked(info.Output.Level, table.Size)
+	}
+}
+
+func (t *LevelSizeTracker) flushEnd(info pebble.FlushInfo) {
+	if info.Err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, table := range info.Output {
+		t.addLocked(0, table.Size)
+	}
+}
+
+func (t *LevelSizeTracker) tableIngested(info pebble.TableIngestInfo) {
+	if info.Err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, table := range info.Tables {
+		t.addLocked(table.Level, table.Size)
+	}
+}
+
+func (t *LevelSizeTracker) addLocked(level int, size uint64) {
+	if level >= 0 && level < numPebbleLevels {
+		t.mu.levels[level] += size
+	}
+}
+
+// removeLocked removes size bytes from the level, without going below zero,
+// since the tables may have been in the LSM before the listener was made.
+func (t *LevelSizeTracker) removeLocked(level int, size uint64) {
+	if level < 0 || level >= numPebbleLevels {
+		return
+	}
+	if size > t.mu.levels[level] {
+		size = t.mu.levels[level]
+	}
+	t.mu.levels[level] -= size
+}
+
+// CompactionDebtEstimator maintains an estimate of the compaction debt of an
+// LSM, i.e. the number of bytes which need to be compacted for the LSM to
+// reach a stable shape, from the level sizes tracked by the
+// pebble.EventListener returned by MakeCompactionDebtEstimator.
+type CompactionDebtEstimator struct {
+	sizes LevelSizeTracker
+}
+
+// MakeCompactionDebtEstimator returns a pebble.EventListener which tracks the
+// size of each level of the LSM as MakeLevelSizeTracker does, and the
+// CompactionDebtEstimator which estimates the compaction debt from them. The
+// estimator is safe for concurrent use, and the listener can be combined with
+// others using TeeEventListener.
+func MakeCompactionDebtEstimator() (pebble.EventListener, *CompactionDebtEstimator) {
+	e := &CompactionDebtEstimator{}
+	return e.sizes.eventListener(), e
+}
+
+// Debt returns the estimated compaction debt, in bytes. Every byte in L0 needs
+// to be compacted into the levels below, and each of the other levels above
+// the bottom one needs to be compacted down until it is no larger than its
+// target size, which is that of the level below it divided by the level
+// multiplier. Bytes compacted out of a level are added to the level below
+// before its excess is computed, so they are counted again for each level they
+// push over its target.
+func (e *CompactionDebtEstimator) Debt() uint64 {
+	levels := e.sizes.LevelSizes()
+
+	var targets [numPebbleLevels]uint64
+	targets[numPebbleLevels-1] = levels[numPebbleLevels-1]
+	for l := numPebbleLevels - 2; l > 0; l-- {
+		targets[l] = targets[l+1] / compactionDebtLevelMultiplier
+	}
+	debt := levels[0]
+	carry := levels[0]
+	for l := 1; l < numPebbleLevels-1; l++ {
+		size := levels[l] + carry
+		carry = 0
+		if size > targets[l] {
+			carry = size - targets[l]
+		}
+		debt += carry
+	}
+	return debt
+}
+
+// LoggedEventType identifies a high-frequency event of a pebble.EventListener
+// whose logging can be configured in LoggingEventListenerOptions.
+type LoggedEventType int
+
+const (
+	// LoggedCompactionBegin is the CompactionBegin event.
+	LoggedCompactionBegin LoggedEventType = iota
+	// LoggedCompactionEnd is the CompactionEnd event.
+	LoggedCompactionEnd
+	// LoggedFlushBegin is the FlushBegin event.
+	LoggedFlushBegin
+	// LoggedFlushEnd is the FlushEnd event.
+	LoggedFlushEnd
+	// LoggedTableCreated is the TableCreated event.
+	LoggedTableCreated
+	// LoggedTableDeleted is the TableDeleted event.
+	LoggedTableDeleted
+	// LoggedTableIngested is the TableIngested event.
+	LoggedTableIngested
+	// LoggedWALCreated is the WALCreated event.
+	LoggedWALCreated
+	// LoggedWALDeleted is the WALDeleted event.
+	LoggedWALDeleted
+)
+
+// EventLogLevel is the level at which an event is logged by the listener
+// returned from MakeLoggingEventListenerWithOptions.
+type EventLogLevel int
+
+const (
+	// EventLogInfo logs the event, as pebble.MakeLoggingEventListener does.
+	EventLogInfo EventLogLevel = iota
+	// EventLogVerbose logs the event only in verbose mode, i.e. when log.V(2).
+	EventLogVerbose
+	// EventLogOff doesn't log the event.
+	EventLogOff
+)
+
+// LoggingEventListenerOptions configures the listener returned from
+// MakeLoggingEventListenerWithOptions. The zero value logs every event, like
+// pebble.MakeLoggingEventListener.
+type LoggingEventListenerOptions struct {
+	// Levels is the level at which each type of event is logged. Types which
+	// aren't in it are logged at EventLogInfo.
+	Levels map[LoggedEventType]EventLogLevel
+	// SampleEvery logs only one in every n events of each type in it, starting
+	// with the first one. Values below 2 log every event.
+	SampleEvery map[LoggedEventType]int
+}
+
+// MakeLoggingEventListenerWithOptions returns a pebble.EventListener which logs
+// events to logger like pebble.MakeLoggingEventListener, but with the level and
+// sampling rate of its high-frequency events set by opts, so that busy engines
+// don't flood the logs. The events which are rare and significant, i.e. those
+// about background errors, slow disks, manifests, table stats and write
+// stalls, aren't configurable and are always logged, and so are the
+// compactions, flushes and ingestions which failed. The callbacks of the
+// listener add a stack frame to those of pebble's logging listener, which a
+// logger reporting its caller's location should skip.
+func MakeLoggingEventListenerWithOptions(
+	logger pebble.Logger, opts LoggingEventListenerOptions,
+) pebble.EventListener {
+	l := pebble.MakeLoggingEventListener(logger)
+	shouldLog := func(typ LoggedEventType) func(failed bool) bool {
+		level := opts.Levels[typ]
+		every := int64(opts.SampleEvery[typ])
+		var count int64
+		return func(failed bool) bool {
+			if failed {
+				return true
+			}
+			switch level {
+			case EventLogOff:
+				return false
+			case EventLogVerbose:
+				if !log.V(2) {
+					return false
+				}
+			}
+			return every < 2 || (atomic.AddInt64(&count, 1)-1)%every == 0
+		}
+	}
+
+	compactionBegin, compactionEnd := l.CompactionBegin, l.CompactionEnd
+	logCompactionBegin, logCompactionEnd := shouldLog(LoggedCompactionBegin), shouldLog(LoggedCompactionEnd)
+	l.CompactionBegin = func(info pebble.CompactionInfo) {
+		if logCompactionBegin(info.Err != nil) {
+			compactionBegin(info)
+		}
+	}
+	l.CompactionEnd = func(info pebble.CompactionInfo) {
+		if logCompactionEnd(info.Err != nil) {
+			compactionEnd(info)
+		}
+	}
+	flushBegin, flushEnd := l.FlushBegin, l.FlushEnd
+	logFlushBegin, logFlushEnd := shouldLog(LoggedFlushBegin), shouldLog(LoggedFlushEnd)
+	l.FlushBegin = func(info pebble.FlushInfo) {
+		if logFlushBegin(info.Err != nil) {
+			flushBegin(info)
+		}
+	}
+	l.FlushEnd = func(info pebble.FlushInfo) {
+		if logFlushEnd(info.Err != nil) {
+			flushEnd(info)
+		}
+	}
+	tableCreated, tableDeleted, tableIngested := l.TableCreated, l.TableDeleted, l.TableIngested
+	logTableCreated, logTableDeleted := shouldLog(LoggedTableCreated), shouldLog(LoggedTableDeleted)
+	logTableIngested := shouldLog(LoggedTableIngested)
+	l.TableCreated = func(info pebble.TableCreateInfo) {
+		if logTableCreated(false) {
+			tableCreated(info)
+		}
+	}
+	l.TableDeleted = func(info pebble.TableDeleteInfo) {
+		if logTableDeleted(info.Err != nil) {
+			tableDeleted(info)
+		}
+	}
+	l.TableIngested = func(info pebble.TableIngestInfo) {
+		if logTableIngested(info.Err != nil) {
+			tableIngested(info)
+		}
+	}
+	walCreated, walDeleted := l.WALCreated, l.WALDeleted
+	logWALCreated, logWALDeleted := shouldLog(LoggedWALCreated), shouldLog(LoggedWALDeleted)
+	l.WALCreated = func(info pebble.WALCreateInfo) {
+		if logWALCreated(info.Err != nil) {
+			walCreated(info)
+		}
+	}
+	l.WALDeleted = func(info pebble.WALDeleteInfo) {
+		if logWALDeleted(info.Err != nil) {
+			walDeleted(info)
+		}
+	}
+	return l
+}
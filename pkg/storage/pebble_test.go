@@ -19,6 +19,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -28,11 +29,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
 
@@ -298,6 +302,442 @@ func TestPebbleDiskSlowEmit(t *testing.T) {
 	require.Equal(t, uint64(1), p.diskStallCount)
 }
 
+func TestMetricsEventListener(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	registry := metric.NewRegistry()
+	metricsListener, m := MakeMetricsEventListener(registry)
+	var flushes int
+	eventListener := TeeEventListener(metricsListener, pebble.EventListener{
+		FlushEnd: func(pebble.FlushInfo) { flushes++ },
+	})
+
+	names := map[string]bool{}
+	registry.Each(func(name string, _ interface{}) { names[name] = true })
+	require.True(t, names[metaEventCompactions.Name])
+	require.True(t, names[metaEventWriteStallDuration.Name])
+
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input: []pebble.LevelInfo{
+			{Level: 0, Tables: []pebble.TableInfo{{Size: 10}, {Size: 20}}},
+			{Level: 1, Tables: []pebble.TableInfo{{Size: 30}}},
+		},
+		Output: pebble.LevelInfo{Level: 1, Tables: []pebble.TableInfo{{Size: 50}}},
+		Done:   true,
+	})
+	eventListener.CompactionEnd(pebble.CompactionInfo{Err: errors.New("boom")})
+	// Intra-L0 compactions are counted apart from the others.
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input:  []pebble.LevelInfo{{Level: 0, Tables: []pebble.TableInfo{{Size: 5}, {Size: 5}}}},
+		Output: pebble.LevelInfo{Level: 0, Tables: []pebble.TableInfo{{Size: 9}}},
+		Done:   true,
+	})
+	require.Equal(t, int64(1), m.Compactions.Count())
+	require.Equal(t, int64(1), m.IntraL0Compactions.Count())
+	require.Equal(t, int64(60), m.CompactedBytesIn.Count())
+	require.Equal(t, int64(50), m.CompactedBytesOut.Count())
+
+	eventListener.FlushEnd(pebble.FlushInfo{Done: true})
+	eventListener.WALCreated(pebble.WALCreateInfo{})
+	eventListener.WALCreated(pebble.WALCreateInfo{})
+	eventListener.WALDeleted(pebble.WALDeleteInfo{})
+	require.Equal(t, int64(1), m.Flushes.Count())
+	require.Equal(t, 1, flushes)
+	require.Equal(t, int64(2), m.WALsCreated.Count())
+	require.Equal(t, int64(1), m.WALsDeleted.Count())
+
+	// Callbacks the metrics listener doesn't set must still be callable.
+	eventListener.TableCreated(pebble.TableCreateInfo{})
+	eventListener.BackgroundError(errors.New("boom"))
+
+	eventListener.WriteStallBegin(pebble.WriteStallBeginInfo{Reason: "memtable count limit reached"})
+	eventListener.WriteStallEnd()
+	// An unmatched end must not record a duration.
+	eventListener.WriteStallEnd()
+	require.Equal(t, int64(1), m.WriteStalls.Count())
+	require.Equal(t, int64(1), m.WriteStallDuration.TotalCount())
+}
+
+func TestLevelStatsEventListener(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	eventListener, stats := MakeLevelStatsEventListener()
+	require.Equal(t, make([]LevelCompactionBytes, numPebbleLevels), stats.Snapshot())
+
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input: []pebble.LevelInfo{
+			{Level: 0, Tables: []pebble.TableInfo{{Size: 10}, {Size: 20}}},
+			{Level: 2, Tables: []pebble.TableInfo{{Size: 30}}},
+		},
+		Output: pebble.LevelInfo{Level: 2, Tables: []pebble.TableInfo{{Size: 55}}},
+		Done:   true,
+	})
+	snapshot := stats.Snapshot()
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input: []pebble.LevelInfo{
+			{Level: 2, Tables: []pebble.TableInfo{{Size: 100}}},
+			{Level: 3, Tables: []pebble.TableInfo{{Size: 200}}},
+		},
+		Output: pebble.LevelInfo{Level: 3, Tables: []pebble.TableInfo{{Size: 290}}},
+		Done:   true,
+	})
+	// Failed compactions are not counted.
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input:  []pebble.LevelInfo{{Level: 5, Tables: []pebble.TableInfo{{Size: 1}}}},
+		Output: pebble.LevelInfo{Level: 6},
+		Err:    errors.New("boom"),
+	})
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input:  []pebble.LevelInfo{{Level: 0, Tables: []pebble.TableInfo{{Size: 40}, {Size: 2}}}},
+		Output: pebble.LevelInfo{Level: 0, Tables: []pebble.TableInfo{{Size: 41}}},
+		Done:   true,
+	})
+	require.Equal(t, LevelCompactionBytes{In: 42, Out: 41}, stats.IntraL0())
+
+	expected := make([]LevelCompactionBytes, numPebbleLevels)
+	expected[0] = LevelCompactionBytes{In: 30}
+	expected[2] = LevelCompactionBytes{In: 130, Out: 55}
+	expected[3] = LevelCompactionBytes{In: 200, Out: 290}
+	require.Equal(t, expected, stats.Snapshot())
+	// Earlier snapshots are not affected by later compactions.
+	require.Equal(t, LevelCompactionBytes{In: 30, Out: 55}, snapshot[2])
+}
+
+func TestCompactionDebtEstimator(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	eventListener, e := MakeCompactionDebtEstimator()
+	require.Equal(t, uint64(0), e.Debt())
+
+	flush := func(size uint64) {
+		eventListener.FlushEnd(pebble.FlushInfo{Output: []pebble.TableInfo{{Size: size}}, Done: true})
+	}
+	compaction := func(inLevel int, in uint64, outLevel int, out uint64) {
+		eventListener.CompactionEnd(pebble.CompactionInfo{
+			Input:  []pebble.LevelInfo{{Level: inLevel, Tables: []pebble.TableInfo{{Size: in}}}},
+			Output: pebble.LevelInfo{Level: outLevel, Tables: []pebble.TableInfo{{Size: out}}},
+			Done:   true,
+		})
+	}
+
+	// With nothing below it, what is flushed to L0 has to be compacted through
+	// every level.
+	flush(100)
+	require.Equal(t, uint64(600), e.Debt())
+	compaction(0, 100, 6, 100)
+	require.Equal(t, uint64(0), e.Debt())
+
+	// The bottom level now allows for 10 bytes in L5 and 1 in L4.
+	flush(50)
+	require.Equal(t, uint64(50+50+50+50+49+39), e.Debt())
+	ingestion := pebble.TableIngestInfo{}
+	ingestion.Tables = append(ingestion.Tables, struct {
+		pebble.TableInfo
+		Level int
+	}{TableInfo: pebble.TableInfo{Size: 30}, Level: 6})
+	eventListener.TableIngested(ingestion)
+	require.Equal(t, uint64(50+50+50+50+49+36), e.Debt())
+
+	// Compacting tables from before the estimator was made doesn't drive a level
+	// below zero.
+	compaction(3, 1000, 4, 5)
+	require.Equal(t, uint64(50+50+50+50+54+41), e.Debt())
+
+	// Failed events are ignored.
+	eventListener.FlushEnd(pebble.FlushInfo{Output: []pebble.TableInfo{{Size: 1}}, Err: errors.New("boom")})
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input: []pebble.LevelInfo{{Level: 0, Tables: []pebble.TableInfo{{Size: 50}}}},
+		Err:   errors.New("boom"),
+	})
+	require.Equal(t, uint64(50+50+50+50+54+41), e.Debt())
+
+	// Events are delivered from several goroutines.
+	eventListener, e = MakeCompactionDebtEstimator()
+	const goroutines, flushes = 4, 100
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < flushes; j++ {
+				eventListener.FlushEnd(pebble.FlushInfo{Output: []pebble.TableInfo{{Size: 1}}, Done: true})
+				_ = e.Debt()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, uint64(6*goroutines*flushes), e.Debt())
+}
+
+func TestLevelSizeTracker(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	eventListener, tracker := MakeLevelSizeTracker()
+	// The callbacks the tracker doesn't set are no-ops, as pebble makes them.
+	eventListener.EnsureDefaults(nil /* logger */)
+	require.Equal(t, [numPebbleLevels]uint64{}, tracker.LevelSizes())
+
+	tables := func(sizes ...uint64) []pebble.TableInfo {
+		var res []pebble.TableInfo
+		for _, size := range sizes {
+			res = append(res, pebble.TableInfo{Size: size})
+		}
+		return res
+	}
+	// Begin events describe the same tables as the end events, so they're
+	// ignored.
+	eventListener.FlushBegin(pebble.FlushInfo{Output: tables(100)})
+	eventListener.FlushEnd(pebble.FlushInfo{Output: tables(40, 60), Done: true})
+	eventListener.FlushEnd(pebble.FlushInfo{Output: tables(1000), Err: errors.New("boom")})
+	eventListener.TableIngested(pebble.TableIngestInfo{Tables: []struct {
+		pebble.TableInfo
+		Level int
+	}{
+		{TableInfo: pebble.TableInfo{Size: 7}, Level: 0},
+		{TableInfo: pebble.TableInfo{Size: 300}, Level: 6},
+	}})
+	eventListener.CompactionBegin(pebble.CompactionInfo{
+		Input: []pebble.LevelInfo{{Level: 0, Tables: tables(107)}},
+	})
+	eventListener.CompactionEnd(pebble.CompactionInfo{
+		Input: []pebble.LevelInfo{
+			{Level: 0, Tables: tables(40, 60)},
+			// Tables from before the tracker was made don't make sizes negative.
+			{Level: 5, Tables: tables(50)},
+		},
+		Output: pebble.LevelInfo{Level: 5, Tables: tables(140)},
+		Done:   true,
+	})
+	// Tables are deleted after the compactions which obsoleted them.
+	eventListener.TableDeleted(pebble.TableDeleteInfo{FileNum: 1})
+
+	expected := [numPebbleLevels]uint64{}
+	expected[0] = 7
+	expected[5] = 140
+	expected[6] = 300
+	require.Equal(t, expected, tracker.LevelSizes())
+
+	// The tracker is safe for concurrent use.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				eventListener.FlushEnd(pebble.FlushInfo{Output: tables(1), Done: true})
+				_ = tracker.LevelSizes()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, uint64(407), tracker.LevelSizes()[0])
+}
+
+func TestThroughputWatchEventListener(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var rates []float64
+	w := &throughputWatch{
+		threshold: 100,
+		window:    time.Minute,
+		onLow:     func(rate float64) { rates = append(rates, rate) },
+	}
+	now := timeutil.Unix(1000, 0)
+	w.now = func() time.Time { return now }
+	compaction := func(bytes uint64, d time.Duration) pebble.CompactionInfo {
+		return pebble.CompactionInfo{
+			Output:   pebble.LevelInfo{Level: 1, Tables: []pebble.TableInfo{{Size: bytes}}},
+			Duration: d,
+			Done:     true,
+		}
+	}
+
+	w.compactionEnd(compaction(1000, time.Second))
+	require.Empty(t, rates)
+	// 1100 bytes in 11 seconds is at the threshold.
+	now = now.Add(10 * time.Second)
+	w.compactionEnd(compaction(100, 10*time.Second))
+	require.Empty(t, rates)
+	// 1110 bytes in 21 seconds is below it.
+	now = now.Add(10 * time.Second)
+	w.compactionEnd(compaction(10, 10*time.Second))
+	require.Len(t, rates, 1)
+	require.InDelta(t, 1110.0/21, rates[0], 1e-9)
+	// The rate stays low without the callback being invoked again.
+	w.compactionEnd(compaction(10, 10*time.Second))
+	require.Len(t, rates, 1)
+	// Failed and very short compactions are ignored.
+	w.compactionEnd(pebble.CompactionInfo{Duration: time.Hour, Err: errors.New("boom")})
+	w.compactionEnd(compaction(0, time.Microsecond))
+
+	// Once the slow compactions age out of the window the rate recovers, and a
+	// later drop invokes the callback again.
+	now = now.Add(2 * time.Minute)
+	w.compactionEnd(compaction(1000, time.Second))
+	require.Len(t, rates, 1)
+	now = now.Add(time.Second)
+	w.compactionEnd(compaction(0, 10*time.Second))
+	require.Len(t, rates, 2)
+	require.InDelta(t, 1000.0/11, rates[1], 1e-9)
+	require.Len(t, w.mu.samples, 2)
+
+	// Compactions may end concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.compactionEnd(compaction(1000, time.Second))
+		}()
+	}
+	wg.Wait()
+	require.Len(t, w.mu.samples, 12)
+}
+
+func TestPebbleFilterShortCompactionLogs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	settings := cluster.MakeTestingClusterSettings()
+	p := &Pebble{settings: settings}
+	var logged []pebble.CompactionInfo
+	eventListener := pebble.EventListener{
+		CompactionEnd: func(info pebble.CompactionInfo) { logged = append(logged, info) },
+	}
+	p.filterShortCompactionLogs(context.Background(), &eventListener)
+
+	// Zero-duration compactions never reach the logging listener, which would
+	// report an infinite output rate for them, even if the setting is zero.
+	eventListener.CompactionEnd(pebble.CompactionInfo{JobID: 1})
+	CompactionLogMinDuration.Override(&settings.SV, 0)
+	eventListener.CompactionEnd(pebble.CompactionInfo{JobID: 2})
+	require.Empty(t, logged)
+
+	eventListener.CompactionEnd(pebble.CompactionInfo{JobID: 3, Duration: time.Millisecond})
+	CompactionLogMinDuration.Override(&settings.SV, time.Second)
+	eventListener.CompactionEnd(pebble.CompactionInfo{JobID: 4, Duration: 500 * time.Millisecond})
+	eventListener.CompactionEnd(pebble.CompactionInfo{JobID: 5, Duration: 2 * time.Second})
+	// Failed compactions are always logged.
+	eventListener.CompactionEnd(pebble.CompactionInfo{JobID: 6, Err: errors.New("boom")})
+
+	var jobs []int
+	for _, info := range logged {
+		jobs = append(jobs, info.JobID)
+	}
+	require.Equal(t, []int{3, 5, 6}, jobs)
+}
+
+type recordingPebbleLogger struct {
+	logged []string
+}
+
+func (l *recordingPebbleLogger) Infof(format string, args ...interface{}) {
+	l.logged = append(l.logged, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingPebbleLogger) Fatalf(format string, args ...interface{}) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+func TestLoggingEventListenerWithOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	logger := &recordingPebbleLogger{}
+	l := MakeLoggingEventListenerWithOptions(logger, LoggingEventListenerOptions{
+		Levels: map[LoggedEventType]EventLogLevel{
+			LoggedCompactionEnd: EventLogOff,
+			LoggedFlushEnd:      EventLogVerbose,
+		},
+		SampleEvery: map[LoggedEventType]int{
+			LoggedTableCreated: 3,
+			LoggedWALCreated:   1,
+		},
+	})
+
+	// One in every three table creations is logged, starting with the first.
+	for i := 1; i <= 7; i++ {
+		l.TableCreated(pebble.TableCreateInfo{JobID: i, Reason: "flushing", FileNum: pebble.FileNum(i)})
+	}
+	require.Len(t, logger.logged, 3)
+	for _, i := range []int{1, 4, 7} {
+		require.Contains(t, strings.Join(logger.logged, "\n"), fmt.Sprintf("sstable created %06d", i))
+	}
+
+	// Disabled events aren't logged unless they failed, and neither are verbose
+	// ones outside of verbose mode.
+	logger.logged = nil
+	l.CompactionEnd(pebble.CompactionInfo{JobID: 1, Done: true})
+	l.FlushEnd(pebble.FlushInfo{JobID: 2, Done: true})
+	require.Empty(t, logger.logged)
+	l.CompactionEnd(pebble.CompactionInfo{JobID: 3, Done: true, Err: errors.New("boom")})
+	require.Len(t, logger.logged, 1)
+
+	// Events which aren't configured are logged in full.
+	logger.logged = nil
+	for i := 0; i < 3; i++ {
+		l.CompactionBegin(pebble.CompactionInfo{JobID: i})
+		l.WALCreated(pebble.WALCreateInfo{JobID: i, FileNum: pebble.FileNum(i)})
+		l.ManifestCreated(pebble.ManifestCreateInfo{JobID: i, FileNum: pebble.FileNum(i)})
+		l.WriteStallBegin(pebble.WriteStallBeginInfo{Reason: "memtable count limit reached"})
+		l.WriteStallEnd()
+		l.BackgroundError(errors.New("boom"))
+	}
+	require.Len(t, logger.logged, 18)
+}
+
+func TestReadAmplificationEventListener(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var m pebble.Metrics
+	m.Levels[0].Sublevels = 3
+	m.Levels[6].Sublevels = 1
+	reports := make(chan ReadAmplificationInfo, 10)
+	w := &readAmpWatch{
+		metrics:   func() *pebble.Metrics { return &m },
+		interval:  time.Minute,
+		onReadAmp: func(info ReadAmplificationInfo) { reports <- info },
+	}
+	now := timeutil.Unix(1000, 0)
+	w.now = func() time.Time { return now }
+
+	w.maybeReport()
+	info := <-reports
+	require.Equal(t, 4, info.Total)
+	require.Equal(t, 3, info.Levels[0])
+	require.Equal(t, 0, info.Levels[1])
+	require.Equal(t, 1, info.Levels[6])
+
+	// Reports are made at most once per interval.
+	now = now.Add(time.Second)
+	w.maybeReport()
+	now = now.Add(time.Minute)
+	w.maybeReport()
+	require.Equal(t, 4, (<-reports).Total)
+	require.Len(t, reports, 0)
+
+	// The listener may sample the metrics of the DB which invokes it.
+	var db *pebble.DB
+	dbReports := make(chan ReadAmplificationInfo, 10)
+	opts := &pebble.Options{FS: vfs.NewMem()}
+	opts.EventListener = MakeReadAmplificationEventListener(
+		func() *pebble.Metrics { return db.Metrics() }, time.Minute,
+		func(info ReadAmplificationInfo) { dbReports <- info },
+	)
+	db, err := pebble.Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, db.Flush())
+	require.Equal(t, 1, (<-dbReports).Total)
+	require.NoError(t, db.Close())
+}
+
 func BenchmarkMVCCKeyCompare(b *testing.B) {
 	rng := rand.New(rand.NewSource(timeutil.Now().Unix()))
 	keys := make([][]byte, 1000)
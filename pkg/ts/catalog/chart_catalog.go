@@ -2123,6 +2123,15 @@ var charts = []sectionDescription{
 				Title:   "Count",
 				Metrics: []string{"rocksdb.num-sstables"},
 			},
+			{
+				Title:   "Obsolete Count",
+				Metrics: []string{"rocksdb.num-obsolete-sstables"},
+			},
+			{
+				Title:     "Obsolete Size",
+				Metrics:   []string{"rocksdb.obsolete-sstables-bytes"},
+				AxisLabel: "Bytes",
+			},
 			{
 				Title: "Ingestions",
 				Metrics: []string{